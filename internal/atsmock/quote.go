@@ -0,0 +1,62 @@
+package atsmock
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Level 一档报价
+type Level struct {
+	QuoteOrderNo string
+	BrokerID     string
+	Price        float64
+	Yield        float64
+	OrderQty     float64
+}
+
+// OrderBookMessage 构造一条 BOND_ORDER_BOOK_MSG 推送消息体
+// 格式与生产推送一致：外层 data.data 为内层报价JSON的字符串
+func OrderBookMessage(messageID, securityID string, ts time.Time, bids, asks []Level) []byte {
+	millis := ts.UnixMilli()
+
+	levels := func(side string, list []Level) []map[string]any {
+		out := make([]map[string]any, 0, len(list))
+		for _, l := range list {
+			out = append(out, map[string]any{
+				"brokerId":         l.BrokerID,
+				"isTbd":            "N",
+				"isValid":          "Y",
+				"minTransQuantity": 1000000,
+				"orderQty":         l.OrderQty,
+				"price":            l.Price,
+				"quoteOrderNo":     l.QuoteOrderNo,
+				"quoteTime":        millis,
+				"securityId":       securityID,
+				"settleType":       "T2",
+				"side":             side,
+				"yield":            l.Yield,
+			})
+		}
+		return out
+	}
+
+	inner, _ := json.Marshal(map[string]any{
+		"askPrices":  levels("ASK", asks),
+		"bidPrices":  levels("BID", bids),
+		"securityId": securityID,
+	})
+
+	body, _ := json.Marshal(map[string]any{
+		"data": map[string]any{
+			"data":         string(inner),
+			"messageId":    messageID,
+			"messageType":  "BOND_ORDER_BOOK_MSG",
+			"organization": "AF",
+			"receiverId":   "30021",
+			"timestamp":    millis,
+		},
+		"sendTime":      millis,
+		"wsMessageType": "ATS_QUOTE",
+	})
+	return body
+}
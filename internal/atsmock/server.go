@@ -0,0 +1,633 @@
+// Package atsmock 本地模拟亚丁ATS网关，用于端到端测试
+//
+// 模拟内容：
+// 1. 登录接口 /cust-gateway/cust-auth/account/outApi/doLogin，使用与生产一致的RSA+AES加密信封
+// 2. WebSocket + STOMP 行情推送，目的地 /user/queue/v1/apiatsbondquote/messages
+// 3. 按脚本推送 BOND_ORDER_BOOK_MSG 消息、断开连接、令牌过期、发送错误帧等故障注入
+package atsmock
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	utils "wealth-bond-quote-service/pkg/crypto_utils"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	LoginPath   = "/cust-gateway/cust-auth/account/outApi/doLogin"
+	WsPath      = "/message-gateway/message/atsapi/ws"
+	Destination = "/user/queue/v1/apiatsbondquote/messages"
+)
+
+// Server 模拟ATS网关
+type Server struct {
+	*httptest.Server
+
+	PublicKey string // Base64编码的PKIX公钥，对应 AdenATSConfig.PublicKey
+	Username  string
+	Password  string
+
+	priv     *rsa.PrivateKey
+	upgrader websocket.Upgrader
+	mux      *http.ServeMux
+
+	mu         sync.Mutex
+	tokens     map[string]bool
+	sessions   map[*session]struct{}
+	logins     int
+	subscribes int
+	acks       []string
+	nacks      []string
+	changed    chan struct{} // 状态变化通知，供 Wait* 使用
+}
+
+// NewServer 启动模拟网关（明文HTTP/WS），默认账号 test/test
+func NewServer() *Server {
+	s := newServer()
+	s.Server.Start()
+	return s
+}
+
+// NewTLSServer 启动HTTPS/WSS模拟网关
+// 证书由 httptest 签发，可通过 Certificate() 获取用于配置CA
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server.StartTLS()
+	return s
+}
+
+func newServer() *Server {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{
+		PublicKey: base64.StdEncoding.EncodeToString(pubDER),
+		Username:  "test",
+		Password:  "test",
+		priv:      priv,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{"v12.stomp", "v11.stomp", "v10.stomp"},
+		},
+		tokens:   make(map[string]bool),
+		sessions: make(map[*session]struct{}),
+		changed:  make(chan struct{}),
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc(LoginPath, s.handleLogin)
+	s.mux.HandleFunc(WsPath, s.handleWs)
+	s.Server = httptest.NewUnstartedServer(s.mux)
+	return s
+}
+
+// BaseURL 登录接口基础地址，对应 AdenATSConfig.BaseURL
+func (s *Server) BaseURL() string {
+	return s.URL
+}
+
+// WssURL WebSocket地址，对应 AdenATSConfig.WssURL
+func (s *Server) WssURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + WsPath
+}
+
+// Close 断开所有连接并关闭服务
+func (s *Server) Close() {
+	s.DropConnections()
+	s.Server.Close()
+}
+
+// Logins 成功登录次数
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Subscribes 累计订阅次数
+func (s *Server) Subscribes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscribes
+}
+
+// Acks 收到的ACK帧id
+func (s *Server) Acks() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.acks...)
+}
+
+// Nacks 收到的NACK帧id
+func (s *Server) Nacks() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.nacks...)
+}
+
+// WaitSubscribes 等待累计订阅次数达到n
+func (s *Server) WaitSubscribes(n int, timeout time.Duration) error {
+	return s.waitFor(timeout, func() bool { return s.subscribes >= n })
+}
+
+// WaitAcks 等待累计ACK+NACK数量达到n
+func (s *Server) WaitAcks(n int, timeout time.Duration) error {
+	return s.waitFor(timeout, func() bool { return len(s.acks)+len(s.nacks) >= n })
+}
+
+func (s *Server) waitFor(timeout time.Duration, cond func() bool) error {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		ok := cond()
+		ch := s.changed
+		s.mu.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return fmt.Errorf("等待超时(%s)", timeout)
+		}
+	}
+}
+
+// notifyLocked 唤醒等待者，调用方需持有 s.mu
+func (s *Server) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// ExpireTokens 使所有已发放的token失效
+// 之后的WebSocket握手返回401，已建立的会话收到ERROR帧后被断开
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	s.tokens = make(map[string]bool)
+	sessions := s.sessionsLocked()
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.sendError("token expired", `{"code":401,"msg":"token已失效，请重新登录"}`)
+		sess.close()
+	}
+}
+
+// DropConnections 直接断开所有WebSocket连接，模拟网络闪断
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	sessions := s.sessionsLocked()
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.close()
+	}
+}
+
+// Kill 模拟网关宕机：断开所有连接并停止监听，之后登录和握手均连接失败
+// 地址保持不变，可用 Restart 在原地址恢复
+func (s *Server) Kill() {
+	s.DropConnections()
+	s.Server.Close()
+}
+
+// Restart 在原地址重新启动已 Kill 的网关（明文HTTP/WS），已发放的token仍然有效
+func (s *Server) Restart() error {
+	l, err := net.Listen("tcp", s.Listener.Addr().String())
+	if err != nil {
+		return fmt.Errorf("重新监听%s失败: %w", s.Listener.Addr(), err)
+	}
+	srv := httptest.NewUnstartedServer(s.mux)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	s.Server = srv
+	return nil
+}
+
+// SendError 向所有会话发送STOMP ERROR帧
+func (s *Server) SendError(message, body string) {
+	s.mu.Lock()
+	sessions := s.sessionsLocked()
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.sendError(message, body)
+	}
+}
+
+// Push 向所有已订阅会话推送一条消息，返回收到消息的会话数
+func (s *Server) Push(body []byte) int {
+	s.mu.Lock()
+	sessions := s.sessionsLocked()
+	s.mu.Unlock()
+
+	n := 0
+	for _, sess := range sessions {
+		if sess.push(body) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// PushRaw 向所有会话直接写一条WebSocket文本消息，用于注入畸形帧
+func (s *Server) PushRaw(data []byte) {
+	s.mu.Lock()
+	sessions := s.sessionsLocked()
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.writeRaw(data)
+	}
+}
+
+// PushScript 按间隔依次推送脚本中的消息
+func (s *Server) PushScript(bodies [][]byte, interval time.Duration) {
+	for _, body := range bodies {
+		s.Push(body)
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
+func (s *Server) sessionsLocked() []*session {
+	list := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		list = append(list, sess)
+	}
+	return list
+}
+
+// ---------- 登录 ----------
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	SmsCode  string `json:"code"`
+}
+
+type encryptedRequest struct {
+	ReqMsg   string `json:"reqMsg"`
+	ReqKey   string `json:"reqKey"`
+	ClientId string `json:"clientId"`
+}
+
+type encryptedResponse struct {
+	ResMsg string `json:"resMsg"`
+	ResKey string `json:"resKey"`
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req encryptedRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plain, err := s.decryptRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var login loginRequest
+	if err := json.Unmarshal(plain, &login); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := map[string]any{"code": 500, "msg": "用户名或密码错误"}
+	if login.Username == s.Username && login.Password == s.Password {
+		token := strings.ReplaceAll(uuid.New().String(), "-", "")
+		s.mu.Lock()
+		s.tokens[token] = true
+		s.logins++
+		s.notifyLocked()
+		s.mu.Unlock()
+		resp = map[string]any{"code": 200, "msg": "登录成功", "data": token}
+	}
+
+	out, err := s.encryptResponse(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// APIHandler 模拟业务接口：入参为解密后的请求体，返回 code、msg、data
+type APIHandler func(req json.RawMessage) (code int, msg string, data any)
+
+// HandleAPI 注册需要登录的加密业务接口
+// 请求头 token 无效时返回 code=401，不调用 handler
+func (s *Server) HandleAPI(path string, handler APIHandler) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		var req encryptedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		plain, err := s.decryptRequest(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := map[string]any{"code": 401, "msg": "token已失效，请重新登录"}
+		if s.validToken(r.Header.Get("token")) {
+			code, msg, data := handler(plain)
+			resp = map[string]any{"code": code, "msg": msg, "data": data}
+		}
+		out, err := s.encryptResponse(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	})
+}
+
+// decryptRequest 私钥解出AES密钥，再用AES-ECB解密请求体
+func (s *Server) decryptRequest(req encryptedRequest) ([]byte, error) {
+	encKey, err := base64.StdEncoding.DecodeString(req.ReqKey)
+	if err != nil {
+		return nil, fmt.Errorf("reqKey解码失败: %w", err)
+	}
+	aesKeyB64, err := rsa.DecryptPKCS1v15(rand.Reader, s.priv, encKey)
+	if err != nil {
+		return nil, fmt.Errorf("RSA解密失败: %w", err)
+	}
+	aesKey, err := base64.StdEncoding.DecodeString(string(aesKeyB64))
+	if err != nil {
+		return nil, fmt.Errorf("AES密钥解码失败: %w", err)
+	}
+	return utils.AesDecryptECB(req.ReqMsg, aesKey)
+}
+
+// encryptResponse AES-ECB加密响应体，AES密钥用私钥"加密"（PKCS#1 v1.5签名填充），客户端用公钥还原
+func (s *Server) encryptResponse(v any) ([]byte, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	keyB64 := base64.StdEncoding.EncodeToString(key)
+
+	resMsg, err := utils.AesEncrypt(string(plain), keyB64)
+	if err != nil {
+		return nil, err
+	}
+	resKey, err := rsa.SignPKCS1v15(nil, s.priv, crypto.Hash(0), []byte(keyB64))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encryptedResponse{
+		ResMsg: resMsg,
+		ResKey: base64.StdEncoding.EncodeToString(resKey),
+	})
+}
+
+// ---------- WebSocket + STOMP ----------
+
+func (s *Server) validToken(token string) bool {
+	token = strings.TrimPrefix(token, "Bearer ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[token]
+}
+
+func (s *Server) handleWs(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = r.Header.Get("token")
+	}
+	if !s.validToken(token) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	sess := &session{server: s, conn: conn}
+	sess.writer = frame.NewWriter(wsWriter{sess})
+
+	s.mu.Lock()
+	s.sessions[sess] = struct{}{}
+	s.notifyLocked()
+	s.mu.Unlock()
+
+	sess.serve()
+
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	s.notifyLocked()
+	s.mu.Unlock()
+}
+
+// session 单个WebSocket上的STOMP会话
+type session struct {
+	server *Server
+	conn   *websocket.Conn
+
+	mu      sync.Mutex // 保护 writer 和订阅信息
+	writer  *frame.Writer
+	subID   string
+	ackMode string
+	seq     int
+	closed  bool
+}
+
+func (sess *session) serve() {
+	defer sess.close()
+	reader := frame.NewReader(&wsReader{conn: sess.conn})
+	for {
+		f, err := reader.Read()
+		if err != nil {
+			return
+		}
+		if f == nil {
+			continue // 心跳
+		}
+
+		switch f.Command {
+		case frame.CONNECT, frame.STOMP:
+			if !sess.server.validToken(f.Header.Get("token")) {
+				sess.sendError("token invalid", `{"code":401,"msg":"token无效"}`)
+				return
+			}
+			sess.write(frame.New(frame.CONNECTED,
+				frame.Version, "1.2",
+				frame.HeartBeat, "0,0",
+				frame.Server, "atsmock/1.0"))
+
+		case frame.SUBSCRIBE:
+			if dest := f.Header.Get(frame.Destination); dest != Destination {
+				sess.sendError("unknown destination", dest)
+				return
+			}
+			sess.mu.Lock()
+			sess.subID = f.Header.Get(frame.Id)
+			sess.ackMode = f.Header.Get(frame.Ack)
+			sess.mu.Unlock()
+			// 与生产网关一致：订阅不回RECEIPT
+			sess.server.mu.Lock()
+			sess.server.subscribes++
+			sess.server.notifyLocked()
+			sess.server.mu.Unlock()
+
+		case frame.UNSUBSCRIBE:
+			sess.mu.Lock()
+			sess.subID = ""
+			sess.mu.Unlock()
+
+		case frame.ACK, frame.NACK:
+			id := f.Header.Get(frame.Id)
+			if id == "" {
+				id = f.Header.Get(frame.MessageId)
+			}
+			sess.server.mu.Lock()
+			if f.Command == frame.ACK {
+				sess.server.acks = append(sess.server.acks, id)
+			} else {
+				sess.server.nacks = append(sess.server.nacks, id)
+			}
+			sess.server.notifyLocked()
+			sess.server.mu.Unlock()
+
+		case frame.DISCONNECT:
+			if receipt := f.Header.Get(frame.Receipt); receipt != "" {
+				sess.write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+			}
+			return
+		}
+	}
+}
+
+func (sess *session) write(f *frame.Frame) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return io.ErrClosedPipe
+	}
+	return sess.writer.Write(f)
+}
+
+func (sess *session) writeRaw(data []byte) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return io.ErrClosedPipe
+	}
+	return sess.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (sess *session) push(body []byte) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed || sess.subID == "" {
+		return io.ErrClosedPipe
+	}
+	sess.seq++
+	msgID := fmt.Sprintf("%s-%d", sess.subID, sess.seq)
+	f := frame.New(frame.MESSAGE,
+		frame.Destination, Destination,
+		frame.Subscription, sess.subID,
+		frame.MessageId, msgID,
+		frame.ContentType, "text/plain;charset=UTF-8",
+		"message-type", "ATS_QUOTE")
+	if sess.ackMode != "" && sess.ackMode != "auto" {
+		f.Header.Set(frame.Ack, msgID)
+	}
+	f.Body = body
+	return sess.writer.Write(f)
+}
+
+func (sess *session) sendError(message, body string) {
+	f := frame.New(frame.ERROR, frame.Message, message)
+	f.Body = []byte(body)
+	sess.write(f)
+}
+
+func (sess *session) close() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return
+	}
+	sess.closed = true
+	sess.conn.Close()
+}
+
+// wsReader 把连续的WebSocket消息拼成字节流供STOMP帧解析
+type wsReader struct {
+	conn *websocket.Conn
+	cur  io.Reader
+}
+
+func (r *wsReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			_, next, err := r.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			r.cur = next
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// wsWriter 每次Write作为一条WebSocket文本消息发送，调用方需持有 session.mu
+type wsWriter struct {
+	sess *session
+}
+
+func (w wsWriter) Write(p []byte) (int, error) {
+	if err := w.sess.conn.WriteMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,228 @@
+// Package calendar 交易日历
+// 按时区、交易日（星期）、交易时段和休市日期判断某一时刻是否处于交易时段，
+// 并按日切时间计算某一时刻所属的业务日期
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+const (
+	defaultTimezone = "Asia/Shanghai"
+	dateLayout      = "2006-01-02"
+	minutesPerDay   = 24 * 60
+)
+
+// window 一天内的交易时段，单位：距零点的分钟数，左闭右开
+type window struct {
+	start, end int
+}
+
+// Calendar 交易日历
+type Calendar struct {
+	loc      *time.Location
+	weekdays map[time.Weekday]bool
+	sessions []window
+	holidays map[string]bool
+	cutoff   int // 日切时间，距零点的分钟数，0 表示按自然日
+}
+
+// New 根据配置创建交易日历
+// 未配置时默认：Asia/Shanghai，周一至周五全天开市
+func New(cfg *config.CalendarConfig) (*Calendar, error) {
+	tz := cfg.Timezone
+	if tz == "" {
+		tz = defaultTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("加载时区失败 %s: %w", tz, err)
+	}
+
+	c := &Calendar{
+		loc:      loc,
+		weekdays: make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+	}
+
+	weekdays := cfg.Weekdays
+	if len(weekdays) == 0 {
+		weekdays = []int{1, 2, 3, 4, 5}
+	}
+	for _, d := range weekdays {
+		if d < 0 || d > 6 {
+			return nil, fmt.Errorf("交易日配置错误: %d（0=周日 ... 6=周六）", d)
+		}
+		c.weekdays[time.Weekday(d)] = true
+	}
+
+	for _, s := range cfg.Sessions {
+		w, err := parseWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		c.sessions = append(c.sessions, w)
+	}
+	if len(c.sessions) == 0 {
+		c.sessions = []window{{0, minutesPerDay}}
+	}
+	sort.Slice(c.sessions, func(i, j int) bool { return c.sessions[i].start < c.sessions[j].start })
+
+	holidays := cfg.Holidays
+	if cfg.HolidayFile != "" {
+		list, err := readHolidayFile(cfg.HolidayFile)
+		if err != nil {
+			return nil, err
+		}
+		holidays = append(list, holidays...)
+	}
+	for _, h := range holidays {
+		d, err := time.ParseInLocation(dateLayout, strings.TrimSpace(h), loc)
+		if err != nil {
+			return nil, fmt.Errorf("休市日期格式错误 %q: %w", h, err)
+		}
+		c.holidays[d.Format(dateLayout)] = true
+	}
+
+	if cfg.CutoffTime != "" {
+		cutoff, err := parseClock(cfg.CutoffTime)
+		if err != nil || cutoff == minutesPerDay {
+			return nil, fmt.Errorf("日切时间格式错误 %q，应为 HH:MM", cfg.CutoffTime)
+		}
+		c.cutoff = cutoff
+	}
+	return c, nil
+}
+
+// readHolidayFile 读取休市日期文件，每行一个日期，忽略空行和 # 开头的注释
+func readHolidayFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取休市日期文件失败: %w", err)
+	}
+	defer f.Close()
+	var list []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, s); err != nil {
+			return nil, fmt.Errorf("休市日期文件 %s 第%d行格式错误 %q", path, line, s)
+		}
+		list = append(list, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取休市日期文件失败: %w", err)
+	}
+	return list, nil
+}
+
+// parseWindow 解析 "09:00-12:00" 形式的交易时段，结束时间可写 24:00
+func parseWindow(s string) (window, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 2 {
+		return window{}, fmt.Errorf("交易时段格式错误 %q，应为 HH:MM-HH:MM", s)
+	}
+	start, err1 := parseClock(parts[0])
+	end, err2 := parseClock(parts[1])
+	if err1 != nil || err2 != nil || start >= end {
+		return window{}, fmt.Errorf("交易时段格式错误 %q，应为 HH:MM-HH:MM", s)
+	}
+	return window{start, end}, nil
+}
+
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &h, &m); err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > minutesPerDay {
+		return 0, fmt.Errorf("时间超出范围: %s", s)
+	}
+	return h*60 + m, nil
+}
+
+// Location 日历时区
+func (c *Calendar) Location() *time.Location {
+	return c.loc
+}
+
+// IsTradingDay 是否为交易日（按日历时区的自然日判断）
+func (c *Calendar) IsTradingDay(t time.Time) bool {
+	t = t.In(c.loc)
+	return c.weekdays[t.Weekday()] && !c.holidays[t.Format(dateLayout)]
+}
+
+// IsOpen 是否处于交易时段
+func (c *Calendar) IsOpen(t time.Time) bool {
+	_, ok := c.SessionStart(t)
+	return ok
+}
+
+// SessionStart 返回t所在交易时段的开始时间；不在交易时段时返回false
+func (c *Calendar) SessionStart(t time.Time) (time.Time, bool) {
+	if !c.IsTradingDay(t) {
+		return time.Time{}, false
+	}
+	t = t.In(c.loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.loc)
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range c.sessions {
+		if minute >= w.start && minute < w.end {
+			return midnight.Add(time.Duration(w.start) * time.Minute), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// BusinessDate 返回t所属的业务日期（日历时区的零点）
+// 日切时间及之后计入下一天，非交易日顺延到下一个交易日
+func (c *Calendar) BusinessDate(t time.Time) time.Time {
+	t = t.In(c.loc)
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.loc)
+	if c.cutoff > 0 && t.Hour()*60+t.Minute() >= c.cutoff {
+		d = d.AddDate(0, 0, 1)
+	}
+	return c.NextTradingDay(d)
+}
+
+// NextTradingDay 返回t当天或之后的第一个交易日（零点）；一年内没有交易日时返回t当天
+func (c *Calendar) NextTradingDay(t time.Time) time.Time {
+	t = t.In(c.loc)
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.loc)
+	for i := 0; i <= 366; i++ {
+		if day := d.AddDate(0, 0, i); c.IsTradingDay(day) {
+			return day
+		}
+	}
+	return d
+}
+
+// BusinessDayRange 返回业务日期d包含的时间范围 [start, end)：从上一交易日的日切时间到d的日切时间
+// 未配置日切时间时以零点为界，两个交易日之间的非交易日计入后一个交易日
+func (c *Calendar) BusinessDayRange(d time.Time) (start, end time.Time) {
+	d = d.In(c.loc)
+	d = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, c.loc)
+	prev := d.AddDate(0, 0, -1)
+	for i := 0; i < 366 && !c.IsTradingDay(prev); i++ {
+		prev = prev.AddDate(0, 0, -1)
+	}
+	return c.dayEnd(prev), c.dayEnd(d)
+}
+
+// dayEnd 自然日d的业务日结束时刻：日切时间，未配置时为次日零点
+func (c *Calendar) dayEnd(d time.Time) time.Time {
+	if c.cutoff > 0 {
+		return time.Date(d.Year(), d.Month(), d.Day(), 0, c.cutoff, 0, 0, c.loc)
+	}
+	return time.Date(d.Year(), d.Month(), d.Day()+1, 0, 0, 0, 0, c.loc)
+}
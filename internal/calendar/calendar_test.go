@@ -0,0 +1,149 @@
+package calendar
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+func TestCalendar(t *testing.T) {
+	cal, err := New(&config.CalendarConfig{
+		Timezone: "Asia/Shanghai",
+		Sessions: []string{"13:00-17:00", "09:00-12:00"},
+		Holidays: []string{"2025-10-01"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := cal.Location()
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		wantOpen  bool
+		wantStart string
+	}{
+		{"周二上午", time.Date(2025, 9, 30, 10, 15, 0, 0, loc), true, "09:00"},
+		{"周二午休", time.Date(2025, 9, 30, 12, 30, 0, 0, loc), false, ""},
+		{"周二下午", time.Date(2025, 9, 30, 16, 59, 0, 0, loc), true, "13:00"},
+		{"收市时刻", time.Date(2025, 9, 30, 17, 0, 0, 0, loc), false, ""},
+		{"夜间", time.Date(2025, 9, 30, 23, 0, 0, 0, loc), false, ""},
+		{"休市日", time.Date(2025, 10, 1, 10, 0, 0, 0, loc), false, ""},
+		{"周六", time.Date(2025, 10, 4, 10, 0, 0, 0, loc), false, ""},
+		// 按日历时区判断：UTC 01:30 即上海 09:30
+		{"其他时区", time.Date(2025, 9, 30, 1, 30, 0, 0, time.UTC), true, "09:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, open := cal.SessionStart(tt.at)
+			if open != tt.wantOpen {
+				t.Fatalf("开市 = %v, 期望 %v", open, tt.wantOpen)
+			}
+			if open && start.Format("15:04") != tt.wantStart {
+				t.Fatalf("时段开始 = %s, 期望 %s", start.Format("15:04"), tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestCalendarDefaults(t *testing.T) {
+	cal, err := New(&config.CalendarConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := cal.Location()
+	if !cal.IsOpen(time.Date(2025, 9, 30, 3, 0, 0, 0, loc)) {
+		t.Fatal("默认交易日应全天开市")
+	}
+	if cal.IsOpen(time.Date(2025, 10, 5, 12, 0, 0, 0, loc)) {
+		t.Fatal("默认周日休市")
+	}
+}
+
+func TestCalendarConfigErrors(t *testing.T) {
+	bad := []*config.CalendarConfig{
+		{Timezone: "Mars/Olympus"},
+		{Weekdays: []int{7}},
+		{Sessions: []string{"09:00"}},
+		{Sessions: []string{"12:00-09:00"}},
+		{Sessions: []string{"09:00-25:00"}},
+		{Holidays: []string{"2025/10/01"}},
+		{HolidayFile: "missing.txt"},
+		{CutoffTime: "17"},
+		{CutoffTime: "24:00"},
+	}
+	for _, cfg := range bad {
+		if _, err := New(cfg); err == nil {
+			t.Fatalf("期望配置错误: %+v", cfg)
+		}
+	}
+}
+
+func TestCalendarBusinessDate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "holidays.txt")
+	if err := os.WriteFile(file, []byte("# 国庆\n2025-10-01\n\n2025-10-02\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cal, err := New(&config.CalendarConfig{
+		Timezone:    "Asia/Hong_Kong",
+		Holidays:    []string{"2025-10-03"},
+		HolidayFile: file,
+		CutoffTime:  "17:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := cal.Location()
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"日切前", time.Date(2025, 9, 29, 16, 59, 59, 0, loc), "2025-09-29"},
+		{"日切时刻", time.Date(2025, 9, 29, 17, 0, 0, 0, loc), "2025-09-30"},
+		// 节前最后一个交易日日切后顺延到节后第一个交易日（10-01、10-02 来自文件）
+		{"节前日切后", time.Date(2025, 9, 30, 18, 0, 0, 0, loc), "2025-10-06"},
+		{"休市日", time.Date(2025, 10, 2, 10, 0, 0, 0, loc), "2025-10-06"},
+		{"周日", time.Date(2025, 10, 5, 10, 0, 0, 0, loc), "2025-10-06"},
+		// 按日历时区判断：UTC 09:30 即香港 17:30
+		{"其他时区", time.Date(2025, 10, 6, 9, 30, 0, 0, time.UTC), "2025-10-07"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := cal.BusinessDate(tt.at)
+			if got := d.Format(dateLayout); got != tt.want || d.Location() != loc || d.Hour() != 0 {
+				t.Fatalf("业务日期 = %s, 期望 %s", d, tt.want)
+			}
+		})
+	}
+
+	// 业务日期的时间范围与 BusinessDate 一致
+	for _, day := range []string{"2025-09-29", "2025-10-06", "2025-10-07"} {
+		d, _ := time.ParseInLocation(dateLayout, day, loc)
+		start, end := cal.BusinessDayRange(d)
+		if cal.BusinessDate(start).Format(dateLayout) != day || cal.BusinessDate(end.Add(-time.Nanosecond)).Format(dateLayout) != day ||
+			cal.BusinessDate(start.Add(-time.Nanosecond)).Format(dateLayout) == day || cal.BusinessDate(end).Format(dateLayout) == day {
+			t.Fatalf("%s 范围 [%s, %s)", day, start, end)
+		}
+	}
+	// 节后第一个交易日包含节前最后一个交易日日切之后的全部时间
+	if start, _ := cal.BusinessDayRange(time.Date(2025, 10, 6, 0, 0, 0, 0, loc)); !start.Equal(time.Date(2025, 9, 30, 17, 0, 0, 0, loc)) {
+		t.Fatalf("节后首日开始于 %s", start)
+	}
+
+	// 未配置日切时间按自然日
+	natural, err := New(&config.CalendarConfig{Timezone: "Asia/Hong_Kong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := natural.BusinessDate(time.Date(2025, 9, 29, 23, 59, 0, 0, loc)).Format(dateLayout); got != "2025-09-29" {
+		t.Fatalf("自然日业务日期 = %s", got)
+	}
+	if start, end := natural.BusinessDayRange(time.Date(2025, 9, 30, 0, 0, 0, 0, loc)); !start.Equal(time.Date(2025, 9, 30, 0, 0, 0, 0, loc)) || !end.Equal(time.Date(2025, 10, 1, 0, 0, 0, 0, loc)) {
+		t.Fatalf("自然日范围 [%s, %s)", start, end)
+	}
+}
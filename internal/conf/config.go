@@ -28,17 +28,52 @@ type LogCfg struct {
 
 // AdenATSConfig 亚丁ATS系统配置
 type AdenATSConfig struct {
-	BaseURL              string `yaml:"baseURL"`
-	WssURL               string `yaml:"wssURL"`
-	Username             string `yaml:"username"`
-	Password             string `yaml:"password"`
-	SmsCode              string `yaml:"smsCode"`
-	ClientId             string `yaml:"clientId"`
-	PublicKey            string `yaml:"publicKey"`
-	Timeout              int    `yaml:"timeout"`
-	Heartbeat            int    `yaml:"heartbeat"`
-	ReconnectInterval    int    `yaml:"reconnectInterval"`
-	MaxReconnectAttempts int    `yaml:"maxReconnectAttempts"`
+	BaseURL              string       `yaml:"baseURL"`
+	WssURL               string       `yaml:"wssURL"`
+	Username             string       `yaml:"username"`
+	Password             string       `yaml:"password"`
+	SmsCode              string       `yaml:"smsCode"`
+	ClientId             string       `yaml:"clientId"`
+	PublicKey            string       `yaml:"publicKey"`
+	Timeout              int          `yaml:"timeout"`
+	Heartbeat            int          `yaml:"heartbeat"`
+	ReconnectInterval    int          `yaml:"reconnectInterval"`
+	MaxReconnectAttempts int          `yaml:"maxReconnectAttempts"`
+	LoginMinInterval     int          `yaml:"loginMinInterval"` // 两次登录最小间隔（秒）
+	MaxLoginsPerHour     int          `yaml:"maxLoginsPerHour"` // 每小时最多登录次数，防止账号被锁
+	AckMode              string       `yaml:"ackMode"`          // 订阅确认模式：auto（默认）/ client-individual（写库后确认）
+	SnapshotPath         string       `yaml:"snapshotPath"`     // 全量报价快照接口路径，重连后用于修正最新行情，为空时只标记未确认
+	TLS                  ATSTLSConfig `yaml:"tls"`
+
+	// 多网关故障切换：按顺序排列，第一个为主网关；为空时只使用上面的 baseURL/wssURL
+	Endpoints         []ATSEndpointConfig `yaml:"endpoints"`
+	HealthPath        string              `yaml:"healthPath"`        // 健康探测路径（GET baseURL+healthPath，HTTP状态码<500视为可用），默认 /
+	FailoverThreshold int                 `yaml:"failoverThreshold"` // 同一网关连续失败次数达到后切换到下一个，默认2；探测不可用时立即切换
+	FailbackInterval  int                 `yaml:"failbackInterval"`  // 使用备用网关时探测更高优先级网关的间隔（秒），默认60
+}
+
+// ATSEndpointConfig 单个ATS网关，账号相关字段为空时沿用 AdenATSConfig 中的配置
+type ATSEndpointConfig struct {
+	Name       string `yaml:"name"`
+	BaseURL    string `yaml:"baseURL"`
+	WssURL     string `yaml:"wssURL"`
+	HealthPath string `yaml:"healthPath"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	SmsCode    string `yaml:"smsCode"`
+	ClientId   string `yaml:"clientId"`
+	PublicKey  string `yaml:"publicKey"`
+}
+
+// ATSTLSConfig 亚丁ATS连接的TLS配置，登录接口和WebSocket共用
+type ATSTLSConfig struct {
+	CAFile     string   `yaml:"caFile"`     // 自定义CA证书（PEM），为空时使用系统根证书
+	CertFile   string   `yaml:"certFile"`   // 客户端证书（PEM），双向TLS时配置
+	KeyFile    string   `yaml:"keyFile"`    // 客户端私钥（PEM）
+	PinnedSPKI []string `yaml:"pinnedSPKI"` // 证书公钥指纹：SPKI的SHA256（Base64），证书链中任一证书匹配即通过
+	ServerName string   `yaml:"serverName"` // 覆盖SNI和证书校验使用的主机名
+	// DevInsecureSkipVerify 跳过证书校验，仅限本地开发联调，生产环境禁止开启
+	DevInsecureSkipVerify bool `yaml:"devInsecureSkipVerify"`
 }
 
 // DataProcessConfig 数据处理配置
@@ -51,6 +86,33 @@ type DataProcessConfig struct {
 	DbWorkerNum      int `yaml:"dbWorkerNum"`
 	BatchSize        int `yaml:"batchSize"`
 	FlushDelayMs     int `yaml:"flushDelayMs"`
+	ShutdownTimeout  int `yaml:"shutdownTimeout"` // 优雅关闭的截止时间（秒），默认30
+
+	RawOverflow    OverflowConfig `yaml:"rawOverflow"`    // 原始消息通道满时的处理策略
+	ParsedOverflow OverflowConfig `yaml:"parsedOverflow"` // 解析结果通道满时的处理策略
+
+	Sinks []SinkConfig `yaml:"sinks"` // 行情写入目标，为空时只写入MySQL
+}
+
+// SinkConfig 行情写入目标配置
+type SinkConfig struct {
+	Type         string `yaml:"type"`         // mysql / sqlite / jsonl / memory / lifecycle（报价生命周期事件）
+	Name         string `yaml:"name"`         // 日志和统计中的名称，默认同类型
+	Path         string `yaml:"path"`         // sqlite 数据库文件或 jsonl 输出目录
+	Required     bool   `yaml:"required"`     // 必需的目标写入失败时拒绝消息等待重新投递；非必需的目标失败只记录日志
+	WorkerNum    int    `yaml:"workerNum"`    // 写入协程数，默认沿用 workerNum
+	BatchSize    int    `yaml:"batchSize"`    // 单次批写条数，默认沿用 batchSize
+	FlushDelayMs int    `yaml:"flushDelayMs"` // 刷新延迟（毫秒），默认沿用 flushDelayMs
+	// 暂时性错误（连接断开、死锁等）的重试次数，默认3，负数表示不重试
+	MaxRetries     int `yaml:"maxRetries"`
+	RetryBackoffMs int `yaml:"retryBackoffMs"` // 首次重试间隔（毫秒），之后加倍，默认100
+}
+
+// OverflowConfig 通道满时的处理策略
+type OverflowConfig struct {
+	Policy      string `yaml:"policy"`      // block / drop-oldest / drop-newest / conflate / spill，默认 block
+	SpillDir    string `yaml:"spillDir"`    // spill 策略的溢出目录，按通道名分子目录
+	LogInterval int    `yaml:"logInterval"` // 溢出统计输出间隔（秒），默认60
 }
 
 // ExportConfig 文件导出配置
@@ -62,6 +124,51 @@ type ExportConfig struct {
 	RetentionDays int    `yaml:"retentionDays"`
 }
 
+// CaptureConfig 原始行情录制配置
+type CaptureConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Dir           string `yaml:"dir"`
+	MaxFileSizeMB int    `yaml:"maxFileSizeMB"` // 单文件大小上限（MB），超过后滚动
+	MaxFiles      int    `yaml:"maxFiles"`      // 最多保留文件数，0 表示不清理
+}
+
+// SpoolConfig 本地预写日志配置，开启后行情先落盘再处理，写库成功后推进检查点
+type SpoolConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Dir           string `yaml:"dir"`
+	SegmentSizeMB int    `yaml:"segmentSizeMB"` // 单段大小（MB），超过后滚动，默认64
+	Retention     int    `yaml:"retention"`     // 已提交段保留时长（分钟），0 表示提交后尽快删除
+	SyncInterval  int    `yaml:"syncInterval"`  // 刷盘和保存检查点间隔（毫秒），默认1000
+	StatsInterval int    `yaml:"statsInterval"` // 积压指标输出间隔（秒），默认60
+}
+
+// CalendarConfig 交易日历配置
+type CalendarConfig struct {
+	Timezone string   `yaml:"timezone"` // 时区，默认 Asia/Shanghai
+	Weekdays []int    `yaml:"weekdays"` // 交易日（0=周日 ... 6=周六），默认周一至周五
+	Sessions []string `yaml:"sessions"` // 交易时段，如 "09:00-12:00"，默认全天
+	Holidays []string `yaml:"holidays"` // 休市日期，如 "2025-10-01"
+
+	HolidayFile string `yaml:"holidayFile"` // 休市日期文件，每行一个日期，# 开头为注释，与 holidays 合并
+	CutoffTime  string `yaml:"cutoffTime"`  // 日切时间，如 "17:00"，之后的行情计入下一交易日，默认按自然日
+}
+
+// WatchdogConfig 行情断流监控配置
+type WatchdogConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	FeedTimeout   int  `yaml:"feedTimeout"`   // 订阅整体无消息超时（秒），超时后强制重连，默认60
+	IsinTimeout   int  `yaml:"isinTimeout"`   // 单个ISIN无报价超时（秒），只告警，0表示不监控
+	CheckInterval int  `yaml:"checkInterval"` // 检查间隔（秒），默认10
+	AlertInterval int  `yaml:"alertInterval"` // 同一告警最小间隔（秒），默认600
+}
+
+// OrderBookConfig 内存订单簿配置
+type OrderBookConfig struct {
+	Enabled          bool   `yaml:"enabled"`
+	SnapshotFile     string `yaml:"snapshotFile"`     // 快照文件，启动时恢复、定期和退出时保存，为空时不保存
+	SnapshotInterval int    `yaml:"snapshotInterval"` // 定期保存快照间隔（秒），默认60
+}
+
 // 配置获取函数
 func GetCfg(key string, cfg interface{}) error {
 	if key == "" {
@@ -80,6 +187,9 @@ func GetCfgStr(key string) string {
 
 // 单例模式配置实例
 var (
+	appConfig *APPConfig
+	onceApp   sync.Once
+
 	adenATSConfig *AdenATSConfig
 	onceAdenATS   sync.Once
 
@@ -91,8 +201,34 @@ var (
 
 	logConfig *LogCfg
 	onceLog   sync.Once
+
+	captureConfig *CaptureConfig
+	onceCapture   sync.Once
+
+	spoolConfig *SpoolConfig
+	onceSpool   sync.Once
+
+	calendarConfig *CalendarConfig
+	onceCalendar   sync.Once
+
+	watchdogConfig *WatchdogConfig
+	onceWatchdog   sync.Once
+
+	orderBookConfig *OrderBookConfig
+	onceOrderBook   sync.Once
 )
 
+// GetAPPConfig 获取应用基础配置
+func GetAPPConfig() *APPConfig {
+	onceApp.Do(func() {
+		appConfig = &APPConfig{}
+		if err := GetCfg("app", appConfig); err != nil {
+			logger.Warn("警告: 获取应用基础配置失败: %v\n", err)
+		}
+	})
+	return appConfig
+}
+
 // GetAdenATSConfig 获取亚丁ATS配置
 func GetAdenATSConfig() *AdenATSConfig {
 	onceAdenATS.Do(func() {
@@ -136,3 +272,58 @@ func GetLogConfig() *LogCfg {
 	})
 	return logConfig
 }
+
+// GetCaptureConfig 获取原始行情录制配置
+func GetCaptureConfig() *CaptureConfig {
+	onceCapture.Do(func() {
+		captureConfig = &CaptureConfig{}
+		if err := GetCfg("capture", captureConfig); err != nil {
+			logger.Warn("警告: 获取录制配置失败: %v\n", err)
+		}
+	})
+	return captureConfig
+}
+
+// GetSpoolConfig 获取本地预写日志配置
+func GetSpoolConfig() *SpoolConfig {
+	onceSpool.Do(func() {
+		spoolConfig = &SpoolConfig{}
+		if err := GetCfg("spool", spoolConfig); err != nil {
+			logger.Warn("警告: 获取预写日志配置失败: %v\n", err)
+		}
+	})
+	return spoolConfig
+}
+
+// GetCalendarConfig 获取交易日历配置
+func GetCalendarConfig() *CalendarConfig {
+	onceCalendar.Do(func() {
+		calendarConfig = &CalendarConfig{}
+		if err := GetCfg("calendar", calendarConfig); err != nil {
+			logger.Warn("警告: 获取交易日历配置失败: %v\n", err)
+		}
+	})
+	return calendarConfig
+}
+
+// GetWatchdogConfig 获取行情断流监控配置
+func GetWatchdogConfig() *WatchdogConfig {
+	onceWatchdog.Do(func() {
+		watchdogConfig = &WatchdogConfig{}
+		if err := GetCfg("watchdog", watchdogConfig); err != nil {
+			logger.Warn("警告: 获取行情监控配置失败: %v\n", err)
+		}
+	})
+	return watchdogConfig
+}
+
+// GetOrderBookConfig 获取内存订单簿配置
+func GetOrderBookConfig() *OrderBookConfig {
+	onceOrderBook.Do(func() {
+		orderBookConfig = &OrderBookConfig{}
+		if err := GetCfg("orderBook", orderBookConfig); err != nil {
+			logger.Warn("警告: 获取内存订单簿配置失败: %v\n", err)
+		}
+	})
+	return orderBookConfig
+}
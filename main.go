@@ -19,17 +19,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 	"time"
+	"wealth-bond-quote-service/internal/calendar"
 	config "wealth-bond-quote-service/internal/conf"
 	"wealth-bond-quote-service/internal/dataSource"
+	"wealth-bond-quote-service/pkg/capture"
 	logger "wealth-bond-quote-service/pkg/log"
+	"wealth-bond-quote-service/router"
 	"wealth-bond-quote-service/service"
 
+	"github.com/gofiber/fiber/v2"
 	_ "modernc.org/sqlite"
 )
 
@@ -172,7 +178,7 @@ func generateTestMessage(index int, securityIDs, brokerIDs []string) []byte {
 }
 
 // 如果你想直接在代码中使用，可以调用这个函数
-func GenerateAndSendToChannel(rawChan chan []byte, count int) {
+func GenerateAndSendToChannel(ctx context.Context, rawChan chan *service.RawMessage, count int) {
 	securityIDs := []string{
 		"HK0000098928", "HK0000098929", "HK0000098930", "HK0000098931", "HK0000098932",
 		"CN0000001001", "CN0000001002", "CN0000001003", "CN0000001004", "CN0000001005",
@@ -190,7 +196,7 @@ func GenerateAndSendToChannel(rawChan chan []byte, count int) {
 			message := generateTestMessage(i, securityIDs, brokerIDs)
 
 			select {
-			case rawChan <- message:
+			case rawChan <- &service.RawMessage{Body: message, ReceivedAt: time.Now()}:
 				if i%100 == 0 {
 					fmt.Printf("已发送 %d/%d 条消息\n", i+1, count)
 				}
@@ -199,129 +205,398 @@ func GenerateAndSendToChannel(rawChan chan []byte, count int) {
 			}
 
 			// 控制发送速度
-			time.Sleep(5 * time.Millisecond)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
 		}
 		fmt.Printf("已发送 第%d批次\n", i+1)
-		time.Sleep(5 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
 	}
 	fmt.Printf("完成发送 %d 条消息\n", count)
 }
 
+// initCalendar 创建交易日历，设为按日分表、建表、查询和导出共用的日历
+// 配置错误时返回 nil，按服务器时区的自然日分表
+func initCalendar() *calendar.Calendar {
+	cal, err := calendar.New(config.GetCalendarConfig())
+	if err != nil {
+		logger.Error("交易日历配置错误，按服务器时区的自然日分表: %v", err)
+		return nil
+	}
+	service.SetBusinessCalendar(cal)
+	return cal
+}
+
 func main() {
+	// 子命令：回放录制文件
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
 
 	go func() {
 		http.ListenAndServe("localhost:6060", nil)
 	}()
 
-	var wg sync.WaitGroup
-	RawChan := make(chan []byte, rawCap)
+	RawChan := make(chan *service.RawMessage, rawCap)
 	ParsedChan := make(chan *service.ParsedQuote, parsedCap)
-	DeadChan := make(chan []byte, 1000) // 解析失败
+	DeadChan := make(chan *service.DeadMessage, 1000) // 解析失败
 	db := dataSource.GetDBConn("bond")
+	dataCfg := config.GetDataProcessConfig()
 
-	go GenerateAndSendToChannel(RawChan, 25)
+	// 交易日历：按日分表、建表、查询导出和行情断流监控共用
+	cal := initCalendar()
+
+	// 内存订单簿：写库层同时更新，查询和导出直接读取
+	bookCfg := config.GetOrderBookConfig()
+	var books *service.OrderBookStore
+	if bookCfg.Enabled {
+		books = service.NewOrderBookStore("orderbook")
+	}
 
 	exportConfig := config.GetExportConfig()
 	// 每小时导出最新行情数据
-	service.NewExportLatestQuotesService(db).StartHourlyExport(exportConfig.Path, exportConfig.Interval)
+	exporter := service.NewExportLatestQuotesService(db)
+	exporter.SetOrderBooks(books)
+	exporter.StartHourlyExport(exportConfig.Path, exportConfig.Interval)
 
 	// 每周创建表
 	service.NewCreateTableService(db).StartWeeklyTableCreation()
 
-	// 建立STOMP连接并订阅消息
-	errChan := make(chan error, 1) // 监听出现错误通道
-	ctx, cancel := context.WithCancel(context.Background())
-	go startMessageListener(ctx, errChan, RawChan, &wg)
+	// 流水线各阶段按依赖顺序注册：下游先启动，停止时先停止接入再逐层排空
+	pipeline := service.NewPipeline()
+
+	// 死信落库：解析失败的消息写入死信表，可通过死信接口重新处理
+	deadLetters := service.NewDeadLetterService(db)
+	var deadWg sync.WaitGroup
+	pipeline.Add(service.PipelineStage{
+		Name: "死信落库",
+		Start: func(ctx context.Context) error {
+			if err := deadLetters.EnsureTable(); err != nil {
+				logger.Error("创建死信表失败: %v", err)
+			}
+			deadLetters.Start(DeadChan, &deadWg)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			close(DeadChan)
+			return service.WaitGroupContext(ctx, &deadWg)
+		},
+	})
+
+	// 内存订单簿快照：启动时恢复当前业务日期的订单簿，定期保存，写库层停止后再保存一次
+	if books != nil && bookCfg.SnapshotFile != "" {
+		var bookWg sync.WaitGroup
+		pipeline.Add(service.PipelineStage{
+			Name: "内存订单簿快照",
+			Start: func(ctx context.Context) error {
+				if n, err := books.LoadSnapshot(bookCfg.SnapshotFile, time.Now()); err != nil {
+					logger.Error("恢复内存订单簿失败: %v", err)
+				} else {
+					logger.Info("从快照恢复订单簿 %d 个", n)
+				}
+				interval := time.Duration(bookCfg.SnapshotInterval) * time.Second
+				if interval <= 0 {
+					interval = time.Minute
+				}
+				bookWg.Add(1)
+				go func() {
+					defer bookWg.Done()
+					books.Run(ctx, bookCfg.SnapshotFile, interval)
+				}()
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				return service.WaitGroupContext(ctx, &bookWg)
+			},
+		})
+	}
+
+	// 写库层：按配置写入一个或多个目标，关闭 ParsedChan 后写入最后一批
+	var dbWg sync.WaitGroup
+	dbWriter := service.NewBondQuoteService(db, &dbWg, RawChan, ParsedChan, DeadChan)
+	pipeline.Add(service.PipelineStage{
+		Name: "写库",
+		Start: func(ctx context.Context) error {
+			for i := range dataCfg.Sinks {
+				sinkCfg := &dataCfg.Sinks[i]
+				sink, err := service.NewQuoteSink(sinkCfg, db)
+				if err != nil {
+					return err
+				}
+				dbWriter.AddSink(sink, service.SinkOptions{
+					Required:     sinkCfg.Required,
+					WorkerNum:    sinkCfg.WorkerNum,
+					BatchSize:    sinkCfg.BatchSize,
+					FlushDelay:   time.Duration(sinkCfg.FlushDelayMs) * time.Millisecond,
+					MaxRetries:   sinkCfg.MaxRetries,
+					RetryBackoff: time.Duration(sinkCfg.RetryBackoffMs) * time.Millisecond,
+				})
+			}
+			if books != nil {
+				if len(dataCfg.Sinks) == 0 {
+					// 未配置写入目标时默认写入MySQL
+					dbWriter.AddSink(service.NewDBSink(service.SinkMySQL, db), service.SinkOptions{Required: true})
+				}
+				// 查询和导出直接读取内存订单簿，积压时等待而不是丢弃，避免读到旧报价
+				dbWriter.AddSink(books, service.SinkOptions{WorkerNum: 1, Blocking: true})
+			}
+			dbWriter.StartDBWorkers(workerNum, batchSize, flushDelay)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			close(ParsedChan)
+			return service.WaitGroupContext(ctx, &dbWg)
+		},
+	})
+
+	// 解析结果通道溢出策略
+	parsedOut := ParsedChan
+	var parsedOverflow *service.OverflowStage[*service.ParsedQuote]
+	if stage, err := service.NewParsedOverflowStage("parsed", &dataCfg.ParsedOverflow); err != nil {
+		logger.Error("解析结果通道溢出策略配置错误，按阻塞处理: %v", err)
+	} else {
+		parsedOverflow = stage
+		parsedOut = make(chan *service.ParsedQuote, parsedCap)
+		var stageWg sync.WaitGroup
+		pipeline.Add(service.PipelineStage{
+			Name: "解析结果溢出处理",
+			Start: func(ctx context.Context) error {
+				stage.Start(ctx, parsedOut, ParsedChan, &stageWg)
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				close(parsedOut)
+				return service.WaitGroupContext(ctx, &stageWg)
+			},
+		})
+	}
+
+	// 解析层：关闭 RawChan 后处理完剩余消息
+	var parseWg sync.WaitGroup
+	parser := service.NewBondQuoteService(db, &parseWg, RawChan, parsedOut, DeadChan)
+	pipeline.Add(service.PipelineStage{
+		Name: "解析",
+		Start: func(ctx context.Context) error {
+			parser.StartParseWorkers(workerNum)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			close(RawChan)
+			return service.WaitGroupContext(ctx, &parseWg)
+		},
+	})
+
+	// 测试消息生成
+	var generatorWg sync.WaitGroup
+	pipeline.Add(service.PipelineStage{
+		Name: "测试消息生成",
+		Start: func(ctx context.Context) error {
+			generatorWg.Add(1)
+			go func() {
+				defer generatorWg.Done()
+				GenerateAndSendToChannel(ctx, RawChan, 25)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return service.WaitGroupContext(ctx, &generatorWg)
+		},
+	})
+
+	// 本地预写日志：会话收到的消息先落盘再进入解析层，写库成功后推进检查点
+	// 预写日志中未投递的消息留在磁盘，下次启动时重放
+	sessionOut := RawChan
+	var spoolStage *service.SpoolStage
+	if spoolCfg := config.GetSpoolConfig(); spoolCfg.Enabled {
+		sp, err := service.OpenSpool(spoolCfg)
+		if err != nil {
+			logger.Error("打开预写日志失败，行情直接进入内存通道: %v", err)
+		} else {
+			fmt.Printf("预写日志已开启，目录: %s\n", spoolCfg.Dir)
+			defer sp.Close()
+			in := make(chan *service.RawMessage, rawCap)
+			sessionOut = in
+			spoolStage = service.NewSpoolStage(sp, spoolCfg)
+			var stageWg sync.WaitGroup
+			pipeline.Add(service.PipelineStage{
+				Name: "预写日志",
+				Start: func(ctx context.Context) error {
+					spoolStage.Start(ctx, in, RawChan, &stageWg)
+					return nil
+				},
+				Stop: func(ctx context.Context) error {
+					close(in)
+					return service.WaitGroupContext(ctx, &stageWg)
+				},
+			})
+		}
+	}
+
+	// 通道溢出策略：会话始终及时读取，RawChan 满时按配置阻塞、丢弃、合并或落盘
+	sessionRaw := sessionOut
+	var rawOverflow *service.OverflowStage[*service.RawMessage]
+	if stage, err := service.NewRawOverflowStage("raw", &dataCfg.RawOverflow); err != nil {
+		logger.Error("原始消息通道溢出策略配置错误，按阻塞处理: %v", err)
+	} else {
+		rawOverflow = stage
+		in := make(chan *service.RawMessage, rawCap)
+		out := sessionOut
+		sessionRaw = in
+		var stageWg sync.WaitGroup
+		pipeline.Add(service.PipelineStage{
+			Name: "原始消息溢出处理",
+			Start: func(ctx context.Context) error {
+				stage.Start(ctx, in, out, &stageWg)
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				close(in)
+				return service.WaitGroupContext(ctx, &stageWg)
+			},
+		})
+	}
 
-	// 第五步：启动后台处理工作协程
-	bqs := service.NewBondQuoteService(db, &wg, RawChan, ParsedChan, DeadChan)
-	go bqs.StartParseWorkers(workerNum)
-	go bqs.StartDBWorkers(workerNum, batchSize, flushDelay)
+	// 建立ATS会话：登录、连接、订阅，断线后按配置退避重连
+	session := service.NewAtsSession(config.GetAdenATSConfig(), sessionRaw)
+	// 重连后把断线前的最新行情标记为未确认，并按快照修正
+	recovery := service.NewQuoteRecovery(db, config.GetAdenATSConfig().SnapshotPath)
+	recovery.SetOrderBooks(books)
+	session.SetRecovery(recovery)
 
-	// 设置中断信号处理
-	// 监听Ctrl+C信号，优雅退出程序
+	// 原始消息录制，可用 replay 子命令回放
+	if captureCfg := config.GetCaptureConfig(); captureCfg.Enabled {
+		writer, err := capture.NewWriter(captureCfg.Dir, int64(captureCfg.MaxFileSizeMB)<<20, captureCfg.MaxFiles)
+		if err != nil {
+			logger.Error("初始化行情录制失败: %v", err)
+		} else {
+			fmt.Printf("行情录制已开启，目录: %s\n", captureCfg.Dir)
+			session.SetCapture(writer)
+			defer writer.Close()
+		}
+	}
+
+	// 行情断流监控：交易时段内超时无推送时强制重连并告警
+	if watchdogCfg := config.GetWatchdogConfig(); watchdogCfg.Enabled {
+		if cal == nil {
+			logger.Error("交易日历配置错误，行情断流监控未启动")
+		} else {
+			watchdog := service.NewFeedWatchdog(watchdogCfg, cal)
+			session.SetWatchdog(watchdog)
+			parser.SetWatchdog(watchdog)
+			pipeline.Add(service.PipelineStage{
+				Name: "行情断流监控",
+				Start: func(ctx context.Context) error {
+					go watchdog.Run(ctx)
+					return nil
+				},
+			})
+		}
+	}
+
+	// 查询接口：死信查询、重新处理和清理，订单簿和报价生命周期查询
+	appCfg := config.GetAPPConfig()
+	addr := appCfg.Addr
+	if addr == "" {
+		addr = ":8081"
+	}
+	app := fiber.New(fiber.Config{AppName: appCfg.Name, DisableStartupMessage: true})
+	router.NewDeadLetterHandler(deadLetters).RegisterRoutes(app)
+	query := service.NewBondQueryService(db)
+	query.SetOrderBooks(books)
+	router.NewQueryHandler(query).RegisterRoutes(app)
+	pipeline.Add(service.PipelineStage{
+		Name: "查询接口",
+		Start: func(ctx context.Context) error {
+			// 先监听端口，端口被占用时启动失败
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return fmt.Errorf("查询接口监听 %s 失败: %w", addr, err)
+			}
+			fmt.Printf("查询接口已启动，地址: %s\n", addr)
+			go func() {
+				if err := app.Listener(ln); err != nil {
+					logger.Error("查询接口异常退出: %v", err)
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return app.ShutdownWithContext(ctx)
+		},
+	})
+
+	// ATS会话最后启动、最先停止
+	sessionDone := make(chan struct{})
+	var sessionErr error
+	pipeline.Add(service.PipelineStage{
+		Name: "ATS会话",
+		Start: func(ctx context.Context) error {
+			go func() {
+				defer close(sessionDone)
+				sessionErr = session.Run(ctx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			select {
+			case <-sessionDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+
+	// 监听 Ctrl+C 和 Kubernetes 的 SIGTERM，优雅退出程序
 	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	shutdownTimeout := time.Duration(dataCfg.ShutdownTimeout) * time.Second
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+	if err := pipeline.Start(context.Background(), shutdownTimeout); err != nil {
+		fmt.Printf("流水线启动失败: %v\n", err)
+		os.Exit(1)
+	}
 
 	fmt.Println("连接成功，等待消息推送...")
 	fmt.Println("按 Ctrl+C 退出")
 
-	for {
-		select {
-		case err := <-errChan:
-			logger.Error("消息监听错误: %v", err)
-			go func() {
-				time.Sleep(5 * time.Second)                      // 等待一段时间后重启监听
-				startMessageListener(ctx, errChan, RawChan, &wg) // 重启监听
-			}()
-		case <-interrupt:
-			fmt.Println("收到中断信号，正在退出...")
-			fmt.Println("正在断开连接...")
-			cancel() // 取消上下文，通知所有协程退出
-			// 优雅关闭：关闭 channels，等待 workers 完成
-			close(RawChan)
-			close(ParsedChan)
-			close(DeadChan)
-			close(errChan)
+	select {
+	case <-sessionDone:
+		// 会话只会在重连次数耗尽时自行退出
+		logger.Error("ATS会话终止: %v", sessionErr)
+		fmt.Println("ATS会话终止，正在退出...")
+	case sig := <-interrupt:
+		fmt.Printf("收到信号 %s，正在退出...\n", sig)
+	}
 
-			// 现在等待所有 worker 完成清理工作
-			wg.Wait()
-			fmt.Println("所有后台任务已停止")
-			return
-		}
+	// 优雅关闭：停止接入，依次排空解析层和写库层，超过截止时间不再等待
+	if err := pipeline.Stop(shutdownTimeout); err != nil {
+		logger.Error("流水线未能完全排空: %v", err)
 	}
-}
 
-// 1. 用户登录获取访问令牌
-// 2. 建立WebSocket连接
-// 3. 建立STOMP协议连接
-// 4. 订阅债券行情消息
-// 5. 持续监听消息推送
-func startMessageListener(ctx context.Context, errChan chan error, rawChan chan []byte, wg *sync.WaitGroup) {
-	wg.Add(1)
-	defer wg.Done() // 确保函数退出时调用 Done
-	// 获取亚丁ATS配置
-	adenConfig := config.GetAdenATSConfig()
-
-	logger.Debug("使用配置 - ATS地址: %s, 用户: %s", adenConfig.BaseURL, adenConfig.Username)
-
-	fmt.Println("开始亚丁ATS系统测试...")
-
-	// 创建STOMP客户端实例
-	client := &service.StompClient{}
-
-	// 第一步：用户登录获取访问令牌
-	// 使用加密通信协议，获取后续API调用所需的token
-	fmt.Println("第一步：登录获取Token...")
-	if err := client.Login(adenConfig.Username, adenConfig.Password, adenConfig.SmsCode, adenConfig.PublicKey, adenConfig.BaseURL, adenConfig.ClientId); err != nil {
-		logger.Fatal("登录失败: %v", err.Error())
-		return
+	parsed, written := parser.Counts(), dbWriter.Counts()
+	logger.Info("最终统计: 解析 %d 条, 死信 %d 条, 写库 %d 条, 写库失败 %d 条",
+		parsed.Parsed, parsed.Dead, written.Written, written.Failed)
+	for _, st := range dbWriter.SinkStats() {
+		logger.Info("写入目标%s: %+v", st.Name, st)
 	}
-	logger.Info("登录成功，获取到Token: %s", client.Token[:20]+"...")
-
-	// 第二步：建立WebSocket连接
-	// 使用获取的token建立安全的WebSocket连接
-	fmt.Println("第二步：建立WebSocket连接...")
-	if err := client.ConnectWebSocket(adenConfig.WssURL); err != nil {
-		logger.Fatal("WebSocket连接失败: %v", err.Error())
-		return
+	if rawOverflow != nil {
+		logger.Info("原始消息通道: %+v", rawOverflow.Stats())
 	}
-	defer client.Conn.Close() // 确保程序退出时关闭连接
-
-	// 第三步：建立STOMP协议连接
-	// 在WebSocket基础上建立STOMP消息协议连接
-	fmt.Println("第三步：建立STOMP连接...")
-	if err := client.ConnectStomp(); err != nil {
-		logger.Fatal("STOMP连接失败: %v", err.Error())
-		return
+	if parsedOverflow != nil {
+		logger.Info("解析结果通道: %+v", parsedOverflow.Stats())
 	}
-	defer client.StompConn.Disconnect() // 确保程序退出时断开STOMP连接
-
-	// 第四步：订阅债券行情消息
-	// 订阅指定的消息队列，开始接收实时行情数据
-	fmt.Println("第四步：订阅行情消息...")
-	if err := client.Subscribe(ctx, rawChan, errChan /*, &Mwg*/); err != nil {
-		logger.Fatal("订阅失败: %v", err.Error())
-		return
+	if spoolStage != nil {
+		logger.Info("预写日志: %+v", spoolStage.Stats())
 	}
+	fmt.Println("所有后台任务已停止")
 }
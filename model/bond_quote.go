@@ -5,25 +5,29 @@ import (
 )
 
 // BondQuoteDetail 债券行情明细表
+// (message_id, quote_order_no, side, level_no) 唯一，重复投递和回放的同一档报价只保留一行；
+// 报价单号为空的多个档位按档位序号区分
 type BondQuoteDetail struct {
-	ID               int64      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`                            // 主键ID
-	MessageID        string     `gorm:"column:message_id;not null;index" json:"messageId"`                       // 消息ID
-	MessageType      string     `gorm:"column:message_type;not null" json:"messageType"`                         // 消息类型
-	Timestamp        int64      `gorm:"column:timestamp;not null" json:"timestamp"`                              // 时间戳
-	ISIN             string     `gorm:"column:isin;not null;index" json:"isin"`                                  // 债券代码
-	BrokerID         string     `gorm:"column:broker_id;not null" json:"brokerId"`                               // 券商ID
-	Side             string     `gorm:"column:side;not null" json:"side"`                                        // 方向(BID/ASK)
-	Price            float64    `gorm:"column:price;not null;type:decimal(18,6)" json:"price"`                   // 报价
-	Yield            *float64   `gorm:"column:yield;type:decimal(18,6)" json:"yield"`                            // 收益率
-	OrderQty         float64    `gorm:"column:order_qty;not null;type:decimal(18,2)" json:"orderQty"`            // 数量
-	MinTransQuantity *float64   `gorm:"column:min_trans_quantity;type:decimal(18,2)" json:"minTransQuantity"`    // 最小交易量
-	QuoteOrderNo     string     `gorm:"column:quote_order_no;not null" json:"quoteOrderNo"`                      // 报价单号
-	QuoteTime        time.Time  `gorm:"column:quote_time;not null;index" json:"quoteTime"`                       // 报价时间
-	SettleType       *string    `gorm:"column:settle_type" json:"settleType"`                                    // 结算类型
-	SettleDate       *time.Time `gorm:"column:settle_date;type:date" json:"settleDate"`                          // 结算日期
-	IsValid          *string    `gorm:"column:is_valid;type:char(1)" json:"isValid"`                             // 是否有效(Y/N)
-	IsTbd            *string    `gorm:"column:is_tbd;type:char(1)" json:"isTbd"`                                 // 是否待定(Y/N)
-	CreateTime       time.Time  `gorm:"column:create_time;not null;default:CURRENT_TIMESTAMP" json:"createTime"` // 创建时间
+	ID               int64      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`                                                              // 主键ID
+	MessageID        string     `gorm:"column:message_id;not null;index;uniqueIndex:,composite:dedupe_level,priority:1" json:"messageId"`          // 消息ID
+	MessageType      string     `gorm:"column:message_type;not null" json:"messageType"`                                                           // 消息类型
+	Timestamp        int64      `gorm:"column:timestamp;not null" json:"timestamp"`                                                                // 时间戳
+	ISIN             string     `gorm:"column:isin;not null;index" json:"isin"`                                                                    // 债券代码
+	BrokerID         string     `gorm:"column:broker_id;not null" json:"brokerId"`                                                                 // 券商ID
+	Side             string     `gorm:"column:side;not null;size:8;uniqueIndex:,composite:dedupe_level,priority:3" json:"side"`                    // 方向(BID/ASK)
+	Price            float64    `gorm:"column:price;not null;type:decimal(18,6)" json:"price"`                                                     // 报价
+	Yield            *float64   `gorm:"column:yield;type:decimal(18,6)" json:"yield"`                                                              // 收益率
+	OrderQty         float64    `gorm:"column:order_qty;not null;type:decimal(18,2)" json:"orderQty"`                                              // 数量
+	MinTransQuantity *float64   `gorm:"column:min_trans_quantity;type:decimal(18,2)" json:"minTransQuantity"`                                      // 最小交易量
+	QuoteOrderNo     string     `gorm:"column:quote_order_no;not null;size:64;uniqueIndex:,composite:dedupe_level,priority:2" json:"quoteOrderNo"` // 报价单号
+	LevelNo          int        `gorm:"column:level_no;not null;default:0;uniqueIndex:,composite:dedupe_level,priority:4" json:"levelNo"`          // 档位序号(同一消息同一方向内从1开始)
+	QuoteTime        time.Time  `gorm:"column:quote_time;not null;index" json:"quoteTime"`                                                         // 报价时间
+	SettleType       *string    `gorm:"column:settle_type" json:"settleType"`                                                                      // 结算类型
+	SettleDate       *time.Time `gorm:"column:settle_date;type:date" json:"settleDate"`                                                            // 结算日期
+	IsValid          *string    `gorm:"column:is_valid;type:char(1)" json:"isValid"`                                                               // 是否有效(Y/N)
+	IsTbd            *string    `gorm:"column:is_tbd;type:char(1)" json:"isTbd"`                                                                   // 是否待定(Y/N)
+	Source           string     `gorm:"column:source;type:varchar(16);not null;default:STREAM" json:"source"`                                      // 数据来源(STREAM推送/SNAPSHOT重连后快照修正)
+	CreateTime       time.Time  `gorm:"column:create_time;not null;default:CURRENT_TIMESTAMP" json:"createTime"`                                   // 创建时间
 }
 
 // // TableName 设置表名
@@ -46,8 +50,49 @@ type BondLatestQuote struct {
 	SendTime       int64     `gorm:"column:send_time;index" json:"sendTime"`                                           // 消息发送时间
 	Timestamp      int64     `gorm:"column:timestamp;index" json:"timestamp"`                                          // 业务时间戳
 	LastUpdateTime time.Time `gorm:"column:last_update_time;not null;default:CURRENT_TIMESTAMP" json:"lastUpdateTime"` // 最后更新时间
+	Status         string    `gorm:"column:status;type:varchar(16);not null;default:CONFIRMED" json:"status"`          // 行情状态(CONFIRMED已确认/UNCONFIRMED断线前数据未确认)
 }
 
+// BondQuoteEvent 报价生命周期事件表
+// 比对同一债券相邻两次订单簿得到，(message_id, quote_order_no, side) 唯一，重复投递和回放只保留一行
+type BondQuoteEvent struct {
+	ID           int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`                                                              // 主键ID
+	MessageID    string    `gorm:"column:message_id;not null;uniqueIndex:,composite:dedupe,priority:1" json:"messageId"`                      // 产生事件的消息ID
+	QuoteOrderNo string    `gorm:"column:quote_order_no;not null;size:64;index;uniqueIndex:,composite:dedupe,priority:2" json:"quoteOrderNo"` // 报价单号
+	Side         string    `gorm:"column:side;not null;size:8;uniqueIndex:,composite:dedupe,priority:3" json:"side"`                          // 方向(BID/ASK)
+	EventType    string    `gorm:"column:event_type;type:varchar(16);not null" json:"eventType"`                                              // 事件类型(NEW/AMEND/CANCEL)
+	ISIN         string    `gorm:"column:isin;not null;index" json:"isin"`                                                                    // 债券代码
+	BrokerID     string    `gorm:"column:broker_id;not null" json:"brokerId"`                                                                 // 券商ID
+	PrevPrice    *float64  `gorm:"column:prev_price;type:decimal(18,6)" json:"prevPrice"`                                                     // 变化前报价，NEW 时为空
+	Price        *float64  `gorm:"column:price;type:decimal(18,6)" json:"price"`                                                              // 变化后报价，CANCEL 时为空
+	PrevYield    *float64  `gorm:"column:prev_yield;type:decimal(18,6)" json:"prevYield"`                                                     // 变化前收益率
+	Yield        *float64  `gorm:"column:yield;type:decimal(18,6)" json:"yield"`                                                              // 变化后收益率
+	PrevOrderQty *float64  `gorm:"column:prev_order_qty;type:decimal(18,2)" json:"prevOrderQty"`                                              // 变化前数量
+	OrderQty     *float64  `gorm:"column:order_qty;type:decimal(18,2)" json:"orderQty"`                                                       // 变化后数量
+	QuoteTime    time.Time `gorm:"column:quote_time;not null" json:"quoteTime"`                                                               // 报价时间，CANCEL 时为原报价的时间
+	EventTime    time.Time `gorm:"column:event_time;not null;index" json:"eventTime"`                                                         // 事件时间（消息发送时间）
+	CreateTime   time.Time `gorm:"column:create_time;not null;default:CURRENT_TIMESTAMP" json:"createTime"`                                   // 创建时间
+}
+
+// 报价生命周期事件类型
+const (
+	QuoteEventNew    = "NEW"    // 新出现的报价
+	QuoteEventAmend  = "AMEND"  // 价格、收益率或数量变化
+	QuoteEventCancel = "CANCEL" // 报价消失或变为无效
+)
+
+// 明细数据来源
+const (
+	QuoteSourceStream   = "STREAM"   // 实时推送
+	QuoteSourceSnapshot = "SNAPSHOT" // 重连后按快照修正
+)
+
+// 最新行情状态
+const (
+	QuoteStatusConfirmed   = "CONFIRMED"   // 重连后已由推送或快照确认
+	QuoteStatusUnconfirmed = "UNCONFIRMED" // 断线前的数据，重连后尚未确认
+)
+
 // // TableName 设置表名
 // func (BondLatestQuote) TableName() string {
 // 	return "t_bond_latest_quote"
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+)
+
+// DeadLetter 解析失败的原始行情消息（死信）
+type DeadLetter struct {
+	ID            int64      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`                                // 主键ID
+	ReceivedAt    time.Time  `gorm:"column:received_at;not null;index" json:"receivedAt"`                         // 原始消息接收时间
+	Reason        string     `gorm:"column:reason;type:varchar(512);not null" json:"reason"`                      // 进入死信的原因
+	RawBody       []byte     `gorm:"column:raw_body;type:mediumblob" json:"rawBody,omitempty"`                    // 原始消息
+	Size          int        `gorm:"column:size;not null" json:"size"`                                            // 原始消息字节数
+	Status        string     `gorm:"column:status;type:varchar(16);not null;default:PENDING;index" json:"status"` // 处理状态(PENDING/REPROCESSED/FAILED)
+	Attempts      int        `gorm:"column:attempts;not null;default:0" json:"attempts"`                          // 重新处理次数
+	LastError     string     `gorm:"column:last_error;type:varchar(512)" json:"lastError"`                        // 最近一次重新处理失败原因
+	ReprocessedAt *time.Time `gorm:"column:reprocessed_at" json:"reprocessedAt"`                                  // 重新处理成功时间
+	CreateTime    time.Time  `gorm:"column:create_time;not null;default:CURRENT_TIMESTAMP" json:"createTime"`     // 创建时间
+}
+
+// TableName 设置表名
+func (DeadLetter) TableName() string {
+	return "t_bond_dead_letter"
+}
+
+// 死信处理状态
+const (
+	DeadLetterPending     = "PENDING"     // 待处理
+	DeadLetterReprocessed = "REPROCESSED" // 已重新处理写库
+	DeadLetterFailed      = "FAILED"      // 重新处理仍失败
+)
@@ -0,0 +1,224 @@
+// Package capture 原始行情录制与回放
+//
+// 录制文件格式：
+//   - 文件头：8字节魔数 "BQCAP001"
+//   - 记录：[8字节接收时间 UnixNano][4字节消息体长度][消息体]，整数均为大端序
+//
+// 文件按大小和自然日滚动，超过保留个数时删除最旧的文件。
+// 进程崩溃可能留下不完整的末尾记录，读取时返回 io.ErrUnexpectedEOF。
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	magic      = "BQCAP001"
+	headerSize = 12                // 接收时间8字节 + 长度4字节
+	maxBody    = 64 << 20          // 单条记录上限，防止读到损坏文件时分配超大内存
+	filePrefix = "capture-"        // 录制文件名前缀
+	fileSuffix = ".cap"            // 录制文件扩展名
+	timeLayout = "20060102-150405" // 文件名中的时间格式，按字典序即按时间排序
+
+	defaultMaxFileSize = 256 << 20 // 默认单文件256MB
+)
+
+// ErrBadMagic 文件不是录制文件
+var ErrBadMagic = errors.New("不是行情录制文件")
+
+// Record 一条录制记录
+type Record struct {
+	ReceivedAt time.Time // 接收时间
+	Body       []byte    // 原始消息体
+}
+
+// Writer 滚动录制文件写入器，可并发使用
+type Writer struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	day  string // 当前文件所属自然日，跨日时滚动
+	buf  []byte
+}
+
+// NewWriter 创建录制写入器
+// 参数：
+//   - dir: 录制目录，不存在时自动创建
+//   - maxSize: 单文件最大字节数，<=0 时使用默认值256MB
+//   - maxFiles: 最多保留的文件个数，<=0 表示不清理
+func NewWriter(dir string, maxSize int64, maxFiles int) (*Writer, error) {
+	if dir == "" {
+		return nil, errors.New("录制目录不能为空")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建录制目录失败: %w", err)
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxFileSize
+	}
+	return &Writer{dir: dir, maxSize: maxSize, maxFiles: maxFiles}, nil
+}
+
+// Write 写入一条记录，必要时先滚动文件
+// 每条记录一次系统调用写出，不做额外缓冲，进程崩溃时最多丢失正在写的一条
+func (w *Writer) Write(receivedAt time.Time, body []byte) error {
+	if len(body) > maxBody {
+		return fmt.Errorf("消息体过大: %d 字节", len(body))
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	recLen := int64(headerSize + len(body))
+	day := receivedAt.Format("20060102")
+	if w.f == nil || day != w.day || (w.size > int64(len(magic)) && w.size+recLen > w.maxSize) {
+		if err := w.rotate(receivedAt); err != nil {
+			return err
+		}
+	}
+
+	w.buf = w.buf[:0]
+	w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(receivedAt.UnixNano()))
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(len(body)))
+	w.buf = append(w.buf, body...)
+	n, err := w.f.Write(w.buf)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("写入录制文件失败: %w", err)
+	}
+	return nil
+}
+
+// Close 关闭当前文件
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// rotate 关闭当前文件并打开新文件，随后清理超出保留个数的旧文件
+func (w *Writer) rotate(now time.Time) error {
+	if w.f != nil {
+		if err := w.f.Close(); err != nil {
+			return fmt.Errorf("关闭录制文件失败: %w", err)
+		}
+		w.f = nil
+	}
+
+	// 同一秒内多次滚动时追加序号区分
+	stamp := now.Format(timeLayout)
+	var path string
+	for i := 0; ; i++ {
+		path = filepath.Join(w.dir, fmt.Sprintf("%s%s-%03d%s", filePrefix, stamp, i, fileSuffix))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("创建录制文件失败: %w", err)
+	}
+	if _, err := f.WriteString(magic); err != nil {
+		f.Close()
+		return fmt.Errorf("写入录制文件头失败: %w", err)
+	}
+	w.f = f
+	w.size = int64(len(magic))
+	w.day = now.Format("20060102")
+	return w.prune()
+}
+
+// prune 删除超出保留个数的最旧文件
+func (w *Writer) prune() error {
+	if w.maxFiles <= 0 {
+		return nil
+	}
+	files, err := Files(w.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > w.maxFiles {
+		if err := os.Remove(files[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除过期录制文件失败: %w", err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// Files 按时间顺序列出目录下的录制文件
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Reader 顺序读取录制记录
+type Reader struct {
+	r   *bufio.Reader
+	hdr [headerSize]byte
+}
+
+// NewReader 创建读取器并校验文件头
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, ErrBadMagic
+	}
+	if string(head) != magic {
+		return nil, ErrBadMagic
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next 读取下一条记录
+// 正常读完返回 io.EOF；末尾记录不完整时返回 io.ErrUnexpectedEOF
+func (r *Reader) Next() (*Record, error) {
+	if _, err := io.ReadFull(r.r, r.hdr[:]); err != nil {
+		return nil, err
+	}
+	nanos := int64(binary.BigEndian.Uint64(r.hdr[:8]))
+	size := binary.BigEndian.Uint32(r.hdr[8:])
+	if size > maxBody {
+		return nil, fmt.Errorf("记录长度异常: %d 字节", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &Record{ReceivedAt: time.Unix(0, nanos), Body: body}, nil
+}
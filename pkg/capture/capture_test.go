@@ -0,0 +1,188 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2025, 7, 1, 9, 30, 0, 123456789, time.Local)
+	bodies := [][]byte{[]byte(`{"a":1}`), {}, bytes.Repeat([]byte("x"), 100000)}
+	for i, b := range bodies {
+		if err := w.Write(base.Add(time.Duration(i)*time.Millisecond), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	files, _ := Files(dir)
+	if len(files) != 1 {
+		t.Fatalf("文件数 = %d, 期望 1", len(files))
+	}
+	recs := readAll(t, files[0])
+	if len(recs) != len(bodies) {
+		t.Fatalf("记录数 = %d, 期望 %d", len(recs), len(bodies))
+	}
+	for i, rec := range recs {
+		if !bytes.Equal(rec.Body, bodies[i]) {
+			t.Fatalf("第%d条内容不一致", i)
+		}
+		if want := base.Add(time.Duration(i) * time.Millisecond); !rec.ReceivedAt.Equal(want) {
+			t.Fatalf("第%d条时间 = %v, 期望 %v", i, rec.ReceivedAt, want)
+		}
+	}
+}
+
+func TestRotateAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 100, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 7, 1, 23, 59, 59, 0, time.Local)
+	body := bytes.Repeat([]byte("y"), 60) // 每条记录72字节，每个文件只能放一条
+	for i := 0; i < 5; i++ {
+		if err := w.Write(now, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 跨日必定滚动
+	if err := w.Write(now.Add(time.Second), []byte("next day")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	files, _ := Files(dir)
+	if len(files) != 3 {
+		t.Fatalf("保留文件数 = %d, 期望 3: %v", len(files), files)
+	}
+	last := readAll(t, files[2])
+	if len(last) != 1 || string(last[0].Body) != "next day" {
+		t.Fatalf("最新文件内容不符: %v", last)
+	}
+}
+
+func TestTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := NewWriter(dir, 0, 0)
+	now := time.Now()
+	w.Write(now, []byte("first"))
+	w.Write(now, []byte("second"))
+	w.Close()
+
+	files, _ := Files(dir)
+	info, _ := os.Stat(files[0])
+	if err := os.Truncate(files[0], info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := os.Open(files[0])
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := r.Next(); err != nil || string(rec.Body) != "first" {
+		t.Fatalf("第一条读取失败: %v", err)
+	}
+	if _, err := r.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("截断记录应返回 ErrUnexpectedEOF, 实际: %v", err)
+	}
+}
+
+func TestBadMagic(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("Starting: dlv dap"))); !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("非录制文件应返回 ErrBadMagic, 实际: %v", err)
+	}
+}
+
+func TestReplayPacing(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, 7, 1, 9, 30, 0, 0, time.Local)
+	// 两个文件，验证跨文件节奏连续
+	for i, offsets := range [][]time.Duration{{0, time.Second}, {3 * time.Second}} {
+		sub := fmt.Sprintf("%s/%d", dir, i)
+		w, _ := NewWriter(sub, 0, 0)
+		for _, off := range offsets {
+			w.Write(base.Add(off), []byte(off.String()))
+		}
+		w.Close()
+	}
+	var paths []string
+	for i := 0; i < 2; i++ {
+		files, _ := Files(fmt.Sprintf("%s/%d", dir, i))
+		paths = append(paths, files...)
+	}
+
+	var waits []time.Duration
+	r := &Replayer{Speed: 2, sleep: func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}}
+	var got []string
+	n, err := r.Replay(context.Background(), paths, func(rec *Record) error {
+		got = append(got, string(rec.Body))
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Fatalf("回放条数 = %d, err = %v", n, err)
+	}
+	if fmt.Sprint(got) != "[0s 1s 3s]" {
+		t.Fatalf("回放顺序 = %v", got)
+	}
+	// 二倍速：1秒、3秒的记录分别在0.5秒、1.5秒处发出
+	want := []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond}
+	if len(waits) != len(want) {
+		t.Fatalf("等待次数 = %d, 期望 %d", len(waits), len(want))
+	}
+	for i := range want {
+		if diff := want[i] - waits[i]; diff < 0 || diff > 200*time.Millisecond {
+			t.Fatalf("第%d次等待 = %v, 期望约 %v", i, waits[i], want[i])
+		}
+	}
+
+	// 尽快回放不等待
+	waits = nil
+	r.Speed = 0
+	if n, err := r.Replay(context.Background(), paths, func(*Record) error { return nil }); err != nil || n != 3 {
+		t.Fatalf("尽快回放条数 = %d, err = %v", n, err)
+	}
+	if len(waits) != 0 {
+		t.Fatalf("尽快回放不应等待, 实际等待 %v", waits)
+	}
+}
+
+func readAll(t *testing.T, path string) []*Record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []*Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+}
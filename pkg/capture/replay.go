@@ -0,0 +1,97 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	logger "wealth-bond-quote-service/pkg/log"
+)
+
+// Replayer 按录制时间节奏回放记录
+type Replayer struct {
+	// Speed 回放倍速：1 为原速，10 为十倍速，<=0 表示不等待、尽快回放
+	Speed float64
+
+	sleep func(ctx context.Context, d time.Duration) error // 测试时可替换
+}
+
+// Replay 依次回放多个录制文件，对每条记录调用 fn
+// 记录间隔按第一条记录的接收时间计算，跨文件保持连续节奏
+// 文件末尾记录不完整时记录告警并继续下一个文件
+// 返回已回放的记录条数
+func (r *Replayer) Replay(ctx context.Context, paths []string, fn func(*Record) error) (int, error) {
+	var (
+		count     int
+		first     time.Time
+		wallStart time.Time
+	)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return count, fmt.Errorf("打开录制文件失败: %w", err)
+		}
+		reader, err := NewReader(f)
+		if err != nil {
+			f.Close()
+			return count, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for {
+			rec, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				logger.Warn("录制文件 %s 末尾记录不完整，已跳过", path)
+				break
+			}
+			if err != nil {
+				f.Close()
+				return count, fmt.Errorf("%s: %w", path, err)
+			}
+
+			if count == 0 {
+				first, wallStart = rec.ReceivedAt, time.Now()
+			} else if r.Speed > 0 {
+				offset := time.Duration(float64(rec.ReceivedAt.Sub(first)) / r.Speed)
+				if err := r.wait(ctx, time.Until(wallStart.Add(offset))); err != nil {
+					f.Close()
+					return count, err
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				f.Close()
+				return count, err
+			}
+
+			if err := fn(rec); err != nil {
+				f.Close()
+				return count, err
+			}
+			count++
+		}
+		f.Close()
+	}
+	return count, nil
+}
+
+func (r *Replayer) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	if r.sleep != nil {
+		return r.sleep(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
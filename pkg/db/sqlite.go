@@ -0,0 +1,34 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	_ "modernc.org/sqlite" // 纯Go实现的SQLite驱动，注册名为"sqlite"
+)
+
+// InitSqliteConn 打开SQLite数据库（使用modernc纯Go驱动，无需CGO）
+// path: 数据库文件路径
+func InitSqliteConn(path string) (*gorm.DB, error) {
+	// busy_timeout 避免多个写库协程并发时报 database is locked
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite 同一时刻只允许一个写事务
+	sqlDB.SetMaxOpenConns(1)
+
+	conn, err := gorm.Open(sqlite.New(sqlite.Config{
+		DriverName: "sqlite",
+		Conn:       sqlDB,
+	}), &gorm.Config{})
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,152 @@
+package spool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Reader 从检查点之后顺序读取未提交的记录，读到末尾时等待新记录
+// Next/Seek 只能在一个协程中调用，Rewind 可在任意协程调用
+type Reader struct {
+	s    *Spool
+	next uint64 // 下一条要读取的序号
+
+	mu     sync.Mutex
+	rewind uint64 // 待回退的序号，0 表示无
+
+	f     *os.File
+	first uint64 // 当前打开段的起始序号
+	off   int64  // 当前段内的读取位置
+}
+
+// NewReader 创建从检查点之后开始读取的读取器
+func (s *Spool) NewReader() *Reader {
+	return &Reader{s: s, next: s.Committed() + 1}
+}
+
+// Seek 重新定位到指定序号，用于写库失败后回退重读
+func (r *Reader) Seek(seq uint64) {
+	if seq == 0 {
+		seq = 1
+	}
+	r.closeFile()
+	r.next = seq
+}
+
+// Rewind 请求回退到指定序号，下次 Next 时生效；正在等待新记录的 Next 会被唤醒
+// 多次请求取最小的序号
+func (r *Reader) Rewind(seq uint64) {
+	r.mu.Lock()
+	if r.rewind == 0 || seq < r.rewind {
+		r.rewind = seq
+	}
+	r.mu.Unlock()
+	r.s.wake()
+}
+
+// Next 返回下一条未提交的记录，已提交的记录（回退重读时）自动跳过
+// 没有新记录时阻塞，直到有新记录、ctx 取消或预写日志关闭（返回 ErrClosed）
+func (r *Reader) Next(ctx context.Context) (*Record, error) {
+	s := r.s
+	for {
+		r.mu.Lock()
+		if target := r.rewind; target != 0 {
+			r.rewind = 0
+			if target < r.next {
+				r.Seek(target)
+			}
+		}
+		r.mu.Unlock()
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if r.next > s.lastSeq {
+			wait := s.notify
+			s.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-wait:
+			}
+			continue
+		}
+		seg := s.segmentFor(r.next)
+		if seg == nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("预写日志记录 %d 不存在", r.next)
+		}
+		if r.next < seg.firstSeq {
+			// 已清理的段只包含已提交的记录
+			r.next = seg.firstSeq
+		}
+		path, first := seg.path, seg.firstSeq
+		s.mu.Unlock()
+
+		if r.f == nil || r.first != first {
+			r.closeFile()
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, fmt.Errorf("打开预写日志段失败: %w", err)
+			}
+			r.f, r.first, r.off = f, first, int64(len(magic))
+		}
+
+		rec, n, err := readRecord(r.f, r.off)
+		if err != nil {
+			return nil, fmt.Errorf("读取预写日志记录 %d 失败: %w", r.next, err)
+		}
+		r.off += n
+		if rec.Seq < r.next {
+			// 段内定位到目标序号
+			continue
+		}
+		r.next = rec.Seq + 1
+		if s.isCommitted(rec.Seq) {
+			continue
+		}
+		return rec, nil
+	}
+}
+
+// Close 关闭读取器
+func (r *Reader) Close() error {
+	return r.closeFile()
+}
+
+func (r *Reader) closeFile() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// wake 唤醒等待新记录的读取器
+func (s *Spool) wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// segmentFor 包含指定序号的段；序号早于所有段时返回第一段，调用方需持有锁
+func (s *Spool) segmentFor(seq uint64) *segment {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	i := sort.Search(len(s.segments), func(i int) bool { return s.segments[i].firstSeq > seq })
+	if i == 0 {
+		return s.segments[0]
+	}
+	return s.segments[i-1]
+}
@@ -0,0 +1,514 @@
+// Package spool 本地磁盘预写日志（WAL），位于行情接收与写库之间
+//
+// 段文件格式：
+//   - 文件头：8字节魔数 "BQSPL001"
+//   - 记录：[8字节序号][8字节接收时间 UnixNano][4字节消息体长度][4字节CRC32][消息体]，整数均为大端序
+//
+// 段文件以第一条记录的序号命名（%020d.seg），写满 SegmentSize 后滚动。
+// 消费方写库成功后调用 Commit，序号连续提交的位置即检查点，定期持久化到 checkpoint 文件；
+// 重启后从检查点之后继续读取，未提交的消息不会丢失（至少一次）。
+// 完全位于检查点之前的段在超过 Retention 后删除。
+// 进程崩溃可能留下不完整的末尾记录，Open 时截断。
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	magic          = "BQSPL001"
+	headerSize     = 24       // 序号8字节 + 接收时间8字节 + 长度4字节 + CRC4字节
+	maxBody        = 64 << 20 // 单条记录上限，防止读到损坏文件时分配超大内存
+	segmentSuffix  = ".seg"   // 段文件扩展名
+	checkpointFile = "checkpoint"
+
+	defaultSegmentSize  = 64 << 20 // 默认单段64MB
+	defaultSyncInterval = time.Second
+)
+
+var (
+	// ErrClosed 预写日志已关闭
+	ErrClosed = errors.New("预写日志已关闭")
+	// ErrBadMagic 文件不是预写日志段文件
+	ErrBadMagic = errors.New("不是预写日志段文件")
+)
+
+// Options 预写日志配置
+type Options struct {
+	SegmentSize  int64         // 单段最大字节数，<=0 时使用默认值64MB
+	Retention    time.Duration // 已提交的段保留时长，0 表示提交后尽快删除
+	SyncInterval time.Duration // 刷盘、保存检查点和清理的间隔，<=0 时使用默认值1秒
+}
+
+// Record 一条预写日志记录
+type Record struct {
+	Seq        uint64    // 序号，从1开始连续递增
+	ReceivedAt time.Time // 接收时间
+	Body       []byte    // 原始消息体
+}
+
+// Stats 预写日志状态
+type Stats struct {
+	Segments  int    `json:"segments"`  // 段文件个数
+	Bytes     int64  `json:"bytes"`     // 段文件总字节数
+	LastSeq   uint64 `json:"lastSeq"`   // 最后写入的序号
+	Committed uint64 `json:"committed"` // 检查点：该序号及之前的记录均已提交
+	Depth     uint64 `json:"depth"`     // 未提交的记录数（积压深度）
+}
+
+// segment 段文件信息
+type segment struct {
+	path     string
+	firstSeq uint64
+	lastSeq  uint64 // 段内最后一条记录的序号，空段为 firstSeq-1
+	size     int64
+	modTime  time.Time
+}
+
+// Spool 预写日志，可并发使用
+type Spool struct {
+	dir  string
+	opts Options
+
+	mu        sync.Mutex
+	segments  []*segment
+	active    *os.File // 当前写入的段
+	lastSeq   uint64
+	committed uint64              // 连续提交的位置
+	done      map[uint64]struct{} // 已提交但前面仍有未提交记录的序号
+	saved     uint64              // 已持久化的检查点
+	notify    chan struct{}       // 有新记录时关闭并替换，唤醒等待的读取器
+	closed    bool
+	buf       []byte
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open 打开（或创建）预写日志目录，截断末尾不完整的记录并恢复检查点
+func Open(dir string, opts Options) (*Spool, error) {
+	if dir == "" {
+		return nil, errors.New("预写日志目录不能为空")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建预写日志目录失败: %w", err)
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+
+	s := &Spool{
+		dir:    dir,
+		opts:   opts,
+		done:   make(map[uint64]struct{}),
+		notify: make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	committed, err := readCheckpoint(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return nil, err
+	}
+	s.committed, s.saved = committed, committed
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.syncLoop()
+	return s, nil
+}
+
+// load 扫描段文件，确定最后序号并以追加方式打开最后一段
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, &segment{
+			path:     filepath.Join(s.dir, name),
+			firstSeq: first,
+			size:     info.Size(),
+			modTime:  info.ModTime(),
+		})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].firstSeq < s.segments[j].firstSeq })
+	for i := 0; i+1 < len(s.segments); i++ {
+		s.segments[i].lastSeq = s.segments[i+1].firstSeq - 1
+	}
+
+	if len(s.segments) == 0 {
+		// 段全部清理后从检查点继续编号
+		s.lastSeq = s.committed
+		return nil
+	}
+
+	last := s.segments[len(s.segments)-1]
+	lastSeq, end, err := scanSegment(last.path, last.firstSeq)
+	if err != nil {
+		return err
+	}
+	if end < last.size {
+		if err := os.Truncate(last.path, end); err != nil {
+			return fmt.Errorf("截断不完整的预写日志失败: %w", err)
+		}
+		last.size = end
+	}
+	last.lastSeq = lastSeq
+	s.lastSeq = lastSeq
+	if s.committed > s.lastSeq {
+		s.lastSeq = s.committed
+	}
+
+	f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("打开预写日志失败: %w", err)
+	}
+	s.active = f
+	return nil
+}
+
+// scanSegment 顺序校验段文件，返回最后一条完整记录的序号和其结束位置
+func scanSegment(path string, firstSeq uint64) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, head); err != nil || string(head) != magic {
+		return 0, 0, fmt.Errorf("%s: %w", path, ErrBadMagic)
+	}
+	off := int64(len(magic))
+	lastSeq := firstSeq - 1
+	for {
+		rec, n, err := readRecord(f, off)
+		if err != nil {
+			// 末尾不完整或校验失败：之后的内容视为崩溃残留
+			return lastSeq, off, nil
+		}
+		if rec.Seq != lastSeq+1 {
+			return lastSeq, off, nil
+		}
+		lastSeq = rec.Seq
+		off += n
+	}
+}
+
+// readRecord 读取 off 处的一条记录，返回记录和占用的字节数
+func readRecord(f *os.File, off int64) (*Record, int64, error) {
+	var hdr [headerSize]byte
+	if _, err := f.ReadAt(hdr[:], off); err != nil {
+		return nil, 0, err
+	}
+	seq := binary.BigEndian.Uint64(hdr[0:8])
+	nanos := int64(binary.BigEndian.Uint64(hdr[8:16]))
+	size := binary.BigEndian.Uint32(hdr[16:20])
+	sum := binary.BigEndian.Uint32(hdr[20:24])
+	if size > maxBody {
+		return nil, 0, fmt.Errorf("记录长度异常: %d 字节", size)
+	}
+	body := make([]byte, size)
+	if _, err := f.ReadAt(body, off+headerSize); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, 0, fmt.Errorf("记录 %d 校验失败", seq)
+	}
+	return &Record{Seq: seq, ReceivedAt: time.Unix(0, nanos), Body: body}, headerSize + int64(size), nil
+}
+
+// Append 追加一条记录，返回分配的序号
+// 每条记录一次系统调用写出，进程崩溃时不丢失；断电保护取决于 SyncInterval，需要立即落盘时调用 Flush
+func (s *Spool) Append(receivedAt time.Time, body []byte) (uint64, error) {
+	if len(body) > maxBody {
+		return 0, fmt.Errorf("消息体过大: %d 字节", len(body))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	seq := s.lastSeq + 1
+	recLen := int64(headerSize + len(body))
+	if cur := s.current(); cur == nil || (cur.size > int64(len(magic)) && cur.size+recLen > s.opts.SegmentSize) {
+		if err := s.rotate(seq); err != nil {
+			return 0, err
+		}
+	}
+
+	s.buf = s.buf[:0]
+	s.buf = binary.BigEndian.AppendUint64(s.buf, seq)
+	s.buf = binary.BigEndian.AppendUint64(s.buf, uint64(receivedAt.UnixNano()))
+	s.buf = binary.BigEndian.AppendUint32(s.buf, uint32(len(body)))
+	s.buf = binary.BigEndian.AppendUint32(s.buf, crc32.ChecksumIEEE(body))
+	s.buf = append(s.buf, body...)
+	n, err := s.active.Write(s.buf)
+	cur := s.current()
+	cur.size += int64(n)
+	if err != nil {
+		// 写入不完整时截掉残留，保证后续记录可读
+		if terr := s.active.Truncate(cur.size - int64(n)); terr == nil {
+			cur.size -= int64(n)
+		}
+		return 0, fmt.Errorf("写入预写日志失败: %w", err)
+	}
+
+	cur.lastSeq = seq
+	cur.modTime = time.Now()
+	s.lastSeq = seq
+	close(s.notify)
+	s.notify = make(chan struct{})
+	return seq, nil
+}
+
+// current 当前写入的段，调用方需持有锁
+func (s *Spool) current() *segment {
+	if s.active == nil || len(s.segments) == 0 {
+		return nil
+	}
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate 关闭当前段并以 firstSeq 创建新段，调用方需持有锁
+func (s *Spool) rotate(firstSeq uint64) error {
+	if s.active != nil {
+		if err := s.active.Sync(); err != nil {
+			return fmt.Errorf("刷盘失败: %w", err)
+		}
+		if err := s.active.Close(); err != nil {
+			return fmt.Errorf("关闭预写日志段失败: %w", err)
+		}
+		s.active = nil
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", firstSeq, segmentSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("创建预写日志段失败: %w", err)
+	}
+	if _, err := f.WriteString(magic); err != nil {
+		f.Close()
+		return fmt.Errorf("写入预写日志段头失败: %w", err)
+	}
+	s.active = f
+	s.segments = append(s.segments, &segment{
+		path:     path,
+		firstSeq: firstSeq,
+		lastSeq:  firstSeq - 1,
+		size:     int64(len(magic)),
+		modTime:  time.Now(),
+	})
+	return nil
+}
+
+// Commit 标记记录已处理（写库成功），序号连续时推进检查点
+// 重复提交或提交检查点之前的序号无影响
+func (s *Spool) Commit(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq <= s.committed || seq > s.lastSeq {
+		return
+	}
+	s.done[seq] = struct{}{}
+	for {
+		if _, ok := s.done[s.committed+1]; !ok {
+			break
+		}
+		delete(s.done, s.committed+1)
+		s.committed++
+	}
+}
+
+// isCommitted 记录是否已提交
+func (s *Spool) isCommitted(seq uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq <= s.committed {
+		return true
+	}
+	_, ok := s.done[seq]
+	return ok
+}
+
+// Committed 检查点：该序号及之前的记录均已提交
+func (s *Spool) Committed() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.committed
+}
+
+// Stats 当前状态
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Stats{
+		Segments:  len(s.segments),
+		LastSeq:   s.lastSeq,
+		Committed: s.committed,
+		Depth:     s.lastSeq - s.committed,
+	}
+	for _, seg := range s.segments {
+		st.Bytes += seg.size
+	}
+	return st
+}
+
+// Flush 把已追加的记录刷到磁盘，返回后这些记录断电也不会丢失；不保存检查点
+func (s *Spool) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		return nil
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("刷盘失败: %w", err)
+	}
+	return nil
+}
+
+// Sync 刷盘、保存检查点并清理过期段
+func (s *Spool) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncLocked()
+}
+
+func (s *Spool) syncLocked() error {
+	if s.active != nil {
+		if err := s.active.Sync(); err != nil {
+			return fmt.Errorf("刷盘失败: %w", err)
+		}
+	}
+	if s.committed != s.saved {
+		if err := writeCheckpoint(filepath.Join(s.dir, checkpointFile), s.committed); err != nil {
+			return err
+		}
+		s.saved = s.committed
+	}
+	return s.pruneLocked(time.Now())
+}
+
+// pruneLocked 删除全部已提交且超过保留时长的段，当前写入的段不删除
+func (s *Spool) pruneLocked(now time.Time) error {
+	for len(s.segments) > 1 {
+		seg := s.segments[0]
+		if seg.lastSeq > s.saved || now.Sub(seg.modTime) < s.opts.Retention {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除预写日志段失败: %w", err)
+		}
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+// syncLoop 定期刷盘
+func (s *Spool) syncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Sync()
+		}
+	}
+}
+
+// Close 刷盘、保存检查点并关闭，等待中的读取器返回 ErrClosed
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.notify)
+	s.mu.Unlock()
+
+	close(s.stop)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.syncLocked()
+	if s.active != nil {
+		if cerr := s.active.Close(); err == nil {
+			err = cerr
+		}
+		s.active = nil
+	}
+	return err
+}
+
+// readCheckpoint 读取检查点文件，不存在时为0
+func readCheckpoint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("读取检查点失败: %w", err)
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("检查点文件损坏: %w", err)
+	}
+	return seq, nil
+}
+
+// writeCheckpoint 先写临时文件再改名，保证检查点文件完整
+func writeCheckpoint(path string, seq uint64) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("保存检查点失败: %w", err)
+	}
+	if _, err := f.WriteString(strconv.FormatUint(seq, 10)); err != nil {
+		f.Close()
+		return fmt.Errorf("保存检查点失败: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("保存检查点失败: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("保存检查点失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("保存检查点失败: %w", err)
+	}
+	return nil
+}
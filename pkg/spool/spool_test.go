@@ -0,0 +1,223 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func appendN(t *testing.T, s *Spool, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := s.Append(time.Now(), []byte(fmt.Sprintf("msg-%d", i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readN(t *testing.T, r *Reader, n int) []uint64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	seqs := make([]uint64, 0, n)
+	for i := 0; i < n; i++ {
+		rec, err := r.Next(ctx)
+		if err != nil {
+			t.Fatalf("读取第%d条失败: %v", i+1, err)
+		}
+		if want := fmt.Sprintf("msg-%d", rec.Seq); string(rec.Body) != want {
+			t.Fatalf("记录 %d 内容 = %q", rec.Seq, rec.Body)
+		}
+		seqs = append(seqs, rec.Seq)
+	}
+	return seqs
+}
+
+func TestSpoolReplayFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{SegmentSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, s, 10)
+	r := s.NewReader()
+	if got := readN(t, r, 10); got[0] != 1 || got[9] != 10 {
+		t.Fatalf("读取序号 = %v", got)
+	}
+
+	// 乱序提交：1-3、5 提交，4 未提交，检查点停在3
+	for _, seq := range []uint64{2, 1, 3, 5} {
+		s.Commit(seq)
+	}
+	if st := s.Stats(); st.Committed != 3 || st.Depth != 7 || st.LastSeq != 10 || st.Segments < 2 {
+		t.Fatalf("状态 = %+v", st)
+	}
+	r.Close()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启：从检查点之后重读，之前已提交的5不在内存中，会再次投递（至少一次）
+	s, err = Open(dir, Options{SegmentSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if st := s.Stats(); st.Committed != 3 || st.LastSeq != 10 {
+		t.Fatalf("重启后状态 = %+v", st)
+	}
+	r = s.NewReader()
+	defer r.Close()
+	if got := readN(t, r, 7); got[0] != 4 || got[6] != 10 {
+		t.Fatalf("重启后读取序号 = %v", got)
+	}
+	// 序号在重启后继续递增
+	if seq, err := s.Append(time.Now(), []byte("msg-11")); err != nil || seq != 11 {
+		t.Fatalf("重启后追加序号 = %d, err = %v", seq, err)
+	}
+}
+
+func TestSpoolRewindSkipsCommitted(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{SegmentSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	appendN(t, s, 6)
+	r := s.NewReader()
+	defer r.Close()
+	readN(t, r, 6)
+
+	// 2 写库失败，其余成功：回退到2只重读2
+	for _, seq := range []uint64{1, 3, 4, 5, 6} {
+		s.Commit(seq)
+	}
+	r.Seek(2)
+	if got := readN(t, r, 1); got[0] != 2 {
+		t.Fatalf("回退后读取 = %v", got)
+	}
+	s.Commit(2)
+	if got := s.Committed(); got != 6 {
+		t.Fatalf("检查点 = %d", got)
+	}
+
+	// 读到末尾时阻塞，直到有新记录
+	got := make(chan uint64, 1)
+	go func() {
+		rec, err := r.Next(context.Background())
+		if err == nil {
+			got <- rec.Seq
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	appendN(t, s, 1)
+	select {
+	case seq := <-got:
+		if seq != 7 {
+			t.Fatalf("新记录序号 = %d", seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("读取器未被新记录唤醒")
+	}
+}
+
+func TestSpoolRetention(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{SegmentSize: 100, SyncInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	appendN(t, s, 10)
+	segments := s.Stats().Segments
+	if segments < 3 {
+		t.Fatalf("段数 = %d, 期望按大小滚动", segments)
+	}
+
+	// 未提交的段不删除
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Stats().Segments; got != segments {
+		t.Fatalf("未提交时段数 = %d", got)
+	}
+
+	for seq := uint64(1); seq <= 10; seq++ {
+		s.Commit(seq)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// 当前写入的段保留
+	if st := s.Stats(); st.Segments != 1 || st.Depth != 0 {
+		t.Fatalf("清理后状态 = %+v", st)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(files) != 1 {
+		t.Fatalf("段文件 = %v", files)
+	}
+}
+
+func TestSpoolTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, s, 3)
+	s.Close()
+
+	// 模拟崩溃时写了一半的记录
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 4, 1, 2, 3})
+	f.Close()
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if seq, err := s.Append(time.Now(), []byte("msg-4")); err != nil || seq != 4 {
+		t.Fatalf("截断后追加序号 = %d, err = %v", seq, err)
+	}
+	r := s.NewReader()
+	defer r.Close()
+	if got := readN(t, r, 4); got[3] != 4 {
+		t.Fatalf("读取序号 = %v", got)
+	}
+}
+
+func TestSpoolCloseWakesReader(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.NewReader()
+	defer r.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Next(context.Background())
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("err = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("关闭后读取器未返回")
+	}
+	if _, err := s.Append(time.Now(), []byte("x")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("关闭后追加 err = %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wealth-bond-quote-service/internal/dataSource"
+	"wealth-bond-quote-service/pkg/capture"
+	logger "wealth-bond-quote-service/pkg/log"
+	"wealth-bond-quote-service/service"
+)
+
+// runReplay 回放录制文件写入数据库，用于复现线上问题和数据库故障后补数
+// 用法：wealth-bond-quote-aden replay [-speed 1] [-workers 4] <录制文件或目录>...
+// 消息按自身时间戳写入对应业务日期的表，与线上使用同一交易日历
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	speed := fs.Float64("speed", 1, "回放倍速：1 原速，10 十倍速，0 尽快回放")
+	workers := fs.Int("workers", workerNum, "解析/写库协程数")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: replay [-speed 1] [-workers N] <录制文件或目录>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// 目录展开为其中的录制文件（按时间排序）
+	var paths []string
+	for _, arg := range fs.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("无法读取 %s: %v\n", arg, err)
+			return 1
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		files, err := capture.Files(arg)
+		if err != nil {
+			fmt.Printf("无法读取目录 %s: %v\n", arg, err)
+			return 1
+		}
+		paths = append(paths, files...)
+	}
+	if *workers <= 0 {
+		*workers = 1
+	}
+	if batchSize <= 0 {
+		batchSize = 300
+	}
+	if flushDelay <= 0 {
+		flushDelay = 100 * time.Millisecond
+	}
+
+	initCalendar()
+	db := dataSource.GetDBConn("bond")
+	tables := service.NewCreateTableService(db)
+
+	RawChan := make(chan *service.RawMessage, rawCap)
+	ParsedChan := make(chan *service.ParsedQuote, parsedCap)
+	DeadChan := make(chan *service.DeadMessage, 1000)
+
+	// 解析层和写库层分开等待，保证解析协程退出后再关闭 ParsedChan
+	var parseWg, dbWg sync.WaitGroup
+	service.NewBondQuoteService(db, &parseWg, RawChan, ParsedChan, DeadChan).StartParseWorkers(*workers)
+	dbWriter := service.NewBondQuoteService(db, &dbWg, RawChan, ParsedChan, DeadChan)
+	dbWriter.StartDBWorkers(*workers, batchSize, flushDelay)
+
+	var dead atomic.Int64
+	deadDone := make(chan struct{})
+	go func() {
+		defer close(deadDone)
+		for msg := range DeadChan {
+			dead.Add(1)
+			logger.Warn("回放消息解析失败: %s, 内容: %.200s", msg.Reason, msg.Body)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			fmt.Println("收到中断信号，停止回放...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	fmt.Printf("开始回放 %d 个录制文件，倍速: %g\n", len(paths), *speed)
+	start := time.Now()
+	seenDays := make(map[string]bool)
+	replayer := &capture.Replayer{Speed: *speed}
+	n, err := replayer.Replay(ctx, paths, func(rec *capture.Record) error {
+		// 补数时目标日期的表可能尚未创建
+		date := service.BusinessDate(rec.ReceivedAt)
+		if day := date.Format("20060102"); !seenDays[day] {
+			if err := tables.EnsureDailyTablesExist(date); err != nil {
+				return err
+			}
+			seenDays[day] = true
+		}
+		select {
+		case RawChan <- &service.RawMessage{Body: rec.Body, ReceivedAt: rec.ReceivedAt}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	// 按层关闭：解析层退出后才能关闭 ParsedChan 和 DeadChan
+	close(RawChan)
+	parseWg.Wait()
+	close(ParsedChan)
+	close(DeadChan)
+	dbWg.Wait()
+	<-deadDone
+
+	var duplicates, stale int64
+	for _, st := range dbWriter.SinkStats() {
+		duplicates, stale = duplicates+st.Duplicates, stale+st.Stale
+	}
+	fmt.Printf("回放结束：共 %d 条，解析失败 %d 条，已存在跳过明细 %d 行，旧行情未覆盖最新价 %d 条，耗时 %s\n",
+		n, dead.Load(), duplicates, stale, time.Since(start).Round(time.Millisecond))
+	if err != nil && err != context.Canceled {
+		fmt.Printf("回放中断: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,167 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+
+	"wealth-bond-quote-service/service"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// DeadLetterHandler 死信处理器
+type DeadLetterHandler struct {
+	deadLetterService *service.DeadLetterService
+}
+
+// NewDeadLetterHandler 创建死信处理器
+func NewDeadLetterHandler(deadLetterService *service.DeadLetterService) *DeadLetterHandler {
+	return &DeadLetterHandler{
+		deadLetterService: deadLetterService,
+	}
+}
+
+// RegisterRoutes 注册路由
+func (h *DeadLetterHandler) RegisterRoutes(app *fiber.App) {
+	group := app.Group("/v1/api/bond/dead-letter")
+
+	// 分页查询死信
+	group.Get("/", h.List)
+	// 查看单条死信（含原始消息）
+	group.Get("/:id", h.Get)
+	// 重新处理死信
+	group.Post("/reprocess", h.Reprocess)
+	// 清理死信
+	group.Delete("/", h.Purge)
+}
+
+// List 分页查询死信
+func (h *DeadLetterHandler) List(c *fiber.Ctx) error {
+	var param service.DeadLetterQuery
+	if err := c.QueryParser(&param); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code": 400,
+			"msg":  "参数错误: " + err.Error(),
+		})
+	}
+
+	list, total, err := h.deadLetterService.List(param)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code": 500,
+			"msg":  "查询失败: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"code": 200,
+		"msg":  "查询成功",
+		"data": fiber.Map{
+			"list":  list,
+			"total": total,
+		},
+	})
+}
+
+// Get 查看单条死信
+func (h *DeadLetterHandler) Get(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code": 400,
+			"msg":  "参数错误: id必须为数字",
+		})
+	}
+
+	dl, err := h.deadLetterService.Get(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"code": 404,
+			"msg":  "死信不存在",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code": 500,
+			"msg":  "查询失败: " + err.Error(),
+		})
+	}
+
+	// 原始消息同时以文本返回，便于直接查看
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"code": 200,
+		"msg":  "查询成功",
+		"data": fiber.Map{
+			"deadLetter": dl,
+			"raw":        string(dl.RawBody),
+		},
+	})
+}
+
+// Reprocess 重新处理死信，条件放在请求体中
+func (h *DeadLetterHandler) Reprocess(c *fiber.Ctx) error {
+	var param service.DeadLetterQuery
+	if err := c.BodyParser(&param); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code": 400,
+			"msg":  "参数错误: " + err.Error(),
+		})
+	}
+
+	// 参数验证：避免误操作重新处理全部历史死信
+	if len(param.IDs) == 0 && param.Date == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code": 400,
+			"msg":  "日期和ID不能同时为空",
+		})
+	}
+
+	result, err := h.deadLetterService.Reprocess(param)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code": 500,
+			"msg":  "重新处理失败: " + err.Error(),
+			"data": result,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"code": 200,
+		"msg":  "重新处理完成",
+		"data": result,
+	})
+}
+
+// Purge 清理死信
+func (h *DeadLetterHandler) Purge(c *fiber.Ctx) error {
+	var param service.DeadLetterQuery
+	if err := c.QueryParser(&param); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code": 400,
+			"msg":  "参数错误: " + err.Error(),
+		})
+	}
+
+	deleted, err := h.deadLetterService.Purge(param)
+	if errors.Is(err, service.ErrPurgeWithoutFilter) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code": 400,
+			"msg":  err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code": 500,
+			"msg":  "清理失败: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"code": 200,
+		"msg":  "清理成功",
+		"data": fiber.Map{
+			"deleted": deleted,
+		},
+	})
+}
@@ -1,6 +1,8 @@
 package router
 
 import (
+	"errors"
+
 	"wealth-bond-quote-service/service"
 
 	"github.com/gofiber/fiber/v2"
@@ -28,6 +30,86 @@ func (h *QueryHandler) RegisterRoutes(app *fiber.App) {
 	queryGroup.Get("/daily-end", h.ExportDailyEndData)
 	// 导出时间段数据
 	queryGroup.Get("/time-range", h.ExportTimeRangeData)
+	// 全部债券的最优买卖价、中间价、价差和深度
+	queryGroup.Get("/books", h.ListOrderBooks)
+	// 单个债券的完整订单簿
+	queryGroup.Get("/books/:isin", h.GetOrderBook)
+	// 报价单的生命周期事件（NEW/AMEND/CANCEL）
+	queryGroup.Get("/quote-orders/:quoteOrderNo/events", h.GetQuoteOrderHistory)
+}
+
+// GetQuoteOrderHistory 查询报价单的生命周期事件，可按日期范围查询，默认当天
+func (h *QueryHandler) GetQuoteOrderHistory(c *fiber.Ctx) error {
+	var param service.DateRangeParam
+	if err := c.QueryParser(&param); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"code": 400,
+			"msg":  "参数错误: " + err.Error(),
+		})
+	}
+
+	events, err := h.queryService.QuoteOrderHistory(c.Params("quoteOrderNo"), param)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code": 500,
+			"msg":  "查询失败: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"code": 200,
+		"msg":  "查询成功",
+		"data": fiber.Map{
+			"list":  events,
+			"total": len(events),
+		},
+	})
+}
+
+// ListOrderBooks 查询全部订单簿摘要
+func (h *QueryHandler) ListOrderBooks(c *fiber.Ctx) error {
+	list, err := h.queryService.OrderBookSummaries()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code": 500,
+			"msg":  "查询失败: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"code": 200,
+		"msg":  "查询成功",
+		"data": fiber.Map{
+			"list":  list,
+			"total": len(list),
+		},
+	})
+}
+
+// GetOrderBook 查询单个债券的订单簿
+func (h *QueryHandler) GetOrderBook(c *fiber.Ctx) error {
+	book, err := h.queryService.OrderBook(c.Params("isin"))
+	if errors.Is(err, service.ErrOrderBookNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"code": 404,
+			"msg":  "订单簿不存在",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"code": 500,
+			"msg":  "查询失败: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"code": 200,
+		"msg":  "查询成功",
+		"data": fiber.Map{
+			"book":    book,
+			"summary": book.Summary(),
+		},
+	})
 }
 
 // ExportCurrentLatestQuotes 导出当前最新行情
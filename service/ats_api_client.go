@@ -0,0 +1,196 @@
+package service
+
+// ATS加密接口客户端
+// ATS的HTTP接口统一使用RSA+AES混合加密信封：
+// 1. 请求：随机AES密钥加密JSON请求体（reqMsg），RSA公钥加密AES密钥（reqKey），附带clientId
+// 2. 响应：AES加密的响应体（resMsg），服务端私钥"加密"的AES密钥（resKey），客户端用公钥还原
+// 3. 解密后的响应体为 {code, msg, data}，code 为200表示成功
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	config "wealth-bond-quote-service/internal/conf"
+	utils "wealth-bond-quote-service/pkg/crypto_utils"
+	logger "wealth-bond-quote-service/pkg/log"
+)
+
+// LoginPath 登录接口路径
+const LoginPath = "/cust-gateway/cust-auth/account/outApi/doLogin"
+
+// atsCodeOK ATS接口成功状态码
+const atsCodeOK = 200
+
+var (
+	ErrAtsBadRequest  = errors.New("ATS请求参数错误")
+	ErrAtsNotFound    = errors.New("ATS接口或数据不存在")
+	ErrAtsRateLimited = errors.New("ATS请求过于频繁")
+	ErrAtsServer      = errors.New("ATS服务端错误")
+)
+
+// atsErrorCodes ATS状态码到错误类型的映射，未列出的状态码按区间归类
+// 认证类错误映射为 ErrAuthRejected，调用方可用 IsAuthError 判断后重新登录
+var atsErrorCodes = map[int]error{
+	400: ErrAtsBadRequest,
+	401: ErrAuthRejected,
+	403: ErrAuthRejected,
+	404: ErrAtsNotFound,
+	429: ErrAtsRateLimited,
+}
+
+// AtsError ATS接口返回的业务错误
+type AtsError struct {
+	Path string // 接口路径
+	Code int    // ATS状态码（或HTTP状态码）
+	Msg  string // ATS返回的错误描述
+}
+
+func (e *AtsError) Error() string {
+	return fmt.Sprintf("ATS接口%s返回错误 code=%d: %s", e.Path, e.Code, e.Msg)
+}
+
+// Unwrap 按状态码归类，支持 errors.Is(err, ErrAuthRejected) 等判断
+func (e *AtsError) Unwrap() error {
+	if err, ok := atsErrorCodes[e.Code]; ok {
+		return err
+	}
+	if e.Code >= 500 {
+		return ErrAtsServer
+	}
+	return nil
+}
+
+// AtsResponse 解密后的ATS响应
+type AtsResponse[T any] struct {
+	Code int    `json:"code"` // 响应状态码，200表示成功
+	Msg  string `json:"msg"`  // 响应消息，成功或错误描述
+	Data T      `json:"data"` // 业务数据
+}
+
+// AtsAPIClient ATS加密接口客户端
+type AtsAPIClient struct {
+	BaseURL    string       // 接口基础地址
+	ClientID   string       // 客户端标识符
+	PublicKey  string       // ATS公钥（Base64编码的PKIX）
+	HTTPClient *http.Client // HTTP客户端，TLS设置见 AtsTransport
+}
+
+// NewAtsAPIClient 根据ATS配置创建接口客户端
+func NewAtsAPIClient(cfg *config.AdenATSConfig, transport *AtsTransport) *AtsAPIClient {
+	c := &AtsAPIClient{
+		BaseURL:   cfg.BaseURL,
+		ClientID:  cfg.ClientId,
+		PublicKey: cfg.PublicKey,
+	}
+	if transport != nil {
+		c.HTTPClient = transport.HTTPClient
+	} else {
+		c.HTTPClient = &http.Client{Timeout: defaultClientTimeout}
+	}
+	return c
+}
+
+// CallAts 调用ATS加密接口
+// 请求体序列化后加密发送，响应解密后把 data 解析为 Resp
+// token 为空时不带认证头（如登录接口）
+func CallAts[Req, Resp any](ctx context.Context, c *AtsAPIClient, path, token string, req Req) (Resp, error) {
+	var zero Resp
+
+	plain, err := c.do(ctx, path, token, req)
+	if err != nil {
+		return zero, err
+	}
+
+	var resp AtsResponse[json.RawMessage]
+	if err := json.Unmarshal(plain, &resp); err != nil {
+		return zero, fmt.Errorf("响应解析失败: %v", err)
+	}
+	if resp.Code != atsCodeOK {
+		return zero, &AtsError{Path: path, Code: resp.Code, Msg: resp.Msg}
+	}
+
+	var data Resp
+	if len(resp.Data) > 0 && string(resp.Data) != "null" {
+		if err := json.Unmarshal(resp.Data, &data); err != nil {
+			return zero, fmt.Errorf("响应数据解析失败: %v", err)
+		}
+	}
+	return data, nil
+}
+
+// do 加密请求、发送并解密响应，返回解密后的响应体
+func (c *AtsAPIClient) do(ctx context.Context, path, token string, req any) ([]byte, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("JSON序列化失败: %v", err)
+	}
+
+	// 加密请求
+	msg, key, err := utils.EncryptRequest(string(jsonData), c.PublicKey, c.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("请求加密失败: %v", err)
+	}
+	reqBody, err := json.Marshal(EncryptedRequest{ReqMsg: msg, ReqKey: key, ClientId: c.ClientID})
+	if err != nil {
+		return nil, fmt.Errorf("加密请求序列化失败: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("构建请求失败: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+		httpReq.Header.Set("token", token)
+	}
+
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+	logger.Debug("ATS接口 %s 响应状态: %s", path, resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &AtsError{Path: path, Code: resp.StatusCode, Msg: string(bytes.TrimSpace(respBody))}
+	}
+
+	var encryptedResp EncryptedResponse
+	if err := json.Unmarshal(respBody, &encryptedResp); err != nil {
+		return nil, fmt.Errorf("加密响应解析失败: %v", err)
+	}
+	return c.decrypt(encryptedResp)
+}
+
+// decrypt 公钥还原AES密钥，再用AES-ECB解密响应体
+func (c *AtsAPIClient) decrypt(encryptedResp EncryptedResponse) ([]byte, error) {
+	pubKeyBytes, err := base64.StdEncoding.DecodeString(c.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("公钥Base64解码失败: %v", err)
+	}
+	aesKey, err := utils.RsaDecryptWithPub(pubKeyBytes, encryptedResp.ResKey)
+	if err != nil {
+		return nil, fmt.Errorf("RSA解密AES密钥失败: %v", err)
+	}
+
+	realAESKey, err := base64.StdEncoding.DecodeString(string(aesKey)) // Base64解码
+	if err != nil {
+		return nil, fmt.Errorf("Base64解码AES密钥失败: %v", err)
+	}
+	decryptedResp, err := utils.AesDecryptECB(encryptedResp.ResMsg, realAESKey)
+	if err != nil {
+		return nil, fmt.Errorf("AES解密响应失败: %v", err)
+	}
+	return decryptedResp, nil
+}
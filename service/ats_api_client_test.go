@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"wealth-bond-quote-service/internal/atsmock"
+)
+
+type bondListRequest struct {
+	Market string `json:"market"`
+}
+
+type bondInfo struct {
+	ISIN string `json:"isin"`
+	Name string `json:"name"`
+}
+
+func TestCallAts(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+
+	const path = "/ats-api/bond/list"
+	srv.HandleAPI(path, func(raw json.RawMessage) (int, string, any) {
+		var req bondListRequest
+		if err := json.Unmarshal(raw, &req); err != nil || req.Market == "" {
+			return 400, "market不能为空", nil
+		}
+		if req.Market == "BUSY" {
+			return 429, "请求过于频繁", nil
+		}
+		if req.Market == "BROKEN" {
+			return 503, "系统维护中", nil
+		}
+		return 200, "成功", []bondInfo{{ISIN: "HK0000000001", Name: req.Market + "债券"}}
+	})
+
+	cfg := newTestATSConfig(srv)
+	api := NewAtsAPIClient(cfg, nil)
+	client := &StompClient{}
+	if err := client.Login(cfg.Username, cfg.Password, cfg.SmsCode, cfg.PublicKey, cfg.BaseURL, cfg.ClientId); err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	ctx := context.Background()
+
+	bonds, err := CallAts[bondListRequest, []bondInfo](ctx, api, path, client.Token, bondListRequest{Market: "HK"})
+	if err != nil {
+		t.Fatalf("调用失败: %v", err)
+	}
+	if len(bonds) != 1 || bonds[0].ISIN != "HK0000000001" || bonds[0].Name != "HK债券" {
+		t.Fatalf("响应数据 = %+v", bonds)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		market string
+		want   error
+		code   int
+	}{
+		{"token无效", "expired", "HK", ErrAuthRejected, 401},
+		{"参数错误", client.Token, "", ErrAtsBadRequest, 400},
+		{"限流", client.Token, "BUSY", ErrAtsRateLimited, 429},
+		{"服务端错误", client.Token, "BROKEN", ErrAtsServer, 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CallAts[bondListRequest, []bondInfo](ctx, api, path, tt.token, bondListRequest{Market: tt.market})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("错误 = %v, 期望 %v", err, tt.want)
+			}
+			var atsErr *AtsError
+			if !errors.As(err, &atsErr) || atsErr.Code != tt.code {
+				t.Fatalf("错误码 = %v, 期望 %d", err, tt.code)
+			}
+		})
+	}
+
+	// 认证错误可被会话识别并触发重新登录
+	_, err = CallAts[bondListRequest, []bondInfo](ctx, api, path, "expired", bondListRequest{Market: "HK"})
+	if !IsAuthError(err) {
+		t.Fatalf("IsAuthError(%v) = false", err)
+	}
+
+	// 未注册的接口返回HTTP 404
+	_, err = CallAts[bondListRequest, []bondInfo](ctx, api, "/ats-api/missing", client.Token, bondListRequest{Market: "HK"})
+	var atsErr *AtsError
+	if !errors.As(err, &atsErr) || atsErr.Code != http.StatusNotFound || !errors.Is(err, ErrAtsNotFound) {
+		t.Fatalf("未知接口错误 = %v", err)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+	cfg := newTestATSConfig(srv)
+
+	client := &StompClient{}
+	err := client.Login(cfg.Username, "wrong", cfg.SmsCode, cfg.PublicKey, cfg.BaseURL, cfg.ClientId)
+	var atsErr *AtsError
+	if !errors.As(err, &atsErr) || atsErr.Msg != "用户名或密码错误" {
+		t.Fatalf("登录错误 = %v", err)
+	}
+	if client.Token != "" {
+		t.Fatal("登录失败不应设置token")
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/pkg/capture"
+	"wealth-bond-quote-service/pkg/db"
+)
+
+// newTestDB 创建SQLite测试库并建好当天的表
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn, err := db.InitSqliteConn(filepath.Join(t.TempDir(), "bond.db"))
+	if err != nil {
+		t.Fatalf("打开SQLite失败: %v", err)
+	}
+	if err := NewCreateTableService(conn).EnsureDailyTablesExist(time.Now()); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	return conn
+}
+
+// newTestATSConfig 指向模拟网关的ATS配置
+func newTestATSConfig(srv *atsmock.Server) *config.AdenATSConfig {
+	return &config.AdenATSConfig{
+		BaseURL:           srv.BaseURL(),
+		WssURL:            srv.WssURL(),
+		Username:          srv.Username,
+		Password:          srv.Password,
+		ClientId:          "30021",
+		PublicKey:         srv.PublicKey,
+		Timeout:           5,
+		ReconnectInterval: 50,
+	}
+}
+
+// newTestSession 创建指向模拟网关的会话，放宽登录限流以便快速重登
+func newTestSession(cfg *config.AdenATSConfig, rawChan chan *RawMessage) *AtsSession {
+	s := NewAtsSession(cfg, rawChan)
+	for _, e := range s.endpoints {
+		e.tokens.minInterval = 10 * time.Millisecond
+	}
+	return s
+}
+
+// breakTable 把表替换为结构不兼容的同名表，模拟写库持续失败（表不存在会自动重建）
+func breakTable(t *testing.T, conn *gorm.DB, table string) {
+	t.Helper()
+	if err := conn.Migrator().DropTable(table); err != nil {
+		t.Fatalf("删除表%s失败: %v", table, err)
+	}
+	if err := conn.Exec("CREATE TABLE " + table + " (id INTEGER PRIMARY KEY)").Error; err != nil {
+		t.Fatalf("创建表%s失败: %v", table, err)
+	}
+}
+
+// waitRows 等待表中行数达到want
+func waitRows(t *testing.T, conn *gorm.DB, table string, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var got int64
+	for time.Now().Before(deadline) {
+		if err := conn.Table(table).Count(&got).Error; err == nil && got >= want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("表%s行数 = %d, 期望 %d", table, got, want)
+}
+
+func TestAtsEndToEnd(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+
+	conn := newTestDB(t)
+	detailTable := GetTodayDetailTableName()
+	latestTable := GetTodayLatestTableName()
+
+	var wg sync.WaitGroup
+	rawChan := make(chan *RawMessage, 100)
+	parsedChan := make(chan *ParsedQuote, 100)
+	deadChan := make(chan *DeadMessage, 10)
+	bqs := NewBondQuoteService(conn, &wg, rawChan, parsedChan, deadChan)
+	bqs.StartParseWorkers(2)
+	bqs.StartDBWorkers(2, 10, 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := newTestSession(newTestATSConfig(srv), rawChan)
+	done := make(chan error, 1)
+	go func() { done <- session.Run(ctx) }()
+
+	seq := 0
+	pushQuote := func(isin string) {
+		seq++
+		bids := []atsmock.Level{{QuoteOrderNo: fmt.Sprintf("BID%d", seq), BrokerID: "B1", Price: 99.5, Yield: 4.1, OrderQty: 5000000}}
+		asks := []atsmock.Level{{QuoteOrderNo: fmt.Sprintf("ASK%d", seq), BrokerID: "B2", Price: 100.5, Yield: 3.9, OrderQty: 3000000}}
+		body := atsmock.OrderBookMessage(fmt.Sprintf("MSG%d", seq), isin, time.Now(), bids, asks)
+		if n := srv.Push(body); n != 1 {
+			t.Fatalf("推送消息失败，收到的会话数 = %d", n)
+		}
+	}
+
+	// 登录、订阅、推送行情入库
+	if err := srv.WaitSubscribes(1, 5*time.Second); err != nil {
+		t.Fatalf("首次订阅: %v", err)
+	}
+	if got := session.State(); got != SessionStreaming {
+		t.Fatalf("会话状态 = %s, 期望 STREAMING", got)
+	}
+	pushQuote("HK0000000001")
+	pushQuote("HK0000000002")
+	waitRows(t, conn, detailTable, 4)
+
+	// 无法解析的消息进入死信
+	srv.Push([]byte("not a json"))
+	select {
+	case dead := <-deadChan:
+		if string(dead.Body) != "not a json" || dead.Reason == "" || dead.ReceivedAt.IsZero() {
+			t.Fatalf("死信 = %q, 原因 = %q, 接收时间 = %s", dead.Body, dead.Reason, dead.ReceivedAt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("畸形消息未进入死信通道")
+	}
+
+	// 网络闪断：复用token重连并恢复订阅
+	srv.DropConnections()
+	if err := srv.WaitSubscribes(2, 5*time.Second); err != nil {
+		t.Fatalf("断线后重新订阅: %v", err)
+	}
+	if got := srv.Logins(); got != 1 {
+		t.Fatalf("闪断后登录次数 = %d, 期望复用token不重新登录", got)
+	}
+	pushQuote("HK0000000001")
+	waitRows(t, conn, detailTable, 6)
+
+	// token过期：ERROR帧触发重新登录
+	srv.ExpireTokens()
+	if err := srv.WaitSubscribes(3, 5*time.Second); err != nil {
+		t.Fatalf("token过期后重新订阅: %v", err)
+	}
+	if got := srv.Logins(); got != 2 {
+		t.Fatalf("token过期后登录次数 = %d, 期望 2", got)
+	}
+	pushQuote("HK0000000003")
+	waitRows(t, conn, detailTable, 8)
+	waitRows(t, conn, latestTable, 3)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("会话退出错误: %v", err)
+	}
+	if got := session.State(); got != SessionStopped {
+		t.Fatalf("会话状态 = %s, 期望 STOPPED", got)
+	}
+	close(rawChan)
+	close(parsedChan)
+	close(deadChan)
+	wg.Wait()
+}
+
+func TestClientIndividualAckAfterCommit(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+
+	conn := newTestDB(t)
+	detailTable := GetTodayDetailTableName()
+
+	var wg sync.WaitGroup
+	rawChan := make(chan *RawMessage, 100)
+	parsedChan := make(chan *ParsedQuote, 100)
+	deadChan := make(chan *DeadMessage, 10)
+	bqs := NewBondQuoteService(conn, &wg, rawChan, parsedChan, deadChan)
+	bqs.StartParseWorkers(2)
+	bqs.StartDBWorkers(1, 10, 20*time.Millisecond)
+
+	cfg := newTestATSConfig(srv)
+	cfg.AckMode = "client-individual"
+	ctx, cancel := context.WithCancel(context.Background())
+	session := newTestSession(cfg, rawChan)
+	done := make(chan error, 1)
+	go func() { done <- session.Run(ctx) }()
+
+	if err := srv.WaitSubscribes(1, 5*time.Second); err != nil {
+		t.Fatalf("订阅: %v", err)
+	}
+
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	srv.Push(atsmock.OrderBookMessage("MSG1", "HK0000000001", time.Now(), level, nil))
+	srv.Push(atsmock.OrderBookMessage("MSG2", "HK0000000002", time.Now(), level, nil))
+	srv.Push([]byte(`{"data":`))
+
+	// 两条写库成功后ACK，畸形消息进入死信后NACK
+	if err := srv.WaitAcks(3, 5*time.Second); err != nil {
+		t.Fatalf("等待确认: %v", err)
+	}
+	if got := len(srv.Acks()); got != 2 {
+		t.Fatalf("ACK数量 = %d, 期望 2", got)
+	}
+	if got := len(srv.Nacks()); got != 1 {
+		t.Fatalf("NACK数量 = %d, 期望 1", got)
+	}
+	var rows int64
+	conn.Table(detailTable).Count(&rows)
+	if rows != 2 {
+		t.Fatalf("ACK时明细行数 = %d, 期望 2", rows)
+	}
+	<-deadChan
+
+	// 写库失败的消息不ACK，而是NACK等待重新投递
+	breakTable(t, conn, detailTable)
+	srv.Push(atsmock.OrderBookMessage("MSG3", "HK0000000003", time.Now(), level, nil))
+	if err := srv.WaitAcks(4, 5*time.Second); err != nil {
+		t.Fatalf("等待确认: %v", err)
+	}
+	if got := len(srv.Acks()); got != 2 {
+		t.Fatalf("写库失败后ACK数量 = %d, 期望仍为 2", got)
+	}
+	if got := len(srv.Nacks()); got != 2 {
+		t.Fatalf("写库失败后NACK数量 = %d, 期望 2", got)
+	}
+
+	cancel()
+	<-done
+	close(rawChan)
+	close(parsedChan)
+	close(deadChan)
+	wg.Wait()
+}
+
+func TestCaptureAndReplayBackfill(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+
+	// 录制线上推送
+	dir := t.TempDir()
+	writer, err := capture.NewWriter(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawChan := make(chan *RawMessage, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	session := newTestSession(newTestATSConfig(srv), rawChan)
+	session.SetCapture(writer)
+	done := make(chan error, 1)
+	go func() { done <- session.Run(ctx) }()
+	if err := srv.WaitSubscribes(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// 录制的是前一天的行情，补数时按消息时间写入前一天的表
+	yesterday := time.Now().AddDate(0, 0, -1)
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	body := atsmock.OrderBookMessage("MSG1", "HK0000000001", yesterday, level, level)
+	srv.Push(body)
+	select {
+	case <-rawChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("未收到推送")
+	}
+	cancel()
+	<-done
+	writer.Close()
+
+	files, _ := capture.Files(dir)
+	if len(files) != 1 {
+		t.Fatalf("录制文件数 = %d, 期望 1", len(files))
+	}
+	var recs []*capture.Record
+	if _, err := (&capture.Replayer{}).Replay(context.Background(), files, func(rec *capture.Record) error {
+		recs = append(recs, rec)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || string(recs[0].Body) != string(body) {
+		t.Fatalf("录制内容不符: %d 条", len(recs))
+	}
+
+	// 数据库故障后补数：按消息时间写入前一天的表
+	conn := newTestDB(t)
+	if err := NewCreateTableService(conn).EnsureDailyTablesExist(yesterday); err != nil {
+		t.Fatal(err)
+	}
+	pq, err := ParseBondQuote(recs[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pq.ReceivedAt = yesterday
+	if err := InsertBatch(conn, []*ParsedQuote{pq}); err != nil {
+		t.Fatalf("补数写库失败: %v", err)
+	}
+	waitRows(t, conn, GetDetailTableName(yesterday), 2)
+	var today int64
+	conn.Table(GetTodayDetailTableName()).Count(&today)
+	if today != 0 {
+		t.Fatalf("补数不应写入当天表, 当天行数 = %d", today)
+	}
+
+	// 再回放两遍，明细表不变
+	sink := NewDBSink("bond", conn)
+	for i := 0; i < 2; i++ {
+		if err := sink.Write([]*ParsedQuote{pq}); err != nil {
+			t.Fatalf("重复回放写库失败: %v", err)
+		}
+	}
+	var rows int64
+	conn.Table(GetDetailTableName(yesterday)).Count(&rows)
+	if rows != 2 || sink.Duplicates() != 4 {
+		t.Fatalf("重复回放后明细 %d 行, 跳过 %d 行", rows, sink.Duplicates())
+	}
+}
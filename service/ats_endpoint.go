@@ -0,0 +1,175 @@
+package service
+
+// ATS多网关故障切换
+// 1. endpoints 按顺序排列，第一个为主网关；未配置时使用 baseURL/wssURL 作为唯一网关
+// 2. 每个网关有独立的登录地址、WebSocket地址和可选账号，token分别缓存
+// 3. 当前网关连续失败达到阈值，或断线后健康探测不可用时，切换到下一个可用网关
+// 4. 使用备用网关期间定期探测更高优先级的网关，恢复后主动断开并回切
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+const (
+	defaultHealthPath        = "/"
+	defaultFailoverThreshold = 2
+	defaultFailbackInterval  = 60 * time.Second
+	healthProbeTimeout       = 5 * time.Second
+)
+
+// ErrEndpointFailback 更高优先级的网关已恢复，断开当前连接回切
+var ErrEndpointFailback = errors.New("主网关已恢复，回切")
+
+// EndpointStatus 网关状态
+type EndpointStatus struct {
+	Name      string    `json:"name"`
+	BaseURL   string    `json:"baseURL"`
+	WssURL    string    `json:"wssURL"`
+	Active    bool      `json:"active"`    // 当前是否使用该网关
+	Failures  int       `json:"failures"`  // 连续失败次数
+	Healthy   bool      `json:"healthy"`   // 最近一次健康探测结果
+	CheckedAt time.Time `json:"checkedAt"` // 最近一次健康探测时间，零值表示未探测
+}
+
+// atsEndpoint 单个网关及其会话资源
+type atsEndpoint struct {
+	name       string
+	cfg        *config.AdenATSConfig // 合并了全局配置后的网关配置
+	healthPath string
+	tokens     *TokenManager
+	api        *AtsAPIClient
+
+	mu        sync.Mutex
+	failures  int
+	healthy   bool
+	checkedAt time.Time
+}
+
+// resolveEndpoints 按配置生成网关列表，网关未配置的字段沿用全局配置
+func resolveEndpoints(cfg *config.AdenATSConfig) ([]*atsEndpoint, error) {
+	healthPath := cfg.HealthPath
+	if healthPath == "" {
+		healthPath = defaultHealthPath
+	}
+	if len(cfg.Endpoints) == 0 {
+		return []*atsEndpoint{newAtsEndpoint("default", cfg, healthPath)}, nil
+	}
+
+	endpoints := make([]*atsEndpoint, 0, len(cfg.Endpoints))
+	for i, e := range cfg.Endpoints {
+		if e.BaseURL == "" || e.WssURL == "" {
+			return nil, fmt.Errorf("ATS网关配置错误: 第%d个网关缺少 baseURL 或 wssURL", i+1)
+		}
+		merged := *cfg
+		merged.Endpoints = nil
+		merged.BaseURL = e.BaseURL
+		merged.WssURL = e.WssURL
+		if e.Username != "" {
+			merged.Username = e.Username
+			merged.Password = e.Password
+			merged.SmsCode = e.SmsCode
+		}
+		if e.ClientId != "" {
+			merged.ClientId = e.ClientId
+		}
+		if e.PublicKey != "" {
+			merged.PublicKey = e.PublicKey
+		}
+
+		name := e.Name
+		if name == "" {
+			name = fmt.Sprintf("endpoint-%d", i+1)
+		}
+		path := healthPath
+		if e.HealthPath != "" {
+			path = e.HealthPath
+		}
+		endpoints = append(endpoints, newAtsEndpoint(name, &merged, path))
+	}
+	return endpoints, nil
+}
+
+func newAtsEndpoint(name string, cfg *config.AdenATSConfig, healthPath string) *atsEndpoint {
+	return &atsEndpoint{
+		name:       name,
+		cfg:        cfg,
+		healthPath: healthPath,
+		tokens:     NewTokenManager(cfg),
+		api:        NewAtsAPIClient(cfg, nil),
+	}
+}
+
+// setTransport 设置登录和接口调用使用的HTTP客户端
+func (e *atsEndpoint) setTransport(transport *AtsTransport) {
+	e.tokens.transport = transport
+	e.api = NewAtsAPIClient(e.cfg, transport)
+}
+
+// probe 健康探测：GET baseURL+healthPath，能建立连接且HTTP状态码<500视为可用
+func (e *atsEndpoint) probe(ctx context.Context, client *http.Client) bool {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	healthy := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.cfg.BaseURL+e.healthPath, nil)
+	if err == nil {
+		if resp, err := client.Do(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			healthy = resp.StatusCode < http.StatusInternalServerError
+		}
+	}
+
+	e.mu.Lock()
+	e.healthy = healthy
+	e.checkedAt = time.Now()
+	e.mu.Unlock()
+	return healthy
+}
+
+// recordFailure 记录一次失败，返回连续失败次数
+// 本轮曾进入接收状态时重新计数，避免偶发断线累积触发切换
+func (e *atsEndpoint) recordFailure(streamed bool) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if streamed {
+		e.failures = 0
+	}
+	e.failures++
+	return e.failures
+}
+
+// checkAuth 认证失败时作废token，下一轮重新登录
+func (e *atsEndpoint) checkAuth(token string, err error) {
+	if IsAuthError(err) {
+		e.tokens.Invalidate(token)
+	}
+}
+
+func (e *atsEndpoint) resetFailures() {
+	e.mu.Lock()
+	e.failures = 0
+	e.mu.Unlock()
+}
+
+func (e *atsEndpoint) status(active bool) EndpointStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return EndpointStatus{
+		Name:      e.name,
+		BaseURL:   e.cfg.BaseURL,
+		WssURL:    e.cfg.WssURL,
+		Active:    active,
+		Failures:  e.failures,
+		Healthy:   e.healthy,
+		CheckedAt: e.checkedAt,
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+func TestResolveEndpoints(t *testing.T) {
+	cfg := &config.AdenATSConfig{
+		BaseURL:   "https://primary",
+		WssURL:    "wss://primary/ws",
+		Username:  "user",
+		Password:  "pass",
+		ClientId:  "30021",
+		PublicKey: "KEY",
+	}
+	endpoints, err := resolveEndpoints(cfg)
+	if err != nil || len(endpoints) != 1 || endpoints[0].cfg.BaseURL != "https://primary" || endpoints[0].healthPath != "/" {
+		t.Fatalf("未配置endpoints时应使用baseURL: %+v, %v", endpoints, err)
+	}
+
+	cfg.HealthPath = "/health"
+	cfg.Endpoints = []config.ATSEndpointConfig{
+		{Name: "sh", BaseURL: "https://sh", WssURL: "wss://sh/ws"},
+		{BaseURL: "https://hk", WssURL: "wss://hk/ws", Username: "hk-user", Password: "hk-pass", PublicKey: "HKKEY", HealthPath: "/ping"},
+	}
+	endpoints, err = resolveEndpoints(cfg)
+	if err != nil || len(endpoints) != 2 {
+		t.Fatalf("endpoints = %+v, %v", endpoints, err)
+	}
+	sh, hk := endpoints[0], endpoints[1]
+	if sh.name != "sh" || sh.cfg.Username != "user" || sh.cfg.PublicKey != "KEY" || sh.healthPath != "/health" {
+		t.Fatalf("主网关应沿用全局账号: %s %+v", sh.name, sh.cfg)
+	}
+	if hk.name != "endpoint-2" || hk.cfg.Username != "hk-user" || hk.cfg.Password != "hk-pass" ||
+		hk.cfg.PublicKey != "HKKEY" || hk.cfg.ClientId != "30021" || hk.healthPath != "/ping" {
+		t.Fatalf("备用网关应使用自己的账号: %s %+v", hk.name, hk.cfg)
+	}
+	if cfg.BaseURL != "https://primary" || cfg.Username != "user" {
+		t.Fatal("合并配置不应修改全局配置")
+	}
+
+	cfg.Endpoints = append(cfg.Endpoints, config.ATSEndpointConfig{Name: "bad", BaseURL: "https://bad"})
+	if _, err := resolveEndpoints(cfg); err == nil {
+		t.Fatal("缺少wssURL应报错")
+	}
+}
+
+// expectQuote 在指定网关推送一条报价，并确认会话收到
+func expectQuote(t *testing.T, srv *atsmock.Server, rawChan chan *RawMessage, id string) {
+	t.Helper()
+	level := []atsmock.Level{{QuoteOrderNo: id, BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	if n := srv.Push(atsmock.OrderBookMessage(id, "HK0000000001", time.Now(), level, nil)); n != 1 {
+		t.Fatalf("推送 %s 到 %d 个会话", id, n)
+	}
+	select {
+	case msg := <-rawChan:
+		pq, err := ParseBondQuote(msg.Body)
+		if err != nil || pq.Meta.Data.MessageID != id {
+			t.Fatalf("收到消息 %v, err = %v, 期望 %s", pq, err, id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("未收到消息 %s", id)
+	}
+}
+
+func TestSessionFailoverAndFailback(t *testing.T) {
+	primary := atsmock.NewServer()
+	defer primary.Close()
+	backup := atsmock.NewServer()
+	defer backup.Close()
+
+	// 两个网关各自的公钥，备用网关单独配置账号
+	cfg := newTestATSConfig(primary)
+	cfg.Endpoints = []config.ATSEndpointConfig{
+		{Name: "primary", BaseURL: primary.BaseURL(), WssURL: primary.WssURL(), PublicKey: primary.PublicKey},
+		{Name: "backup", BaseURL: backup.BaseURL(), WssURL: backup.WssURL(), PublicKey: backup.PublicKey,
+			Username: backup.Username, Password: backup.Password},
+	}
+
+	rawChan := make(chan *RawMessage, 10)
+	session := newTestSession(cfg, rawChan)
+	session.failbackTick = 50 * time.Millisecond
+	alerts := make(chan string, 10)
+	session.alert = func(_ context.Context, content string) error {
+		alerts <- content
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- session.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if err := primary.WaitSubscribes(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.ActiveEndpoint().Name; got != "primary" {
+		t.Fatalf("当前网关 = %s", got)
+	}
+	expectQuote(t, primary, rawChan, "P1")
+
+	// 主网关在推送过程中宕机，切换到备用网关
+	primary.Kill()
+	if err := backup.WaitSubscribes(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.ActiveEndpoint().Name; got != "backup" {
+		t.Fatalf("故障切换后当前网关 = %s", got)
+	}
+	expectQuote(t, backup, rawChan, "B1")
+	statuses := session.Endpoints()
+	if len(statuses) != 2 || statuses[0].Active || statuses[0].Healthy || !statuses[1].Active {
+		t.Fatalf("网关状态 = %+v", statuses)
+	}
+
+	// 主网关恢复后回切，备用网关的连接被断开
+	if err := primary.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.WaitSubscribes(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.ActiveEndpoint().Name; got != "primary" {
+		t.Fatalf("回切后当前网关 = %s", got)
+	}
+	expectQuote(t, primary, rawChan, "P2")
+	if n := backup.Push([]byte(`{}`)); n != 0 {
+		t.Fatalf("回切后备用网关仍有 %d 个会话", n)
+	}
+	if primary.Logins() != 1 || backup.Logins() != 1 {
+		t.Fatalf("登录次数 primary=%d backup=%d，token应按网关缓存复用", primary.Logins(), backup.Logins())
+	}
+
+	for _, want := range []string{"primary -> backup", "backup -> primary"} {
+		select {
+		case got := <-alerts:
+			if !strings.Contains(got, want) {
+				t.Fatalf("告警 = %s, 期望包含 %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("未收到网关切换告警: %s", want)
+		}
+	}
+}
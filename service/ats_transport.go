@@ -0,0 +1,126 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+	logger "wealth-bond-quote-service/pkg/log"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrPinMismatch 服务端证书公钥与配置的指纹均不匹配
+var ErrPinMismatch = errors.New("服务端证书公钥指纹不匹配")
+
+// AtsTransport 与ATS通信共用的HTTP客户端和WebSocket拨号器
+// 由同一份TLS配置构建，登录接口和WebSocket连接的证书校验规则保持一致
+type AtsTransport struct {
+	HTTPClient *http.Client
+	Dialer     *websocket.Dialer
+}
+
+// NewAtsTransport 根据ATS配置构建共用的HTTP客户端和WebSocket拨号器
+func NewAtsTransport(cfg *config.AdenATSConfig) (*AtsTransport, error) {
+	tlsConfig, err := buildTLSConfig(&cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultClientTimeout
+	}
+
+	return &AtsTransport{
+		HTTPClient: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: timeout,
+			},
+		},
+		Dialer: &websocket.Dialer{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+			// 支持的STOMP协议版本，按优先级排序
+			Subprotocols:     []string{"v12.stomp", "v11.stomp", "v10.stomp"},
+			HandshakeTimeout: timeout,
+		},
+	}, nil
+}
+
+// buildTLSConfig 根据配置构建TLS设置
+// 默认使用系统根证书校验；配置了指纹时在证书校验通过后再校验公钥指纹
+func buildTLSConfig(cfg *config.ATSTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA证书文件中没有有效证书: %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("客户端证书和私钥必须同时配置")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(cfg.PinnedSPKI) > 0 {
+		pins := make(map[string]bool, len(cfg.PinnedSPKI))
+		for _, pin := range cfg.PinnedSPKI {
+			pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+			sum, err := base64.StdEncoding.DecodeString(pin)
+			if err != nil || len(sum) != sha256.Size {
+				return nil, fmt.Errorf("证书公钥指纹格式错误: %q", pin)
+			}
+			pins[string(sum)] = true
+		}
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[string(sum[:])] {
+					return nil
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+
+	if cfg.DevInsecureSkipVerify {
+		logger.Warn("!!! 已开启 devInsecureSkipVerify，跳过ATS服务端证书校验，仅限开发环境使用，禁止用于生产 !!!")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// SPKIPin 计算证书公钥指纹，格式与 pinnedSPKI 配置一致
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
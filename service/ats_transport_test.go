@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+// writeCAFile 把模拟网关的证书写成PEM文件
+func writeCAFile(t *testing.T, srv *atsmock.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// connectWithTLS 使用给定TLS配置登录并建立WebSocket连接
+func connectWithTLS(srv *atsmock.Server, tlsCfg config.ATSTLSConfig) error {
+	cfg := newTestATSConfig(srv)
+	cfg.TLS = tlsCfg
+	transport, err := NewAtsTransport(cfg)
+	if err != nil {
+		return err
+	}
+	client := &StompClient{Transport: transport}
+	if err := client.Login(cfg.Username, cfg.Password, cfg.SmsCode, cfg.PublicKey, cfg.BaseURL, cfg.ClientId); err != nil {
+		return err
+	}
+	if err := client.ConnectWebSocket(cfg.WssURL); err != nil {
+		return err
+	}
+	return client.Conn.Close()
+}
+
+func TestAtsTransportTLS(t *testing.T) {
+	srv := atsmock.NewTLSServer()
+	defer srv.Close()
+	caFile := writeCAFile(t, srv)
+	pin := SPKIPin(srv.Certificate())
+
+	tests := []struct {
+		name    string
+		tls     config.ATSTLSConfig
+		wantErr string
+	}{
+		{name: "系统根证书不信任自签证书", wantErr: "certificate"},
+		{name: "自定义CA", tls: config.ATSTLSConfig{CAFile: caFile}},
+		{name: "覆盖校验主机名", tls: config.ATSTLSConfig{CAFile: caFile, ServerName: "example.com"}},
+		{name: "主机名不匹配", tls: config.ATSTLSConfig{CAFile: caFile, ServerName: "ats.example.org"}, wantErr: "certificate"},
+		{name: "指纹匹配", tls: config.ATSTLSConfig{CAFile: caFile, PinnedSPKI: []string{"sha256/" + strings.Repeat("A", 43) + "=", pin}}},
+		{name: "指纹不匹配", tls: config.ATSTLSConfig{CAFile: caFile, PinnedSPKI: []string{"sha256/" + strings.Repeat("A", 43) + "="}}, wantErr: ErrPinMismatch.Error()},
+		{name: "开发环境跳过校验", tls: config.ATSTLSConfig{DevInsecureSkipVerify: true}},
+		{name: "跳过校验仍检查指纹", tls: config.ATSTLSConfig{DevInsecureSkipVerify: true, PinnedSPKI: []string{"sha256/" + strings.Repeat("A", 43) + "="}}, wantErr: ErrPinMismatch.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := connectWithTLS(srv, tt.tls)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("连接失败: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("错误 = %v, 期望包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAtsTransportConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "bad.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+
+	tests := []struct {
+		name string
+		tls  config.ATSTLSConfig
+	}{
+		{name: "CA文件不存在", tls: config.ATSTLSConfig{CAFile: filepath.Join(dir, "missing.pem")}},
+		{name: "CA文件无证书", tls: config.ATSTLSConfig{CAFile: notPEM}},
+		{name: "只配置客户端证书", tls: config.ATSTLSConfig{CertFile: notPEM}},
+		{name: "客户端证书无效", tls: config.ATSTLSConfig{CertFile: notPEM, KeyFile: notPEM}},
+		{name: "指纹格式错误", tls: config.ATSTLSConfig{PinnedSPKI: []string{"sha256/abc"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAtsTransport(&config.AdenATSConfig{TLS: tt.tls}); err == nil {
+				t.Fatal("期望配置错误")
+			}
+		})
+	}
+
+	// 会话启动时TLS配置错误直接失败，不进入重连
+	cfg := &config.AdenATSConfig{TLS: config.ATSTLSConfig{CAFile: notPEM}}
+	session := NewAtsSession(cfg, make(chan *RawMessage))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := session.Run(ctx); err == nil || errors.Is(err, ErrMaxReconnectAttempts) {
+		t.Fatalf("期望TLS配置错误, 实际: %v", err)
+	}
+	if got := session.State(); got != SessionFailed {
+		t.Fatalf("会话状态 = %s, 期望 FAILED", got)
+	}
+}
@@ -2,9 +2,10 @@ package service
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gorm.io/gorm"
@@ -14,30 +15,39 @@ import (
 	logger "wealth-bond-quote-service/pkg/log"
 )
 
-var (
-	msgPool = sync.Pool{
-		New: func() interface{} {
-			return &BondQuoteMessage{}
-		},
-	}
-	qpdPool = sync.Pool{
-		New: func() interface{} {
-			return &QuotePriceData{}
-		},
-	}
-)
+// quotePool 复用解析结果，连同档位切片的底层数组一起复用
+var quotePool = sync.Pool{
+	New: func() interface{} {
+		return &ParsedQuote{}
+	},
+}
 
 // BondQuoteService 债券行情服务
 type BondQuoteService struct {
 	db         *gorm.DB
 	wg         *sync.WaitGroup
-	RawChan    chan []byte
+	RawChan    chan *RawMessage
 	ParsedChan chan *ParsedQuote
-	DeadChan   chan []byte
+	DeadChan   chan *DeadMessage
+	watchdog   *FeedWatchdog
+	sinks      []*sinkRunner
+
+	parsed  atomic.Int64
+	dead    atomic.Int64
+	written atomic.Int64
+	failed  atomic.Int64
+}
+
+// QuoteCounts 解析和写库计数
+type QuoteCounts struct {
+	Parsed  int64 `json:"parsed"`  // 解析成功的消息数
+	Dead    int64 `json:"dead"`    // 进入死信的消息数
+	Written int64 `json:"written"` // 写库成功的消息数
+	Failed  int64 `json:"failed"`  // 写库失败的消息数
 }
 
 // NewBondQuoteService 创建债券行情服务
-func NewBondQuoteService(db *gorm.DB, wg *sync.WaitGroup, RawChan chan []byte, ParsedChan chan *ParsedQuote, DeadChan chan []byte) *BondQuoteService {
+func NewBondQuoteService(db *gorm.DB, wg *sync.WaitGroup, RawChan chan *RawMessage, ParsedChan chan *ParsedQuote, DeadChan chan *DeadMessage) *BondQuoteService {
 	return &BondQuoteService{
 		db:         db,
 		wg:         wg,
@@ -47,6 +57,21 @@ func NewBondQuoteService(db *gorm.DB, wg *sync.WaitGroup, RawChan chan []byte, P
 	}
 }
 
+// Counts 返回解析和写库计数
+func (bqs *BondQuoteService) Counts() QuoteCounts {
+	return QuoteCounts{
+		Parsed:  bqs.parsed.Load(),
+		Dead:    bqs.dead.Load(),
+		Written: bqs.written.Load(),
+		Failed:  bqs.failed.Load(),
+	}
+}
+
+// SetWatchdog 设置行情断流监控，解析成功后记录ISIN报价时间
+func (bqs *BondQuoteService) SetWatchdog(w *FeedWatchdog) {
+	bqs.watchdog = w
+}
+
 // 响应消息结构体
 type BondQuoteMessage struct {
 	Data          BondQuoteData `json:"data"`
@@ -60,6 +85,7 @@ type BondQuoteData struct {
 	MessageType    string `json:"messageType"`
 	Organization   string `json:"organization"`
 	ReceiverID     string `json:"receiverId"`
+	SecurityCode   string `json:"securityCode,omitempty"` // 部分格式在外层携带证券代码
 	Timestamp      int64  `json:"timestamp"`
 }
 
@@ -81,84 +107,426 @@ type QuotePrice struct {
 	QuoteOrderNo     string  `json:"quoteOrderNo"`
 	QuoteTime        int64   `json:"quoteTime"`
 	SecurityID       string  `json:"securityId"`
+	SettleDate       string  `json:"settleDate,omitempty"` // 2006-01-02
 	SettleType       string  `json:"settleType"`
 	Side             string  `json:"side"`
 	Yield            float64 `json:"yield"`
 }
 
+// Acknowledger 消息确认句柄
+// 非自动确认订阅下，消息写库成功后 Ack，进入死信或写库失败时 Nack
+type Acknowledger interface {
+	Ack() error
+	Nack() error
+}
+
+// RawMessage 原始行情消息：STOMP body + 接收信息
+type RawMessage struct {
+	Body       []byte       // 原始JSON
+	ReceivedAt time.Time    // 接收时间
+	Ack        Acknowledger // 消息确认句柄，自动确认模式下为nil
+}
+
+// DeadMessage 解析失败的原始消息，由 DeadLetterService 落库
+type DeadMessage struct {
+	Body       []byte    // 原始JSON
+	ReceivedAt time.Time // 接收时间
+	Reason     string    // 解析失败原因
+}
+
 // ParsedQuote 解析结果：外层元信息 + 内层行情数据
+// 来自对象池，写库层在所有写入目标处理完后调用 Release 归还；写入目标不能在 Write 返回后继续引用
 type ParsedQuote struct {
-	Meta    BondQuoteMessage // WsMessageType、MessageId...
-	Payload QuotePriceData   // askPrices / bidPrices / securityId
+	Meta       BondQuoteMessage // WsMessageType、MessageId...
+	Payload    QuotePriceData   // askPrices / bidPrices / securityId
+	ReceivedAt time.Time        // 原始消息接收时间，消息时间戳缺失或异常时决定写入哪一天的表
+	Ack        Acknowledger     // 原始消息的确认句柄，可能为nil
+	Raw        []byte           // 原始消息，隔离到死信时原样保存，重新处理时按原格式解析
+
+	refs atomic.Int32 // 持有者数量，归零时归还对象池
 }
 
-// ParseBondQuote 把 STOMP body 原始 JSON 解析成 ParsedQuote
-func ParseBondQuote(raw []byte) (*ParsedQuote, error) {
-	msg := msgPool.Get().(*BondQuoteMessage)
-	defer msgPool.Put(msg)
-	if err := json.Unmarshal(raw, msg); err != nil {
-		return nil, fmt.Errorf("unmarshal BondQuoteMessage: %w", err)
+// reset 清空内容，保留档位切片的容量
+func (pq *ParsedQuote) reset() {
+	asks, bids := pq.Payload.AskPrices, pq.Payload.BidPrices
+	clear(asks[:cap(asks)])
+	clear(bids[:cap(bids)])
+	pq.Meta = BondQuoteMessage{}
+	pq.Payload = QuotePriceData{AskPrices: asks[:0], BidPrices: bids[:0]}
+	pq.ReceivedAt = time.Time{}
+	pq.Ack = nil
+	pq.Raw = nil
+}
+
+// Release 释放一个持有者，全部释放后归还对象池，之后不能再访问
+func (pq *ParsedQuote) Release() {
+	if pq.refs.Add(-1) != 0 {
+		return
+	}
+	pq.reset()
+	quotePool.Put(pq)
+}
+
+// retain 设置持有者数量，写库层分发到多个写入目标前调用
+func (pq *ParsedQuote) retain(n int) {
+	pq.refs.Store(int32(n))
+}
+
+// Clone 深拷贝，用于需要在写入后继续保留行情的场景；副本不属于对象池
+func (pq *ParsedQuote) Clone() *ParsedQuote {
+	c := &ParsedQuote{Meta: pq.Meta, Payload: pq.Payload, ReceivedAt: pq.ReceivedAt, Ack: pq.Ack}
+	c.Payload.AskPrices = slices.Clone(pq.Payload.AskPrices)
+	c.Payload.BidPrices = slices.Clone(pq.Payload.BidPrices)
+	return c
+}
+
+// ackMessage 确认消息，失败只记录日志（服务端会重新投递）
+func ackMessage(a Acknowledger) {
+	if a == nil {
+		return
 	}
+	if err := a.Ack(); err != nil {
+		logger.Warn("消息ACK失败: %v", err)
+	}
+}
 
-	payload := qpdPool.Get().(*QuotePriceData)
-	defer qpdPool.Put(payload)
-	if err := json.Unmarshal([]byte(msg.Data.QuotePriceData), payload); err != nil {
-		return nil, fmt.Errorf("unmarshal QuotePriceData: %w", err)
+// nackMessage 拒绝消息，失败只记录日志
+func nackMessage(a Acknowledger) {
+	if a == nil {
+		return
+	}
+	if err := a.Nack(); err != nil {
+		logger.Warn("消息NACK失败: %v", err)
 	}
+}
+
+// deadLetterAcker 可选接口：消息进入死信时的确认方式，未实现时按 Nack 处理
+type deadLetterAcker interface {
+	DeadLetter() error
+}
 
-	// isin必须存在
-	if payload.SecurityID == "" {
-		return nil, errors.New("securityId is empty")
+// deadLetterMessage 确认已进入死信的消息
+func deadLetterMessage(a Acknowledger) {
+	d, ok := a.(deadLetterAcker)
+	if !ok {
+		nackMessage(a)
+		return
 	}
+	if err := d.DeadLetter(); err != nil {
+		logger.Warn("死信消息确认失败: %v", err)
+	}
+}
 
-	return &ParsedQuote{
-		Meta:    *msg,
-		Payload: *payload,
-	}, nil
+// ParseBondQuote 把 STOMP body 原始 JSON 解析成 ParsedQuote
+// 返回的对象来自对象池，归调用方所有；交给写库层后由写库层负责 Release，不再使用时也可不释放
+func ParseBondQuote(raw []byte) (*ParsedQuote, error) {
+	pq := quotePool.Get().(*ParsedQuote)
+	if err := decodeBondQuote(raw, pq); err != nil {
+		pq.reset()
+		quotePool.Put(pq)
+		return nil, err
+	}
+	pq.Raw = raw
+	pq.retain(1)
+	return pq, nil
+}
+
+// parseReorderWindow 已分发但尚未按序输出的消息上限
+const parseReorderWindow = 1024
+
+// parseJob 按接收顺序编号的原始消息
+type parseJob struct {
+	seq uint64
+	raw *RawMessage
+}
+
+// parseResult 解析结果，解析失败时 pq 为 nil
+type parseResult struct {
+	seq uint64
+	pq  *ParsedQuote
 }
 
 // StartParseWorkers — 解析层
+// 多个协程并行解析，但按接收顺序输出到 ParsedChan，保证同一 ISIN 的消息先后顺序不变：
+// 接收时编号，解析完成后在重排缓冲中等待前面的消息，缓冲满时暂停接收
 func (bqs *BondQuoteService) StartParseWorkers(workerNum int) {
+	if workerNum <= 0 {
+		workerNum = 1
+	}
+	jobs := make(chan parseJob, workerNum)
+	results := make(chan parseResult, workerNum)
+	window := make(chan struct{}, parseReorderWindow)
+
+	// 编号
+	go func() {
+		defer close(jobs)
+		var seq uint64
+		for raw := range bqs.RawChan {
+			window <- struct{}{}
+			jobs <- parseJob{seq: seq, raw: raw}
+			seq++
+		}
+	}()
+
+	// 并行解析
+	var parseWg sync.WaitGroup
 	for i := 0; i < workerNum; i++ {
-		bqs.wg.Add(1)
+		parseWg.Add(1)
 		go func() {
-			defer bqs.wg.Done()
-			for raw := range bqs.RawChan {
-				pq, err := ParseBondQuote(raw)
-				switch {
-				// case err == service.ErrNotQuote:
-				// 	continue // 过滤非行情
-				case err != nil:
-					bqs.DeadChan <- raw
-					continue
-				}
-				bqs.ParsedChan <- pq
+			defer parseWg.Done()
+			for job := range jobs {
+				results <- parseResult{seq: job.seq, pq: bqs.parseRaw(job.raw)}
 			}
 		}()
 	}
+	go func() {
+		parseWg.Wait()
+		close(results)
+	}()
+
+	// 按编号顺序输出
+	bqs.wg.Add(1)
+	go func() {
+		defer bqs.wg.Done()
+		pending := make(map[uint64]*ParsedQuote)
+		var next uint64
+		for res := range results {
+			pending[res.seq] = res.pq
+			for {
+				pq, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if pq != nil {
+					bqs.ParsedChan <- pq
+				}
+				<-window
+			}
+		}
+	}()
+}
+
+// parseRaw 解析一条原始消息，失败时写入死信并返回 nil
+func (bqs *BondQuoteService) parseRaw(raw *RawMessage) *ParsedQuote {
+	pq, err := ParseBondQuote(raw.Body)
+	switch {
+	// case err == service.ErrNotQuote:
+	// 	return nil // 过滤非行情
+	case err != nil:
+		bqs.dead.Add(1)
+		bqs.DeadChan <- &DeadMessage{Body: raw.Body, ReceivedAt: raw.ReceivedAt, Reason: err.Error()}
+		deadLetterMessage(raw.Ack)
+		return nil
+	}
+	bqs.parsed.Add(1)
+	pq.ReceivedAt = raw.ReceivedAt
+	if bqs.watchdog != nil {
+		bqs.watchdog.QuoteReceived(pq.Payload.SecurityID, raw.ReceivedAt)
+	}
+	pq.Ack = raw.Ack
+	return pq
+}
+
+// SinkOptions 写入目标的参数，批量参数为零值时沿用 StartDBWorkers 的参数
+type SinkOptions struct {
+	Required     bool // 必需的目标全部写入成功后才确认消息；非必需的目标失败只记录日志
+	Blocking     bool // 非必需的目标积压时阻塞等待而不是丢弃，用于查询和导出直接读取的内存订单簿
+	WorkerNum    int
+	BatchSize    int
+	FlushDelay   time.Duration
+	MaxRetries   int           // 暂时性错误的重试次数，默认3，负数表示不重试
+	RetryBackoff time.Duration // 首次重试间隔，之后翻倍，默认100ms
+}
+
+// SinkStats 写入目标统计
+type SinkStats struct {
+	Name        string `json:"name"`
+	Required    bool   `json:"required"`
+	Written     int64  `json:"written"`     // 写入成功的消息数
+	Failed      int64  `json:"failed"`      // 写入失败的消息数
+	Dropped     int64  `json:"dropped"`     // 非必需且非阻塞的目标积压时丢弃的消息数
+	Retried     int64  `json:"retried"`     // 暂时性错误的重试次数
+	Quarantined int64  `json:"quarantined"` // 隔离到死信的消息数
+	Duplicates  int64  `json:"duplicates"`  // 已存在而跳过的明细行数（重复投递、回放）
+	Stale       int64  `json:"stale"`       // 比已保存的旧而未覆盖的最新行情数（迟到、回放）
+}
+
+// skipCounter 统计写入时跳过行数的目标
+type skipCounter interface {
+	Duplicates() int64
+	Stale() int64
+}
+
+// sinkRunner 单个写入目标的分发、批量写入和统计
+type sinkRunner struct {
+	sink     QuoteSink
+	opts     SinkOptions
+	in       chan *ParsedQuote
+	lastWarn time.Time // 最近一次丢弃告警，仅在分发协程中访问
+
+	written     atomic.Int64
+	failed      atomic.Int64
+	dropped     atomic.Int64
+	retried     atomic.Int64
+	quarantined atomic.Int64
+}
+
+// sinkAck 汇总各必需目标的写入结果，全部成功才确认原始消息
+type sinkAck struct {
+	bqs     *BondQuoteService
+	ack     Acknowledger
+	pending atomic.Int32
+	failed  atomic.Bool
+}
+
+func (a *sinkAck) Ack() error  { return a.done(false) }
+func (a *sinkAck) Nack() error { return a.done(true) }
+
+func (a *sinkAck) done(failed bool) error {
+	if failed {
+		a.failed.Store(true)
+	}
+	if a.pending.Add(-1) != 0 {
+		return nil
+	}
+	if a.failed.Load() {
+		a.bqs.failed.Add(1)
+		if a.ack != nil {
+			return a.ack.Nack()
+		}
+		return nil
+	}
+	a.bqs.written.Add(1)
+	if a.ack != nil {
+		return a.ack.Ack()
+	}
+	return nil
+}
+
+// AddSink 添加写入目标，需在 StartDBWorkers 之前调用；未添加时默认写入 MySQL
+func (bqs *BondQuoteService) AddSink(sink QuoteSink, opts SinkOptions) {
+	bqs.sinks = append(bqs.sinks, &sinkRunner{sink: sink, opts: opts})
+}
+
+// SinkStats 返回各写入目标的统计
+func (bqs *BondQuoteService) SinkStats() []SinkStats {
+	stats := make([]SinkStats, 0, len(bqs.sinks))
+	for _, r := range bqs.sinks {
+		st := SinkStats{
+			Name:        r.sink.Name(),
+			Required:    r.opts.Required,
+			Written:     r.written.Load(),
+			Failed:      r.failed.Load(),
+			Dropped:     r.dropped.Load(),
+			Retried:     r.retried.Load(),
+			Quarantined: r.quarantined.Load(),
+		}
+		if c, ok := r.sink.(skipCounter); ok {
+			st.Duplicates, st.Stale = c.Duplicates(), c.Stale()
+		}
+		stats = append(stats, st)
+	}
+	return stats
 }
 
 // StartDBWorkers — 写库层
+// ParsedChan 中的消息分发到每个写入目标，各目标独立批量写入；ParsedChan 关闭后写入最后一批并关闭目标
 func (bqs *BondQuoteService) StartDBWorkers(workerNum int, batchSize int, flushDelay time.Duration) {
-	bqs.db = bqs.db.Session(&gorm.Session{SkipDefaultTransaction: true, PrepareStmt: true})
+	if len(bqs.sinks) == 0 {
+		bqs.AddSink(NewDBSink(SinkMySQL, bqs.db), SinkOptions{Required: true})
+	}
+	required := 0
+	for _, r := range bqs.sinks {
+		if r.opts.Required {
+			required++
+		}
+	}
+	if required == 0 {
+		// 至少有一个目标决定消息确认
+		bqs.sinks[0].opts.Required = true
+		required = 1
+	}
+
+	for _, r := range bqs.sinks {
+		if r.opts.WorkerNum <= 0 {
+			r.opts.WorkerNum = workerNum
+		}
+		if r.opts.BatchSize <= 0 {
+			r.opts.BatchSize = batchSize
+		}
+		if r.opts.FlushDelay <= 0 {
+			r.opts.FlushDelay = flushDelay
+		}
+		if r.opts.MaxRetries == 0 {
+			r.opts.MaxRetries = defaultWriteRetries
+		}
+		if r.opts.RetryBackoff <= 0 {
+			r.opts.RetryBackoff = defaultWriteRetryBackoff
+		}
+		r.in = make(chan *ParsedQuote, r.opts.BatchSize)
+		bqs.startSink(r)
+	}
+
+	// 分发到各写入目标：必需目标和阻塞目标等待，其他非必需目标积压时丢弃
+	bqs.wg.Add(1)
+	go func() {
+		defer bqs.wg.Done()
+		defer func() {
+			for _, r := range bqs.sinks {
+				close(r.in)
+			}
+		}()
+
+		for pq := range bqs.ParsedChan {
+			ack := &sinkAck{bqs: bqs, ack: pq.Ack}
+			ack.pending.Store(int32(required))
+			pq.Ack = ack
+			// 每个写入目标处理完后释放一次
+			pq.retain(len(bqs.sinks))
+			for _, r := range bqs.sinks {
+				if r.opts.Required || r.opts.Blocking {
+					r.in <- pq
+					continue
+				}
+				select {
+				case r.in <- pq:
+				default:
+					pq.Release()
+					r.dropped.Add(1)
+					if now := time.Now(); now.Sub(r.lastWarn) >= time.Minute {
+						r.lastWarn = now
+						logger.Warn("写入目标%s积压，丢弃消息（累计 %d 条）", r.sink.Name(), r.dropped.Load())
+					}
+				}
+			}
+		}
+	}()
+}
+
+// startSink 启动单个写入目标的分发器和写库协程，全部退出后关闭目标
+func (bqs *BondQuoteService) startSink(r *sinkRunner) {
+	workerNum := r.opts.WorkerNum
+	var wg sync.WaitGroup
 
 	// 为每个 worker 创建独立的数据分区
 	workerChans := make([]chan *ParsedQuote, workerNum)
 	for i := 0; i < workerNum; i++ {
-		workerChans[i] = make(chan *ParsedQuote, batchSize)
+		workerChans[i] = make(chan *ParsedQuote, r.opts.BatchSize)
 	}
 
 	// 启动分发器，按 ISIN 哈希分配到不同 worker
-	bqs.wg.Add(1)
+	wg.Add(1)
 	go func() {
-		defer bqs.wg.Done()
+		defer wg.Done()
 		defer func() {
 			for _, ch := range workerChans {
 				close(ch)
 			}
 		}()
 
-		for pq := range bqs.ParsedChan {
+		for pq := range r.in {
 			// 按 ISIN 哈希分配到特定 worker
 			workerIndex := hash(pq.Payload.SecurityID) % workerNum
 			workerChans[workerIndex] <- pq
@@ -167,21 +535,24 @@ func (bqs *BondQuoteService) StartDBWorkers(workerNum int, batchSize int, flushD
 
 	// 启动 DB workers
 	for i := 0; i < workerNum; i++ {
-		bqs.wg.Add(1)
+		wg.Add(1)
 		go func(workerChan chan *ParsedQuote) {
-			defer bqs.wg.Done()
-			ticker := time.NewTicker(flushDelay)
+			defer wg.Done()
+			ticker := time.NewTicker(r.opts.FlushDelay)
 			defer ticker.Stop()
 
-			batch := make([]*ParsedQuote, 0, batchSize)
+			batch := make([]*ParsedQuote, 0, r.opts.BatchSize)
 
 			flush := func() {
 				if len(batch) == 0 {
 					return
 				}
-				if err := InsertBatch(bqs.db, batch); err != nil {
-					logger.Error("批量写库失败: %v", err)
+				// 必需目标写入成功后才确认消息，失败则拒绝等待重新投递
+				bqs.flushSink(r, batch)
+				for _, pq := range batch {
+					pq.Release()
 				}
+				clear(batch)
 				batch = batch[:0]
 			}
 
@@ -193,7 +564,7 @@ func (bqs *BondQuoteService) StartDBWorkers(workerNum int, batchSize int, flushD
 						return
 					}
 					batch = append(batch, pq)
-					if len(batch) >= batchSize {
+					if len(batch) >= r.opts.BatchSize {
 						flush()
 					}
 				case <-ticker.C:
@@ -202,30 +573,91 @@ func (bqs *BondQuoteService) StartDBWorkers(workerNum int, batchSize int, flushD
 			}
 		}(workerChans[i])
 	}
+
+	bqs.wg.Add(1)
+	go func() {
+		defer bqs.wg.Done()
+		wg.Wait()
+		if err := r.sink.Close(); err != nil {
+			logger.Warn("关闭写入目标%s失败: %v", r.sink.Name(), err)
+		}
+	}()
 }
 
-// GetTodayTableName 获取当天表名
+// GetTodayTableName 获取当前业务日期的表名
 func GetTodayDetailTableName() string {
-	return fmt.Sprintf("t_bond_quote_detail_%s", time.Now().Format("20060102"))
+	return GetDetailTableName(BusinessDate(time.Now()))
 	// return "t_bond_quote_detail"
 }
 
 func GetTodayLatestTableName() string {
-	return fmt.Sprintf("t_bond_latest_quote_%s", time.Now().Format("20060102"))
+	return GetLatestTableName(BusinessDate(time.Now()))
 	// return "t_bond_latest_quote"
 
 }
 
+// GetDetailTableName 获取指定日期的明细表名
+func GetDetailTableName(date time.Time) string {
+	return fmt.Sprintf("t_bond_quote_detail_%s", date.Format("20060102"))
+}
+
+// GetLatestTableName 获取指定日期的最新行情表名
+func GetLatestTableName(date time.Time) string {
+	return fmt.Sprintf("t_bond_latest_quote_%s", date.Format("20060102"))
+}
+
+// GetEventTableName 获取指定日期的报价生命周期事件表名
+func GetEventTableName(date time.Time) string {
+	return fmt.Sprintf("t_bond_quote_event_%s", date.Format("20060102"))
+}
+
 // InsertBatch 把解析后的批次写入 DB
+// 按消息的业务日期写入对应日期的表（回放历史录制时写回当天的表），整批在同一事务内提交
+// 已存在的明细（重复投递、回放）跳过，重复写入同一批次不改变表内容
 func InsertBatch(db *gorm.DB, batch []*ParsedQuote) error {
-	// 获取当天表名
-	detailName := GetTodayDetailTableName()
-	lastestName := GetTodayLatestTableName()
+	_, err := insertBatch(db, batch)
+	return err
+}
+
+// insertResult 写入批次时跳过的行数
+type insertResult struct {
+	Duplicates int64 // 已存在而跳过的明细行数
+	Stale      int64 // 比已保存的旧而未覆盖的最新行情数
+}
 
-	// // 确保表存在
-	// if err := EnsureTableExists(db, tableName); err != nil {
-	// 	return err
-	// }
+// insertBatch 写入批次，返回跳过的行数
+func insertBatch(db *gorm.DB, batch []*ParsedQuote) (insertResult, error) {
+	// 按消息的业务日期分组
+	var days []time.Time
+	groups := make(map[string][]*ParsedQuote)
+	for _, pq := range batch {
+		date := quoteBusinessDate(pq)
+		day := date.Format("20060102")
+		if _, ok := groups[day]; !ok {
+			days = append(days, date)
+		}
+		groups[day] = append(groups[day], pq)
+	}
+
+	var res insertResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, day := range days {
+			r, err := insertDayBatch(tx, day, groups[day.Format("20060102")])
+			if err != nil {
+				return err
+			}
+			res.Duplicates += r.Duplicates
+			res.Stale += r.Stale
+		}
+		return nil
+	})
+	return res, err
+}
+
+// insertDayBatch 把同一天的消息写入当天的明细表和最新行情表，返回跳过的行数
+func insertDayBatch(tx *gorm.DB, day time.Time, batch []*ParsedQuote) (res insertResult, err error) {
+	detailName := GetDetailTableName(day)
+	lastestName := GetLatestTableName(day)
 
 	// 1. 聚合
 	var details []model.BondQuoteDetail
@@ -235,56 +667,31 @@ func InsertBatch(db *gorm.DB, batch []*ParsedQuote) error {
 		meta := pq.Meta       // 外层
 		payload := pq.Payload // 内层
 
-		// ASK / BID 明细
-		addDetail := func(q QuotePrice) {
-			qTime := time.UnixMilli(q.QuoteTime)
-			yield := q.Yield
-			minQty := q.MinTransQuantity
-
-			details = append(details, model.BondQuoteDetail{
-				MessageID:        meta.Data.MessageID,
-				MessageType:      meta.Data.MessageType,
-				Timestamp:        meta.Data.Timestamp,
-				ISIN:             payload.SecurityID,
-				BrokerID:         q.BrokerID,
-				Side:             q.Side,
-				Price:            q.Price,
-				Yield:            &yield,
-				OrderQty:         q.OrderQty,
-				MinTransQuantity: &minQty,
-				QuoteOrderNo:     q.QuoteOrderNo,
-				QuoteTime:        qTime,
-				SettleType:       &q.SettleType,
-				IsValid:          &q.IsValid,
-				IsTbd:            &q.IsTbd,
-				CreateTime:       time.Now(),
-			})
-		}
-
-		for _, ask := range payload.AskPrices {
-			addDetail(ask)
+		// ASK / BID 明细，档位序号按消息中的顺序
+		for i, ask := range payload.AskPrices {
+			details = append(details, newQuoteDetail(&meta, payload.SecurityID, ask, i+1, model.QuoteSourceStream))
 		}
-		for _, bid := range payload.BidPrices {
-			addDetail(bid)
+		for i, bid := range payload.BidPrices {
+			details = append(details, newQuoteDetail(&meta, payload.SecurityID, bid, i+1, model.QuoteSourceStream))
 		}
 
-		// 最新价处理（基于消息发送时间比较）
+		// 最新价处理（基于消息发送时间比较，相同时比较业务时间戳）
 		sendTime := time.UnixMilli(meta.SendTime)
 
-		// 检查是否需要更新（基于SendTime）
 		lq, ok := latestMap[payload.SecurityID]
 		if !ok {
-			lq = &model.BondLatestQuote{ISIN: payload.SecurityID}
+			// 推送写入的最新行情即为已确认
+			lq = &model.BondLatestQuote{ISIN: payload.SecurityID, Status: model.QuoteStatusConfirmed}
 			latestMap[payload.SecurityID] = lq
 		}
 
 		// 如果消息更新，则更新记录
-		shouldUpdate := lq.LastUpdateTime.IsZero() || sendTime.After(lq.LastUpdateTime)
+		shouldUpdate := !ok || newerQuote(meta.SendTime, meta.Data.Timestamp, lq.SendTime, lq.Timestamp)
 		if shouldUpdate {
 			// 将整个消息存储为JSON
 			rawJSON, err := json.Marshal(meta)
 			if err != nil {
-				return fmt.Errorf("marshal message to JSON: %w", err)
+				return res, fmt.Errorf("marshal message to JSON: %w", err)
 			}
 
 			lq.RawJSON = string(rawJSON)
@@ -296,32 +703,117 @@ func InsertBatch(db *gorm.DB, batch []*ParsedQuote) error {
 		}
 	}
 
-	// 2. 执行事务
-	return db.Transaction(func(tx *gorm.DB) error {
-		// 明细批量写 - 使用指定的表名
-		if len(details) > 0 {
-			// 使用指定表名插入数据
-			if err := tx.Table(detailName).CreateInBatches(details, 1000).Error; err != nil {
-				return err
-			}
+	// 2. 写入（调用方负责事务）
+	// 明细批量写 - 使用指定的表名，唯一键冲突的行跳过
+	if len(details) > 0 {
+		// 使用指定表名插入数据
+		created := tx.Table(detailName).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(details, 1000)
+		if created.Error != nil {
+			return res, created.Error
 		}
+		res.Duplicates = int64(len(details)) - created.RowsAffected
+	}
 
-		// 最新价 UPSERT
-		if len(latestMap) > 0 {
-			var latestSlice []model.BondLatestQuote
-			for _, v := range latestMap {
-				latestSlice = append(latestSlice, *v)
+	// 最新价 UPSERT：只有比已保存的更新时才覆盖，迟到或回放的旧消息不会替换新行情
+	if len(latestMap) > 0 {
+		isins := make([]string, 0, len(latestMap))
+		for isin := range latestMap {
+			isins = append(isins, isin)
+		}
+		var stored []model.BondLatestQuote
+		if err := tx.Table(lastestName).Select("isin", "send_time", "timestamp").
+			Where("isin IN ?", isins).Find(&stored).Error; err != nil {
+			return res, err
+		}
+		for _, cur := range stored {
+			if lq := latestMap[cur.ISIN]; !newerQuote(lq.SendTime, lq.Timestamp, cur.SendTime, cur.Timestamp) {
+				delete(latestMap, cur.ISIN)
+				res.Stale++
 			}
+		}
 
-			if err := tx.Table(lastestName).Clauses(clause.OnConflict{
-				Columns:   []clause.Column{{Name: "isin"}}, // 唯一键
-				UpdateAll: true,
-			}).Create(&latestSlice).Error; err != nil {
-				return err
+		var latestSlice []model.BondLatestQuote
+		for _, v := range latestMap {
+			latestSlice = append(latestSlice, *v)
+		}
+		// 查询之后其他协程可能已写入更新的行情，upsert 本身仍带条件
+		if len(latestSlice) > 0 {
+			if err := tx.Table(lastestName).Clauses(latestUpsert(tx)).Create(&latestSlice).Error; err != nil {
+				return res, err
 			}
 		}
-		return nil
-	})
+	}
+	return res, nil
+}
+
+// newerQuote 行情 a 是否比 b 新：先比较消息发送时间，相同时比较业务时间戳
+func newerQuote(aSendTime, aTimestamp, bSendTime, bTimestamp int64) bool {
+	return aSendTime > bSendTime || (aSendTime == bSendTime && aTimestamp > bTimestamp)
+}
+
+// latestUpdateColumns 最新行情 upsert 更新的列
+// MySQL 的 ON DUPLICATE KEY UPDATE 从左到右赋值，后面的条件读到的是已更新的值。
+// 条件用到的 timestamp、send_time 放在最后，前面各列都按原值判断；timestamp 在 send_time 之前，仍按原 send_time 判断。
+// send_time 的条件读到新的 timestamp 时只在发送时间相同时不成立，此时保留的原值与新值相同。
+var latestUpdateColumns = []string{"raw_json", "message_id", "message_type", "last_update_time", "status", "timestamp", "send_time"}
+
+// latestUpsert 带单调条件的最新行情 upsert，只有新行情比已保存的更新时才覆盖各列
+func latestUpsert(tx *gorm.DB) clause.OnConflict {
+	// 新值的引用方式：MySQL 为 VALUES(col)，SQLite 为 excluded.col
+	incoming := func(col string) string { return "excluded.`" + col + "`" }
+	if tx.Dialector.Name() == "mysql" {
+		incoming = func(col string) string { return "VALUES(`" + col + "`)" }
+	}
+	return clause.OnConflict{
+		Columns:   []clause.Column{{Name: "isin"}}, // 唯一键
+		DoUpdates: latestUpdateSet(incoming),
+	}
+}
+
+// latestUpdateSet 按 latestUpdateColumns 的顺序生成各列的条件赋值，incoming 返回新值的引用方式
+func latestUpdateSet(incoming func(col string) string) clause.Set {
+	newer := fmt.Sprintf("(%s > COALESCE(`send_time`, 0) OR (%s = COALESCE(`send_time`, 0) AND %s > COALESCE(`timestamp`, 0)))",
+		incoming("send_time"), incoming("send_time"), incoming("timestamp"))
+
+	set := make(clause.Set, 0, len(latestUpdateColumns))
+	for _, col := range latestUpdateColumns {
+		set = append(set, clause.Assignment{
+			Column: clause.Column{Name: col},
+			Value:  clause.Expr{SQL: fmt.Sprintf("CASE WHEN %s THEN %s ELSE `%s` END", newer, incoming(col), col)},
+		})
+	}
+	return set
+}
+
+// newQuoteDetail 把一档报价转换为明细行，level 为该档在消息同一方向内的序号
+func newQuoteDetail(meta *BondQuoteMessage, isin string, q QuotePrice, level int, source string) model.BondQuoteDetail {
+	yield := q.Yield
+	minQty := q.MinTransQuantity
+	var settleDate *time.Time
+	if d, err := time.ParseInLocation(time.DateOnly, q.SettleDate, BusinessLocation()); err == nil {
+		settleDate = &d
+	}
+	return model.BondQuoteDetail{
+		MessageID:        meta.Data.MessageID,
+		MessageType:      meta.Data.MessageType,
+		Timestamp:        meta.Data.Timestamp,
+		ISIN:             isin,
+		BrokerID:         q.BrokerID,
+		Side:             q.Side,
+		Price:            q.Price,
+		Yield:            &yield,
+		OrderQty:         q.OrderQty,
+		MinTransQuantity: &minQty,
+		QuoteOrderNo:     q.QuoteOrderNo,
+		LevelNo:          level,
+		QuoteTime:        time.UnixMilli(q.QuoteTime),
+		SettleDate:       settleDate,
+		SettleType:       &q.SettleType,
+		IsValid:          &q.IsValid,
+		IsTbd:            &q.IsTbd,
+		Source:           source,
+		CreateTime:       time.Now(),
+	}
 }
 
 // 简单哈希函数
@@ -0,0 +1,434 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/model"
+
+	"gorm.io/gorm/clause"
+)
+
+func TestLatestQuoteMonotonic(t *testing.T) {
+	conn := newTestDB(t)
+	latestTable := GetTodayLatestTableName()
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	base := time.Now().Truncate(time.Second)
+	quote := func(id string, sent time.Time, timestamp int64) *ParsedQuote {
+		pq, err := ParseBondQuote(atsmock.OrderBookMessage(id, "HK0000000001", sent, level, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timestamp != 0 {
+			pq.Meta.Data.Timestamp = timestamp
+		}
+		pq.ReceivedAt = time.Now()
+		return pq
+	}
+	latest := func() string {
+		t.Helper()
+		var lq model.BondLatestQuote
+		if err := conn.Table(latestTable).Where("isin = ?", "HK0000000001").Take(&lq).Error; err != nil {
+			t.Fatal(err)
+		}
+		return lq.MessageID
+	}
+
+	sink := NewDBSink("bond", conn)
+	steps := []struct {
+		pq    *ParsedQuote
+		want  string
+		stale int64
+	}{
+		{quote("M2", base.Add(2*time.Second), 0), "M2", 0},
+		// 迟到的旧消息不覆盖
+		{quote("M1", base.Add(time.Second), 0), "M2", 1},
+		// 发送时间相同时比较业务时间戳
+		{quote("M3", base.Add(2*time.Second), base.Add(3*time.Second).UnixMilli()), "M3", 1},
+		{quote("M4", base.Add(2*time.Second), base.UnixMilli()), "M3", 2},
+		// 同一消息重复投递
+		{quote("M3", base.Add(2*time.Second), base.Add(3*time.Second).UnixMilli()), "M3", 3},
+	}
+	for i, st := range steps {
+		if err := sink.Write([]*ParsedQuote{st.pq}); err != nil {
+			t.Fatalf("第%d步写入失败: %v", i+1, err)
+		}
+		if got := latest(); got != st.want || sink.Stale() != st.stale {
+			t.Fatalf("第%d步最新行情 = %s, 跳过 %d, 期望 %s/%d", i+1, got, sink.Stale(), st.want, st.stale)
+		}
+	}
+
+	// 同一批内取最新的一条
+	if err := sink.Write([]*ParsedQuote{quote("M6", base.Add(6*time.Second), 0), quote("M5", base.Add(5*time.Second), 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := latest(); got != "M6" {
+		t.Fatalf("批内最新行情 = %s", got)
+	}
+
+	// 查询之后被其他协程抢先写入时，upsert 条件仍拒绝旧行情
+	old := model.BondLatestQuote{ISIN: "HK0000000001", MessageID: "M0", SendTime: base.UnixMilli(), LastUpdateTime: base, Status: model.QuoteStatusConfirmed}
+	if err := conn.Table(latestTable).Clauses(latestUpsert(conn)).Create(&old).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := latest(); got != "M6" {
+		t.Fatalf("条件 upsert 后最新行情 = %s", got)
+	}
+}
+
+func TestDetailDedupeEmptyQuoteOrderNo(t *testing.T) {
+	conn := newTestDB(t)
+	// 报价单号为空的多个档位按档位序号区分，不互相当成重复
+	bids := []atsmock.Level{{Price: 99.5, OrderQty: 1000000}, {Price: 99.4, OrderQty: 2000000}, {Price: 99.3, OrderQty: 3000000}}
+	asks := []atsmock.Level{{Price: 100.5, OrderQty: 1000000}, {QuoteOrderNo: "A1", Price: 100.6, OrderQty: 2000000}}
+	body := atsmock.OrderBookMessage("M1", "HK0000000001", time.Now(), bids, asks)
+
+	sink := NewDBSink("bond", conn)
+	for i := 0; i < 2; i++ {
+		pq, err := ParseBondQuote(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pq.ReceivedAt = time.Now()
+		if err := sink.Write([]*ParsedQuote{pq}); err != nil {
+			t.Fatalf("第%d次写入失败: %v", i+1, err)
+		}
+	}
+	var rows int64
+	conn.Table(GetTodayDetailTableName()).Count(&rows)
+	if rows != 5 || sink.Duplicates() != 5 {
+		t.Fatalf("重复回放后明细 %d 行, 跳过 %d 行", rows, sink.Duplicates())
+	}
+	var prices []float64
+	conn.Table(GetTodayDetailTableName()).Where("side = ?", "BID").Order("level_no").Pluck("price", &prices)
+	if !slices.Equal(prices, []float64{99.5, 99.4, 99.3}) {
+		t.Fatalf("买方档位 = %v", prices)
+	}
+}
+
+func TestParseWorkersKeepISINOrder(t *testing.T) {
+	const isins, perISIN = 8, 300
+	var wg sync.WaitGroup
+	rawChan := make(chan *RawMessage, 100)
+	parsedChan := make(chan *ParsedQuote, 100)
+	deadChan := make(chan *DeadMessage, isins*perISIN)
+	bqs := NewBondQuoteService(nil, &wg, rawChan, parsedChan, deadChan)
+	sink := NewMemorySink("memory")
+	var dbWg sync.WaitGroup
+	writer := NewBondQuoteService(nil, &dbWg, nil, parsedChan, nil)
+	writer.AddSink(sink, SinkOptions{Required: true})
+	bqs.StartParseWorkers(8)
+	writer.StartDBWorkers(4, 50, 10*time.Millisecond)
+
+	// 交错推送，档位数不同使解析耗时不同；夹杂无法解析的消息
+	base := time.Now()
+	for n := 0; n < perISIN; n++ {
+		for i := 0; i < isins; i++ {
+			levels := make([]atsmock.Level, 1+(n*7+i*13)%40)
+			for k := range levels {
+				levels[k] = atsmock.Level{QuoteOrderNo: fmt.Sprintf("Q%d", k), BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}
+			}
+			isin := fmt.Sprintf("HK%010d", i)
+			rawChan <- &RawMessage{Body: atsmock.OrderBookMessage(fmt.Sprintf("%s-%06d", isin, n), isin, base, levels, nil), ReceivedAt: base}
+			if n%50 == 0 {
+				rawChan <- &RawMessage{Body: []byte(`{"data":`), ReceivedAt: base}
+			}
+		}
+	}
+	close(rawChan)
+	wg.Wait()
+	close(parsedChan)
+	dbWg.Wait()
+
+	last := make(map[string]string)
+	quotes := sink.Quotes()
+	for _, pq := range quotes {
+		isin, id := pq.Payload.SecurityID, pq.Meta.Data.MessageID
+		if id <= last[isin] {
+			t.Fatalf("%s 顺序错乱: %s 在 %s 之后", isin, id, last[isin])
+		}
+		last[isin] = id
+	}
+	if len(quotes) != isins*perISIN || len(deadChan) != isins*perISIN/50 {
+		t.Fatalf("写入 %d 条, 死信 %d 条", len(quotes), len(deadChan))
+	}
+}
+
+// parseBondQuoteStd 用标准库解析，作为对照
+func parseBondQuoteStd(raw []byte) (*ParsedQuote, error) {
+	pq := &ParsedQuote{}
+	if err := json.Unmarshal(raw, &pq.Meta); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(pq.Meta.Data.QuotePriceData), &pq.Payload); err != nil {
+		return nil, err
+	}
+	return pq, nil
+}
+
+// benchQuoteMessage 生成指定档位数的行情消息
+func benchQuoteMessage(id string, levels int) []byte {
+	ls := make([]atsmock.Level, levels)
+	for k := range ls {
+		ls[k] = atsmock.Level{QuoteOrderNo: fmt.Sprintf("Q%d", k), BrokerID: "B1", Price: 100 + float64(k)/8, Yield: 4, OrderQty: 1000000}
+	}
+	return atsmock.OrderBookMessage(id, "HK0000000001", time.UnixMilli(1752000000000), ls, ls)
+}
+
+func TestParseBondQuotePooled(t *testing.T) {
+	// 缺少部分字段的小消息在复用大消息的对象后不能残留旧值
+	inner, _ := json.Marshal(map[string]any{
+		"securityId": "HK0000000002",
+		"askPrices":  []map[string]any{{"quoteOrderNo": "A1", "side": "ASK", "price": 101}},
+	})
+	sparse, _ := json.Marshal(map[string]any{"data": map[string]any{"data": string(inner), "messageId": "P2"}})
+	// 转义字符、大小写不同的字段名、未知字段和 null
+	inner, _ = json.Marshal(map[string]any{
+		"SECURITYID": "HK0000000003",
+		"bidPrices":  []any{map[string]any{"brokerId": "B<1>&\"券商\"\U0001F600", "price": -1.5e2, "quoteTime": 0, "extra": []any{true, nil, map[string]any{}}}, nil},
+		"askPrices":  nil,
+	})
+	escaped, _ := json.Marshal(map[string]any{"data": map[string]any{"data": string(inner), "messageid": "P4\n"}, "sendTime": 1, "other": "x"})
+	surrogate := []byte(`{"data":{"messageId":"P5","data":"{\"securityId\":\"X\",\"askPrices\":[{\"brokerId\":\"\\ud83d\\ude00\\ud83d-\\u0041\"}]}"}}`)
+	bodies := [][]byte{benchQuoteMessage("P1", 20), sparse, benchQuoteMessage("P3", 3), escaped, surrogate}
+	check := func(body []byte) {
+		want, err := parseBondQuoteStd(body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		got, err := ParseBondQuote(body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// 复用的空切片与标准库的 nil 等价
+		if got.Meta != want.Meta || got.Payload.SecurityID != want.Payload.SecurityID ||
+			!slices.Equal(got.Payload.AskPrices, want.Payload.AskPrices) || !slices.Equal(got.Payload.BidPrices, want.Payload.BidPrices) {
+			t.Errorf("%s 解析结果与标准库不一致:\n%+v\n%+v", want.Meta.Data.MessageID, got.Payload, want.Payload)
+		}
+		got.Release()
+	}
+	for i := 0; i < 3; i++ {
+		for _, body := range bodies {
+			check(body)
+		}
+	}
+
+	// 并发解析和释放
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				check(bodies[(g+i)%len(bodies)])
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for _, bad := range []string{
+		`{"data":`, `{"data":{"data":"{\"askPrices\":[]}"}}`, `{"sendTime":"x"}`, `{} {}`, `{"sendTime":1.5}`,
+		`{"data":{"data":"{\"securityId\":\"X\",\"askPrices\":[{\"price\":01}]}"}}`,
+	} {
+		if _, err := ParseBondQuote([]byte(bad)); err == nil {
+			t.Errorf("%s 应解析失败", bad)
+		}
+	}
+}
+
+// recordQuoteMessage record.md 中记录的格式：data 为对象、ISO 时间、securityCode、settleDate
+const recordQuoteMessage = `{
+  "sendTime": 1719564492000,
+  "wsMessageType": "BOND_QUOTE",
+  "data": {
+    "messageId": "MSG2025070300001",
+    "messageType": "BOND_QUOTE_UPDATE",
+    "timestamp": 1719564492000,
+    "securityCode": "019603.IB",
+    "data": {
+      "askPrices": [{"brokerId": "BROKER001", "isTbd": "N", "isValid": "Y", "minTransQuantity": 1000000, "orderQty": 5000000,
+        "price": 100.25, "quoteOrderNo": "ASK2025070300001", "quoteTime": "2025-07-03T14:54:52.000Z",
+        "securityCode": "019603.IB", "settleType": "T+1", "settleDate": "2025-07-04", "side": "ASK", "yield": 3.125}],
+      "bidPrices": [{"brokerId": "BROKER002", "isTbd": "N", "isValid": "Y", "minTransQuantity": 1000000, "orderQty": 10000000,
+        "price": 100.15, "quoteOrderNo": "BID2025070300001", "quoteTime": "2025-07-03T22:54:30+08:00",
+        "securityCode": "019603.IB", "settleType": "T+1", "settleDate": "20250704", "side": "BID", "yield": 3.135}]
+    }
+  }
+}`
+
+func TestParseBondQuoteSchemaVariants(t *testing.T) {
+	conn := newTestDB(t)
+	pq, err := ParseBondQuote([]byte(recordQuoteMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pq.Payload
+	askTime := time.Date(2025, 7, 3, 14, 54, 52, 0, time.UTC).UnixMilli()
+	bidTime := time.Date(2025, 7, 3, 14, 54, 30, 0, time.UTC).UnixMilli()
+	if p.SecurityID != "019603.IB" || pq.Meta.WsMessageType != "BOND_QUOTE" || len(p.AskPrices) != 1 || len(p.BidPrices) != 1 ||
+		p.AskPrices[0].QuoteTime != askTime || p.BidPrices[0].QuoteTime != bidTime ||
+		p.AskPrices[0].SecurityID != "019603.IB" || p.AskPrices[0].SettleDate != "2025-07-04" || p.BidPrices[0].SettleDate != "2025-07-04" {
+		t.Fatalf("解析结果 = %+v", p)
+	}
+
+	// 内层报价规范化为标准格式，下游用标准库即可读取
+	var normalized QuotePriceData
+	if err := json.Unmarshal([]byte(pq.Meta.Data.QuotePriceData), &normalized); err != nil {
+		t.Fatalf("规范化后的报价 %s: %v", pq.Meta.Data.QuotePriceData, err)
+	}
+	if normalized.SecurityID != p.SecurityID || !slices.Equal(normalized.AskPrices, p.AskPrices) || !slices.Equal(normalized.BidPrices, p.BidPrices) {
+		t.Fatalf("规范化后的报价 = %+v", normalized)
+	}
+	if key := rawQuoteKey([]byte(recordQuoteMessage)); key != "019603.IB" {
+		t.Fatalf("合并键 = %q", key)
+	}
+
+	// 结算日期写入明细
+	pq.ReceivedAt = time.Now()
+	if err := NewDBSink("bond", conn).Write([]*ParsedQuote{pq}); err != nil {
+		t.Fatal(err)
+	}
+	var details []model.BondQuoteDetail
+	if err := conn.Table(GetTodayDetailTableName()).Order("side").Find(&details).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != 2 || details[0].SettleDate == nil || details[0].SettleDate.Format(time.DateOnly) != "2025-07-04" ||
+		details[1].ISIN != "019603.IB" || details[1].QuoteTime.UnixMilli() != bidTime {
+		t.Fatalf("明细 = %+v", details)
+	}
+
+	// 无法识别的格式给出具体的死信原因
+	for body, reason := range map[string]string{
+		`{"data":{"data":42}}`: "data.data: cannot unmarshal number into string or object",
+		`{"data":{"data":{"securityId":"X","askPrices":[{},{"price":"abc"}]}}}`:          "askPrices[1].price: cannot unmarshal string into float64",
+		`{"data":{"data":{"securityId":"X","askPrices":{}}}}`:                            "askPrices: cannot unmarshal object into array",
+		`{"data":{"data":{"securityId":"X","bidPrices":[{"quoteTime":"昨天"}]}}}`:          `bidPrices[0].quoteTime: cannot parse time "昨天"`,
+		`{"data":{"data":{"securityId":"X","bidPrices":[{"settleDate":"04/07/2025"}]}}}`: `bidPrices[0].settleDate: cannot parse date "04/07/2025"`,
+		`{"sendTime":true}`:                             "sendTime: cannot unmarshal bool into epoch millis or ISO-8601 time",
+		`{"data":{"data":{"bidPrices":[{"price":1}]}}}`: "securityId is empty",
+	} {
+		_, err := ParseBondQuote([]byte(body))
+		if err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("%s 解析错误 = %v, 期望包含 %q", body, err, reason)
+		}
+	}
+}
+
+// go test ./service -run ^$ -bench ParseBondQuote -benchmem
+func BenchmarkParseBondQuote(b *testing.B) {
+	for _, levels := range []int{1, 10, 50} {
+		body := benchQuoteMessage("B1", levels)
+		b.Run(fmt.Sprintf("encoding_json/levels=%d", levels), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				if _, err := parseBondQuoteStd(body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("pooled/levels=%d", levels), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				pq, err := ParseBondQuote(body)
+				if err != nil {
+					b.Fatal(err)
+				}
+				pq.Release()
+			}
+		})
+	}
+}
+
+// TestLatestUpsertSequentialAssignment 按 MySQL 的语义（SET 从左到右赋值，后面的表达式读到已更新的列）
+// 逐列执行最新行情 upsert 的赋值，检查各列对"新行情是否胜出"的判断一致
+func TestSettleDateBusinessLocation(t *testing.T) {
+	cal, err := calendar.New(&config.CalendarConfig{Timezone: "Asia/Hong_Kong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBusinessCalendar(cal)
+	defer SetBusinessCalendar(nil)
+
+	// ISO-8601 时间按业务日期时区取日期
+	pq, err := ParseBondQuote([]byte(`{"data":{"messageId":"M1","data":{"securityId":"X","bidPrices":[{"side":"BID","settleDate":"2025-07-03T20:00:00Z"}]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pq.Release()
+	q := pq.Payload.BidPrices[0]
+	if q.SettleDate != "2025-07-04" {
+		t.Fatalf("结算日期 = %s", q.SettleDate)
+	}
+	// 明细的结算日期为业务日期时区的零点，与服务器时区无关
+	d := newQuoteDetail(&pq.Meta, "X", q, 1, model.QuoteSourceStream)
+	if want := time.Date(2025, 7, 4, 0, 0, 0, 0, cal.Location()); d.SettleDate == nil || !d.SettleDate.Equal(want) {
+		t.Fatalf("明细结算日期 = %v, 期望 %v", d.SettleDate, want)
+	}
+}
+
+func TestLatestUpsertSequentialAssignment(t *testing.T) {
+	conn := newTestDB(t)
+	latestTable := GetTodayLatestTableName()
+	if err := conn.Table("incoming_row").AutoMigrate(&model.BondLatestQuote{}); err != nil {
+		t.Fatal(err)
+	}
+	set := latestUpdateSet(func(col string) string { return "(SELECT `" + col + "` FROM incoming_row)" })
+
+	old := model.BondLatestQuote{ISIN: "HK0000000001", MessageID: "OLD", SendTime: 100, Timestamp: 50, Status: model.QuoteStatusConfirmed}
+	cases := []struct {
+		name         string
+		sendTime, ts int64
+		wins         bool
+	}{
+		{"发送时间更新、时间戳更小", 200, 40, true},
+		{"发送时间更新、时间戳更大", 200, 60, true},
+		{"发送时间相同、时间戳更新", 100, 60, true},
+		{"发送时间相同、时间戳更旧", 100, 40, false},
+		{"完全相同", 100, 50, false},
+		{"发送时间更旧", 50, 90, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn.Table(latestTable).Where("1 = 1").Delete(&model.BondLatestQuote{})
+			conn.Table("incoming_row").Where("1 = 1").Delete(&model.BondLatestQuote{})
+			row := old
+			if err := conn.Table(latestTable).Create(&row).Error; err != nil {
+				t.Fatal(err)
+			}
+			in := model.BondLatestQuote{ISIN: old.ISIN, MessageID: "NEW", SendTime: tc.sendTime, Timestamp: tc.ts, Status: model.QuoteStatusConfirmed}
+			if err := conn.Table("incoming_row").Create(&in).Error; err != nil {
+				t.Fatal(err)
+			}
+			for _, a := range set {
+				sql := fmt.Sprintf("UPDATE `%s` SET `%s` = %s WHERE isin = ?", latestTable, a.Column.Name, a.Value.(clause.Expr).SQL)
+				if err := conn.Exec(sql, old.ISIN).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got model.BondLatestQuote
+			if err := conn.Table(latestTable).Take(&got).Error; err != nil {
+				t.Fatal(err)
+			}
+			want := old
+			if tc.wins {
+				want = in
+			}
+			if got.MessageID != want.MessageID || got.SendTime != want.SendTime || got.Timestamp != want.Timestamp {
+				t.Fatalf("最新行情 = %s/%d/%d, 期望 %s/%d/%d", got.MessageID, got.SendTime, got.Timestamp, want.MessageID, want.SendTime, want.Timestamp)
+			}
+		})
+	}
+}
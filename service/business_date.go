@@ -0,0 +1,79 @@
+package service
+
+// 业务日期
+// 按日分表的日期取业务日期：按交易日历的时区和日切时间计算，非交易日顺延到下一个交易日。
+// 行情按消息自身的时间（timestamp，缺失时依次用 sendTime、接收时间）写入对应业务日期的表，
+// 零点或日切附近积压、重放的行情不会因写入时刻落到下一天的表。
+// 建表、查询和导出使用同一个交易日历；未设置交易日历时按服务器时区的自然日。
+
+import (
+	"sync/atomic"
+	"time"
+
+	"wealth-bond-quote-service/internal/calendar"
+)
+
+// maxMessageClockSkew 消息时间与接收时间相差超过该值时视为时间戳异常，按接收时间路由
+const maxMessageClockSkew = 24 * time.Hour
+
+var businessCalendar atomic.Pointer[calendar.Calendar]
+
+// SetBusinessCalendar 设置按日分表、建表、查询和导出共用的交易日历，nil 表示按自然日
+func SetBusinessCalendar(cal *calendar.Calendar) {
+	businessCalendar.Store(cal)
+}
+
+// BusinessDate 返回t所属的业务日期（零点）
+func BusinessDate(t time.Time) time.Time {
+	if cal := businessCalendar.Load(); cal != nil {
+		return cal.BusinessDate(t)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// BusinessDayRange 业务日期d包含的时间范围 [start, end)，按接收时间等筛选某一业务日期的记录时使用
+func BusinessDayRange(d time.Time) (start, end time.Time) {
+	if cal := businessCalendar.Load(); cal != nil {
+		return cal.BusinessDayRange(d)
+	}
+	start = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, d.Location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+// IsBusinessDay 是否为交易日，未设置交易日历时每天都是
+func IsBusinessDay(d time.Time) bool {
+	if cal := businessCalendar.Load(); cal != nil {
+		return cal.IsTradingDay(d)
+	}
+	return true
+}
+
+// BusinessLocation 业务日期所用的时区，查询和导出按该时区解析日期
+func BusinessLocation() *time.Location {
+	if cal := businessCalendar.Load(); cal != nil {
+		return cal.Location()
+	}
+	return time.Local
+}
+
+// messageTime 行情的业务时间：timestamp，缺失或与接收时间相差过大时依次用 sendTime、接收时间
+func messageTime(timestamp, sendTime int64, received time.Time) time.Time {
+	if received.IsZero() {
+		received = time.Now()
+	}
+	for _, ms := range []int64{timestamp, sendTime} {
+		if ms <= 0 {
+			continue
+		}
+		t := time.UnixMilli(ms)
+		if d := t.Sub(received); d < maxMessageClockSkew && d > -maxMessageClockSkew {
+			return t
+		}
+	}
+	return received
+}
+
+// quoteBusinessDate 行情所属的业务日期，决定写入哪一天的表
+func quoteBusinessDate(pq *ParsedQuote) time.Time {
+	return BusinessDate(messageTime(pq.Meta.Data.Timestamp, pq.Meta.SendTime, pq.ReceivedAt))
+}
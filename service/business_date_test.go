@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+func TestBusinessDateRouting(t *testing.T) {
+	cal, err := calendar.New(&config.CalendarConfig{
+		Timezone:   "Asia/Hong_Kong",
+		Holidays:   []string{"2025-10-01"},
+		CutoffTime: "17:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBusinessCalendar(cal)
+	defer SetBusinessCalendar(nil)
+	loc := cal.Location()
+
+	conn := newTestDB(t)
+	sink := NewDBSink("bond", conn)
+	quote := func(id string, ts, received time.Time) *ParsedQuote {
+		pq, err := ParseBondQuote(atsmock.OrderBookMessage(id, "HK0000000001", ts, []atsmock.Level{{QuoteOrderNo: id, Price: 100, OrderQty: 1e6}}, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pq.ReceivedAt = received
+		return pq
+	}
+	friday := time.Date(2025, 9, 26, 0, 0, 0, 0, loc)
+	batch := []*ParsedQuote{
+		// 日切前
+		quote("M1", friday.Add(16*time.Hour+59*time.Minute), friday.Add(16*time.Hour+59*time.Minute)),
+		// 日切后的行情顺延到下周一，按消息时间而不是接收时间
+		quote("M2", friday.Add(17*time.Hour+30*time.Minute), friday.Add(16*time.Hour+59*time.Minute)),
+		// 积压到周六凌晨才写入，仍按消息时间计入周五
+		quote("M3", friday.Add(16*time.Hour), friday.Add(31*time.Hour)),
+		// 时间戳异常时按接收时间
+		quote("M4", friday.AddDate(0, 0, -10), friday.Add(10*time.Hour)),
+	}
+	if err := sink.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	for day, want := range map[string]int64{"20250926": 3, "20250929": 1} {
+		var n int64
+		if err := conn.Table("t_bond_quote_detail_" + day).Count(&n).Error; err != nil || n != want {
+			t.Fatalf("%s 明细行数 = %d, 期望 %d, err = %v", day, n, want, err)
+		}
+	}
+	if conn.Migrator().HasTable(GetDetailTableName(friday.AddDate(0, 0, 1))) {
+		t.Fatal("周六不应建表")
+	}
+
+	// 建表跳过非交易日
+	if err := NewCreateTableService(conn).CreateTables(time.Date(2025, 9, 29, 0, 0, 0, 0, loc)); err != nil {
+		t.Fatal(err)
+	}
+	for day, want := range map[string]bool{"20250930": true, "20251001": false, "20251004": false, "20251006": true} {
+		if got := conn.Migrator().HasTable("t_bond_latest_quote_" + day); got != want {
+			t.Fatalf("%s 建表 = %v, 期望 %v", day, got, want)
+		}
+	}
+}
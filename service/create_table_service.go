@@ -10,7 +10,7 @@ import (
 	"gorm.io/gorm"
 )
 
-// 每周创建七张表（t_bond_quote_detail_%s，t_bond_latest_quote_%s）
+// 每周创建七天的表（t_bond_quote_detail_%s，t_bond_latest_quote_%s，t_bond_quote_event_%s）
 type createTableService struct {
 	db *gorm.DB
 }
@@ -19,31 +19,18 @@ func NewCreateTableService(db *gorm.DB) *createTableService {
 	return &createTableService{db: db}
 }
 
-// 为指定日期所在周创建表
+// 为指定日期所在周创建表，跳过非交易日（非交易日的行情计入下一交易日的表）
 func (s *createTableService) CreateTables(date time.Time) error {
 	// 获取从指定日期到下一个周一的所有日期
 	weekDates := getWeekDates(date)
 
 	for _, d := range weekDates {
-		dateStr := d.Format("20060102")
-		detailTable := fmt.Sprintf("t_bond_quote_detail_%s", dateStr)
-		latestTable := fmt.Sprintf("t_bond_latest_quote_%s", dateStr)
-
-		// 检查并创建明细表
-		if !s.db.Migrator().HasTable(detailTable) {
-			if err := s.db.Table(detailTable).AutoMigrate(&model.BondQuoteDetail{}); err != nil {
-				return fmt.Errorf("创建明细表失败 %s: %w", detailTable, err)
-			}
+		if !IsBusinessDay(d) {
+			continue
 		}
-
-		// 检查并创建最新行情表
-		if !s.db.Migrator().HasTable(latestTable) {
-			if err := s.db.Table(latestTable).AutoMigrate(&model.BondLatestQuote{}); err != nil {
-				return fmt.Errorf("创建最新行情表失败 %s: %w", latestTable, err)
-			}
+		if err := s.EnsureDailyTablesExist(d); err != nil {
+			return err
 		}
-
-		logger.Info("成功创建表: %s, %s", detailTable, latestTable)
 	}
 
 	return nil
@@ -53,37 +40,42 @@ func (s *createTableService) CreateTables(date time.Time) error {
 func (s *createTableService) StartWeeklyTableCreation() {
 	logger.Info("启动每周建表服务...")
 
-	// 立即为本周创建表
-	if err := s.CreateTables(time.Now()); err != nil {
+	// 立即为本周创建表，从当前业务日期开始
+	if err := s.CreateTables(BusinessDate(time.Now())); err != nil {
 		logger.Error("为本周创建表失败: %v", err)
 	}
 
 	// 启动定时任务
 	go func() {
 		for {
-			// 计算到下周一的时间
+			// 等待到业务日期时区的下周一零点
 			now := time.Now()
-			daysUntilMonday := int(time.Monday - now.Weekday())
-			if daysUntilMonday <= 0 {
-				daysUntilMonday += 7
-			}
-			nextMonday := time.Date(now.Year(), now.Month(), now.Day()+daysUntilMonday, 0, 0, 0, 0, now.Location())
-
-			// 等待到下周一
+			nextMonday := nextWeeklyCreation(now)
 			waitDuration := nextMonday.Sub(now)
 			logger.Info("下次建表将在 %s 后执行 (下周一: %s)", waitDuration, nextMonday.Format("2006-01-02"))
 			time.Sleep(waitDuration)
 
-			// 为下一周创建表
-			if err := s.CreateTables(nextMonday); err != nil {
+			// 为下一周创建表，从当前业务日期开始
+			start := BusinessDate(time.Now())
+			if err := s.CreateTables(start); err != nil {
 				logger.Error("为下周创建表失败: %v", err)
 			} else {
-				logger.Info("成功为下周创建表 (周开始日期: %s)", nextMonday.Format("2006-01-02"))
+				logger.Info("成功为下周创建表 (周开始日期: %s)", start.Format("2006-01-02"))
 			}
 		}
 	}()
 }
 
+// nextWeeklyCreation 下一次建表的时间：业务日期时区（交易日历时区）的下周一零点，与服务器时区无关
+func nextWeeklyCreation(now time.Time) time.Time {
+	now = now.In(BusinessLocation())
+	daysUntilMonday := int(time.Monday - now.Weekday())
+	if daysUntilMonday <= 0 {
+		daysUntilMonday += 7
+	}
+	return time.Date(now.Year(), now.Month(), now.Day()+daysUntilMonday, 0, 0, 0, 0, now.Location())
+}
+
 // 获取从指定日期到下一个周一的所有日期
 // 例如：如果今天是周三，则返回[周三, 周四, 周五, 周六, 周日, 周一]
 // 用于批量创建本周剩余天数的表
@@ -104,26 +96,96 @@ func getWeekDates(date time.Time) []time.Time {
 	return dates
 }
 
-// 检查指定日期的表是否存在，不存在则创建
+// 建表之后新增的列，升级前已创建的表需要补齐
+var (
+	detailAddedColumns = []string{"Source", "LevelNo"}
+	latestAddedColumns = []string{"Status"}
+)
+
+// 建表之后新增的索引（按其中一个字段名查找），升级前已创建的表需要补齐
+var (
+	detailAddedIndexes = []string{"LevelNo"} // (message_id, quote_order_no, side, level_no) 唯一索引
+	latestAddedIndexes []string
+)
+
+// 被新索引取代的旧索引名（composite 名），新索引建好后删除
+var (
+	// (message_id, quote_order_no, side) 会把同一消息中报价单号为空的多个档位当成重复
+	detailReplacedIndexes = []string{"dedupe"}
+)
+
+// 检查指定日期的表是否存在，不存在则创建；已存在时补齐新增列
 func (s *createTableService) EnsureDailyTablesExist(date time.Time) error {
-	dateStr := date.Format("20060102")
-	detailTable := fmt.Sprintf("t_bond_quote_detail_%s", dateStr)
-	latestTable := fmt.Sprintf("t_bond_latest_quote_%s", dateStr)
+	detailTable := GetDetailTableName(date)
+	latestTable := GetLatestTableName(date)
 
 	// 检查并创建明细表
-	if !s.db.Migrator().HasTable(detailTable) {
-		if err := s.db.Table(detailTable).AutoMigrate(&model.BondQuoteDetail{}); err != nil {
-			return fmt.Errorf("创建明细表失败 %s: %w", detailTable, err)
-		}
+	if err := s.ensureTable(detailTable, &model.BondQuoteDetail{}, detailAddedColumns, detailAddedIndexes); err != nil {
+		return fmt.Errorf("创建明细表失败 %s: %w", detailTable, err)
 	}
+	s.dropReplacedIndexes(detailTable, &model.BondQuoteDetail{}, detailAddedIndexes, detailReplacedIndexes)
 
 	// 检查并创建最新行情表
-	if !s.db.Migrator().HasTable(latestTable) {
-		if err := s.db.Table(latestTable).AutoMigrate(&model.BondLatestQuote{}); err != nil {
-			return fmt.Errorf("创建最新行情表失败 %s: %w", latestTable, err)
-		}
+	if err := s.ensureTable(latestTable, &model.BondLatestQuote{}, latestAddedColumns, latestAddedIndexes); err != nil {
+		return fmt.Errorf("创建最新行情表失败 %s: %w", latestTable, err)
 	}
 
-	logger.Info("成功创建表: %s, %s", detailTable, latestTable)
+	// 检查并创建报价生命周期事件表
+	eventTable := GetEventTableName(date)
+	if err := s.ensureTable(eventTable, &model.BondQuoteEvent{}, nil, nil); err != nil {
+		return fmt.Errorf("创建报价事件表失败 %s: %w", eventTable, err)
+	}
+
+	logger.Info("成功创建表: %s, %s, %s", detailTable, latestTable, eventTable)
+	return nil
+}
+
+// ensureTable 表不存在时创建，存在时只补充缺失的新增列和索引（不修改已有列）
+func (s *createTableService) ensureTable(table string, m any, addedColumns, addedIndexes []string) error {
+	tx := s.db.Table(table)
+	if !tx.Migrator().HasTable(table) {
+		return tx.AutoMigrate(m)
+	}
+	for _, col := range addedColumns {
+		if tx.Migrator().HasColumn(m, col) {
+			continue
+		}
+		if err := tx.Migrator().AddColumn(m, col); err != nil {
+			return fmt.Errorf("补充列%s失败: %w", col, err)
+		}
+		logger.Info("表 %s 补充列: %s", table, col)
+	}
+	for _, idx := range addedIndexes {
+		if tx.Migrator().HasIndex(m, idx) {
+			continue
+		}
+		// 已有重复数据或列类型不兼容时无法建索引，不影响写入，只是该表不去重
+		if err := tx.Migrator().CreateIndex(m, idx); err != nil {
+			logger.Warn("表 %s 补充索引失败，该表不去重: %v", table, err)
+			continue
+		}
+		logger.Info("表 %s 补充索引: %s", table, idx)
+	}
 	return nil
 }
+
+// dropReplacedIndexes 新索引都已建好后删除被取代的旧索引；新索引没建成时保留旧索引，至少按旧规则去重
+func (s *createTableService) dropReplacedIndexes(table string, m any, addedIndexes, replaced []string) {
+	tx := s.db.Table(table)
+	for _, idx := range addedIndexes {
+		if !tx.Migrator().HasIndex(m, idx) {
+			return
+		}
+	}
+	for _, composite := range replaced {
+		name := s.db.NamingStrategy.IndexName(table, composite)
+		if !tx.Migrator().HasIndex(m, name) {
+			continue
+		}
+		if err := tx.Migrator().DropIndex(m, name); err != nil {
+			logger.Warn("表 %s 删除旧索引 %s 失败: %v", table, name, err)
+			continue
+		}
+		logger.Info("表 %s 删除旧索引: %s", table, name)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/model"
+	"wealth-bond-quote-service/pkg/db"
+)
+
+func TestEnsureTablesAddsColumns(t *testing.T) {
+	conn, err := db.InitSqliteConn(filepath.Join(t.TempDir(), "old.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 升级前建好的表没有 source/status 列和去重索引
+	type oldDetail struct {
+		ID           int64  `gorm:"column:id;primaryKey;autoIncrement"`
+		ISIN         string `gorm:"column:isin"`
+		MessageID    string `gorm:"column:message_id"`
+		Side         string `gorm:"column:side"`
+		QuoteOrderNo string `gorm:"column:quote_order_no"`
+	}
+	type oldLatest struct {
+		ISIN    string `gorm:"column:isin;primaryKey"`
+		RawJSON string `gorm:"column:raw_json"`
+	}
+	today := time.Now()
+	if err := conn.Table(GetDetailTableName(today)).AutoMigrate(&oldDetail{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Table(GetLatestTableName(today)).AutoMigrate(&oldLatest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewCreateTableService(conn).EnsureDailyTablesExist(today); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.Table(GetDetailTableName(today)).Migrator().HasColumn(&model.BondQuoteDetail{}, "Source") {
+		t.Fatal("明细表未补充 source 列")
+	}
+	if !conn.Table(GetDetailTableName(today)).Migrator().HasIndex(&model.BondQuoteDetail{}, "LevelNo") {
+		t.Fatal("明细表未补充去重索引")
+	}
+	if !conn.Table(GetLatestTableName(today)).Migrator().HasColumn(&model.BondLatestQuote{}, "Status") {
+		t.Fatal("最新行情表未补充 status 列")
+	}
+
+	// 已有不含档位序号的去重索引时，换成新索引
+	type dedupeDetail struct {
+		ID           int64  `gorm:"column:id;primaryKey;autoIncrement"`
+		MessageID    string `gorm:"column:message_id;uniqueIndex:,composite:dedupe,priority:1"`
+		Side         string `gorm:"column:side;uniqueIndex:,composite:dedupe,priority:3"`
+		QuoteOrderNo string `gorm:"column:quote_order_no;uniqueIndex:,composite:dedupe,priority:2"`
+	}
+	tomorrow := today.AddDate(0, 0, 1)
+	detailTable := GetDetailTableName(tomorrow)
+	if err := conn.Table(detailTable).AutoMigrate(&dedupeDetail{}); err != nil {
+		t.Fatal(err)
+	}
+	oldIndex := conn.NamingStrategy.IndexName(detailTable, "dedupe")
+	if !conn.Migrator().HasIndex(detailTable, oldIndex) {
+		t.Fatalf("旧索引 %s 未建立", oldIndex)
+	}
+	if err := NewCreateTableService(conn).EnsureDailyTablesExist(tomorrow); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.Table(detailTable).Migrator().HasIndex(&model.BondQuoteDetail{}, "LevelNo") {
+		t.Fatal("明细表未补充含档位序号的去重索引")
+	}
+	if conn.Migrator().HasIndex(detailTable, oldIndex) {
+		t.Fatal("旧去重索引未删除")
+	}
+}
+
+func TestNextWeeklyCreation(t *testing.T) {
+	cal, err := calendar.New(&config.CalendarConfig{Timezone: "Asia/Hong_Kong", CutoffTime: "17:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBusinessCalendar(cal)
+	defer SetBusinessCalendar(nil)
+	loc := cal.Location()
+
+	// 服务器按UTC运行时仍按交易日历时区的周一零点建表
+	for now, want := range map[time.Time]time.Time{
+		time.Date(2025, 9, 28, 15, 0, 0, 0, time.UTC): time.Date(2025, 9, 29, 0, 0, 0, 0, loc), // 香港周日23:00
+		time.Date(2025, 9, 28, 16, 0, 0, 0, time.UTC): time.Date(2025, 10, 6, 0, 0, 0, 0, loc), // 香港周一零点
+		time.Date(2025, 9, 26, 10, 0, 0, 0, time.UTC): time.Date(2025, 9, 29, 0, 0, 0, 0, loc), // 香港周五18:00
+	} {
+		if got := nextWeeklyCreation(now); !got.Equal(want) {
+			t.Fatalf("%s 下次建表 = %s, 期望 %s", now, got, want)
+		}
+	}
+}
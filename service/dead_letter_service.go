@@ -0,0 +1,290 @@
+package service
+
+// 死信存储与重新处理
+// 1. 消费 DeadChan，把解析失败的原始消息连同失败原因、接收时间批量写入 t_bond_dead_letter
+//    写库失败只记录日志并丢弃，保证解析层永远不会因死信通道写满而阻塞
+// 2. 查询、查看、重新处理、清理死信：解析器修复后可把某天的死信重新解析并写入 InsertBatch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"wealth-bond-quote-service/model"
+	logger "wealth-bond-quote-service/pkg/log"
+)
+
+const (
+	deadLetterBatchSize  = 100         // 死信批量写库条数
+	deadLetterFlushDelay = time.Second // 死信批量写库最长等待
+	deadLetterReasonMax  = 512         // 失败原因最大长度（字符）
+	deadLetterPageMax    = 500         // 列表单页最大条数，也是重新处理的批次大小
+	deadLetterDateLayout = "20060102"  // 日期参数格式
+)
+
+// ErrPurgeWithoutFilter 清理死信时未指定任何条件
+var ErrPurgeWithoutFilter = errors.New("清理死信需要指定日期、状态或ID")
+
+// DeadLetterQuery 死信查询条件，各条件之间为"与"关系
+type DeadLetterQuery struct {
+	IDs      []int64 `json:"ids" form:"ids"`           // 指定ID
+	Date     string  `json:"date" form:"date"`         // 接收时间所属的业务日期，格式: YYYYMMDD
+	Before   string  `json:"before" form:"before"`     // 接收时间早于该业务日期，格式: YYYYMMDD
+	Status   string  `json:"status" form:"status"`     // 处理状态
+	Page     int     `json:"page" form:"page"`         // 页码，从1开始
+	PageSize int     `json:"pageSize" form:"pageSize"` // 每页条数，默认100
+}
+
+// ReprocessResult 重新处理结果
+type ReprocessResult struct {
+	Total       int `json:"total"`       // 参与重新处理的死信数
+	Reprocessed int `json:"reprocessed"` // 解析并写库成功
+	Failed      int `json:"failed"`      // 仍然失败
+}
+
+// DeadLetterService 死信服务
+type DeadLetterService struct {
+	db *gorm.DB
+}
+
+// NewDeadLetterService 创建死信服务
+func NewDeadLetterService(db *gorm.DB) *DeadLetterService {
+	return &DeadLetterService{db: db}
+}
+
+// EnsureTable 创建死信表
+func (s *DeadLetterService) EnsureTable() error {
+	return s.db.AutoMigrate(&model.DeadLetter{})
+}
+
+// Start 消费死信通道并批量落库，通道关闭后写完剩余死信再退出
+func (s *DeadLetterService) Start(deadChan <-chan *DeadMessage, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(deadLetterFlushDelay)
+		defer ticker.Stop()
+
+		batch := make([]model.DeadLetter, 0, deadLetterBatchSize)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			if err := s.db.CreateInBatches(batch, deadLetterBatchSize).Error; err != nil {
+				logger.Error("死信写库失败，丢弃 %d 条: %v", len(batch), err)
+				for _, dl := range batch {
+					logger.Error("丢弃的死信 接收时间=%s 原因=%s 内容=%.200s", dl.ReceivedAt.Format(time.RFC3339Nano), dl.Reason, dl.RawBody)
+				}
+			}
+			batch = batch[:0]
+		}
+
+		for {
+			select {
+			case msg, ok := <-deadChan:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, newDeadLetter(msg))
+				if len(batch) >= deadLetterBatchSize {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+}
+
+// newDeadLetter 把死信消息转换为表记录
+func newDeadLetter(msg *DeadMessage) model.DeadLetter {
+	received := msg.ReceivedAt
+	if received.IsZero() {
+		received = time.Now()
+	}
+	return model.DeadLetter{
+		ReceivedAt: received,
+		Reason:     truncateRunes(msg.Reason, deadLetterReasonMax),
+		RawBody:    msg.Body,
+		Size:       len(msg.Body),
+		Status:     model.DeadLetterPending,
+		CreateTime: time.Now(),
+	}
+}
+
+// List 分页查询死信，不返回原始消息内容，返回当前页和总数
+func (s *DeadLetterService) List(q DeadLetterQuery) ([]model.DeadLetter, int64, error) {
+	tx, err := s.filter(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("统计死信失败: %w", err)
+	}
+	// Count 会改写查询语句，分页查询重新构建条件
+	tx, _ = s.filter(q)
+
+	page, size := q.Page, q.PageSize
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 100
+	}
+	if size > deadLetterPageMax {
+		size = deadLetterPageMax
+	}
+	var list []model.DeadLetter
+	err = tx.Omit("raw_body").Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&list).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询死信失败: %w", err)
+	}
+	return list, total, nil
+}
+
+// Get 查看单条死信，包含原始消息内容
+func (s *DeadLetterService) Get(id int64) (*model.DeadLetter, error) {
+	var dl model.DeadLetter
+	if err := s.db.Take(&dl, id).Error; err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+// Reprocess 重新解析符合条件的死信并写入行情表
+// 已重新处理成功的死信不会重复写入；解析仍失败的标记为 FAILED，可在修复后再次处理
+func (s *DeadLetterService) Reprocess(q DeadLetterQuery) (ReprocessResult, error) {
+	var result ReprocessResult
+	if q.Status == model.DeadLetterReprocessed {
+		return result, nil
+	}
+
+	tables := NewCreateTableService(s.db)
+	ensured := make(map[string]bool)
+	var lastID int64
+	for {
+		tx, err := s.filter(q)
+		if err != nil {
+			return result, err
+		}
+		var letters []model.DeadLetter
+		err = tx.Where("status <> ? AND id > ?", model.DeadLetterReprocessed, lastID).
+			Order("id").Limit(deadLetterPageMax).Find(&letters).Error
+		if err != nil {
+			return result, fmt.Errorf("查询死信失败: %w", err)
+		}
+		if len(letters) == 0 {
+			return result, nil
+		}
+		lastID = letters[len(letters)-1].ID
+		result.Total += len(letters)
+
+		var parsed []*ParsedQuote
+		var parsedIDs []int64
+		for _, dl := range letters {
+			pq, err := ParseBondQuote(dl.RawBody)
+			if err != nil {
+				result.Failed++
+				s.markFailed([]int64{dl.ID}, err)
+				continue
+			}
+			day := dl.ReceivedAt.Format(deadLetterDateLayout)
+			if !ensured[day] {
+				if err := tables.EnsureDailyTablesExist(dl.ReceivedAt); err != nil {
+					return result, err
+				}
+				ensured[day] = true
+			}
+			pq.ReceivedAt = dl.ReceivedAt
+			parsed = append(parsed, pq)
+			parsedIDs = append(parsedIDs, dl.ID)
+		}
+		if len(parsed) == 0 {
+			continue
+		}
+
+		if err := InsertBatch(s.db, parsed); err != nil {
+			result.Failed += len(parsedIDs)
+			s.markFailed(parsedIDs, err)
+			return result, fmt.Errorf("死信写库失败: %w", err)
+		}
+		now := time.Now()
+		err = s.db.Model(&model.DeadLetter{}).Where("id IN ?", parsedIDs).Updates(map[string]any{
+			"status":         model.DeadLetterReprocessed,
+			"attempts":       gorm.Expr("attempts + 1"),
+			"last_error":     "",
+			"reprocessed_at": now,
+		}).Error
+		if err != nil {
+			return result, fmt.Errorf("更新死信状态失败: %w", err)
+		}
+		result.Reprocessed += len(parsedIDs)
+	}
+}
+
+// markFailed 记录重新处理失败
+func (s *DeadLetterService) markFailed(ids []int64, cause error) {
+	err := s.db.Model(&model.DeadLetter{}).Where("id IN ?", ids).Updates(map[string]any{
+		"status":     model.DeadLetterFailed,
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": truncateRunes(cause.Error(), deadLetterReasonMax),
+	}).Error
+	if err != nil {
+		logger.Error("更新死信状态失败: %v", err)
+	}
+}
+
+// Purge 删除符合条件的死信，返回删除条数；必须至少指定一个条件
+func (s *DeadLetterService) Purge(q DeadLetterQuery) (int64, error) {
+	if len(q.IDs) == 0 && q.Date == "" && q.Before == "" && q.Status == "" {
+		return 0, ErrPurgeWithoutFilter
+	}
+	tx, err := s.filter(q)
+	if err != nil {
+		return 0, err
+	}
+	res := tx.Delete(&model.DeadLetter{})
+	return res.RowsAffected, res.Error
+}
+
+// filter 按查询条件构建查询
+func (s *DeadLetterService) filter(q DeadLetterQuery) (*gorm.DB, error) {
+	tx := s.db.Model(&model.DeadLetter{})
+	if len(q.IDs) > 0 {
+		tx = tx.Where("id IN ?", q.IDs)
+	}
+	if q.Date != "" {
+		day, err := time.ParseInLocation(deadLetterDateLayout, q.Date, BusinessLocation())
+		if err != nil {
+			return nil, fmt.Errorf("日期格式错误: %w", err)
+		}
+		start, end := BusinessDayRange(day)
+		tx = tx.Where("received_at >= ? AND received_at < ?", start, end)
+	}
+	if q.Before != "" {
+		day, err := time.ParseInLocation(deadLetterDateLayout, q.Before, BusinessLocation())
+		if err != nil {
+			return nil, fmt.Errorf("日期格式错误: %w", err)
+		}
+		start, _ := BusinessDayRange(day)
+		tx = tx.Where("received_at < ?", start)
+	}
+	if q.Status != "" {
+		tx = tx.Where("status = ?", q.Status)
+	}
+	return tx, nil
+}
+
+// truncateRunes 按字符截断字符串
+func truncateRunes(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/model"
+)
+
+func TestDeadLetterDrainAndReprocess(t *testing.T) {
+	conn := newTestDB(t)
+	svc := NewDeadLetterService(conn)
+	if err := svc.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	// 昨天因解析器缺陷进入死信的正常消息，以及一条真正的坏消息
+	good := atsmock.OrderBookMessage("DL1", "HK0000000001", yesterday, level, nil)
+
+	var wg sync.WaitGroup
+	deadChan := make(chan *DeadMessage, 10)
+	svc.Start(deadChan, &wg)
+	deadChan <- &DeadMessage{Body: good, ReceivedAt: yesterday, Reason: "unmarshal QuotePriceData: 旧版本解析错误"}
+	deadChan <- &DeadMessage{Body: []byte("not a json"), ReceivedAt: yesterday, Reason: "unmarshal BondQuoteMessage"}
+	deadChan <- &DeadMessage{Body: []byte("{}"), ReceivedAt: now, Reason: "securityId is empty"}
+	close(deadChan)
+	wg.Wait()
+
+	list, total, err := svc.List(DeadLetterQuery{Date: yesterday.Format("20060102")})
+	if err != nil || total != 2 || len(list) != 2 {
+		t.Fatalf("昨天的死信 = %d/%d, err = %v", len(list), total, err)
+	}
+	if list[0].RawBody != nil || list[0].Size == 0 || list[0].Status != model.DeadLetterPending {
+		t.Fatalf("列表不应返回原始消息: %+v", list[0])
+	}
+	dl, err := svc.Get(list[1].ID)
+	if err != nil || string(dl.RawBody) != string(good) || dl.Reason == "" {
+		t.Fatalf("死信详情 = %+v, err = %v", dl, err)
+	}
+
+	// 修复解析器后重新处理昨天的死信
+	res, err := svc.Reprocess(DeadLetterQuery{Date: yesterday.Format("20060102")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (ReprocessResult{Total: 2, Reprocessed: 1, Failed: 1}) {
+		t.Fatalf("重新处理结果 = %+v", res)
+	}
+	var rows int64
+	conn.Table(GetDetailTableName(yesterday)).Where("message_id = ?", "DL1").Count(&rows)
+	if rows != 1 {
+		t.Fatalf("昨天明细表行数 = %d", rows)
+	}
+	dl, _ = svc.Get(dl.ID)
+	if dl.Status != model.DeadLetterReprocessed || dl.Attempts != 1 || dl.ReprocessedAt == nil {
+		t.Fatalf("重新处理后状态 = %+v", dl)
+	}
+	failed, _, _ := svc.List(DeadLetterQuery{Status: model.DeadLetterFailed})
+	if len(failed) != 1 || failed[0].Attempts != 1 || failed[0].LastError == "" {
+		t.Fatalf("失败的死信 = %+v", failed)
+	}
+
+	// 已处理成功的不会重复写入
+	if res, _ := svc.Reprocess(DeadLetterQuery{Date: yesterday.Format("20060102")}); res.Reprocessed != 0 || res.Failed != 1 {
+		t.Fatalf("再次处理结果 = %+v", res)
+	}
+	conn.Table(GetDetailTableName(yesterday)).Where("message_id = ?", "DL1").Count(&rows)
+	if rows != 1 {
+		t.Fatalf("重复处理后明细表行数 = %d", rows)
+	}
+
+	// 清理
+	if _, err := svc.Purge(DeadLetterQuery{}); !errors.Is(err, ErrPurgeWithoutFilter) {
+		t.Fatalf("无条件清理 err = %v", err)
+	}
+	n, err := svc.Purge(DeadLetterQuery{Before: now.Format("20060102")})
+	if err != nil || n != 2 {
+		t.Fatalf("清理 %d 条, err = %v", n, err)
+	}
+	if _, total, _ := svc.List(DeadLetterQuery{}); total != 1 {
+		t.Fatalf("清理后剩余 %d 条", total)
+	}
+}
+
+func TestDeadLetterBusinessDateFilter(t *testing.T) {
+	cal, err := calendar.New(&config.CalendarConfig{Timezone: "Asia/Hong_Kong", CutoffTime: "17:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBusinessCalendar(cal)
+	defer SetBusinessCalendar(nil)
+	loc := cal.Location()
+
+	conn := newTestDB(t)
+	svc := NewDeadLetterService(conn)
+	if err := svc.EnsureTable(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	deadChan := make(chan *DeadMessage, 10)
+	svc.Start(deadChan, &wg)
+	// 周一日切前、周一日切后（属于周二）、周二
+	for _, at := range []time.Time{
+		time.Date(2025, 9, 29, 16, 0, 0, 0, loc),
+		time.Date(2025, 9, 29, 18, 0, 0, 0, loc),
+		time.Date(2025, 9, 30, 10, 0, 0, 0, loc),
+	} {
+		deadChan <- &DeadMessage{Body: []byte("{}"), ReceivedAt: at, Reason: "securityId is empty"}
+	}
+	close(deadChan)
+	wg.Wait()
+
+	tests := []struct {
+		q    DeadLetterQuery
+		want int64
+	}{
+		{DeadLetterQuery{Date: "20250929"}, 1},
+		{DeadLetterQuery{Date: "20250930"}, 2},
+		{DeadLetterQuery{Before: "20250930"}, 1},
+	}
+	for _, tt := range tests {
+		if _, total, err := svc.List(tt.q); err != nil || total != tt.want {
+			t.Fatalf("%+v 死信 %d 条, 期望 %d, err = %v", tt.q, total, tt.want, err)
+		}
+	}
+}
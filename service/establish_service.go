@@ -16,18 +16,16 @@ package service
 // - 优雅的连接管理
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
-	utils "wealth-bond-quote-service/pkg/crypto_utils"
+	"wealth-bond-quote-service/pkg/capture"
 
 	logger "wealth-bond-quote-service/pkg/log"
 
@@ -45,13 +43,6 @@ type LoginRequest struct {
 	SmsCode  string `json:"code"`
 }
 
-// LoginResponse 登录响应结构体
-type LoginResponse struct {
-	Code  int    `json:"code"` // 响应状态码，200表示成功
-	Msg   string `json:"msg"`  // 响应消息，成功或错误描述
-	Token string `json:"data"` // 登录成功后返回的访问令牌，用于后续API调用
-}
-
 // EncryptedRequest 加密请求结构体
 type EncryptedRequest struct {
 	ReqMsg   string `json:"reqMsg"`   // AES加密后的请求内容（Base64编码）
@@ -72,109 +63,121 @@ type EncryptedResponse struct {
 	ResKey string `json:"resKey"` // RSA加密后的AES密钥（Base64编码），需要用公钥"解密"
 }
 
+// BondQuoteDestination 债券行情订阅主题
+const BondQuoteDestination = "/user/queue/v1/apiatsbondquote/messages"
+
 // StompClient STOMP客户端结构体
 // 封装WebSocket连接和STOMP协议连接，用于接收实时消息推送
 type StompClient struct {
 	Conn      *websocket.Conn // WebSocket底层连接
 	StompConn *stomp.Conn     // STOMP协议连接，基于WebSocket
 	Token     string          // 访问令牌，用于身份验证
+
+	Timeout   time.Duration // 登录、握手、STOMP连接超时时间，为0时使用默认值
+	Heartbeat time.Duration // STOMP心跳发送间隔，为0时使用默认值
+	AckMode   stomp.AckMode // 订阅确认模式，默认自动确认
+
+	Capture   *capture.Writer // 原始消息录制，为nil时不录制
+	Transport *AtsTransport   // 共用的HTTP客户端和WebSocket拨号器，为nil时使用系统根证书的默认配置
+	Watchdog  *FeedWatchdog   // 行情断流监控，为nil时不监控
 }
 
-// 登录获取Token
-func (c *StompClient) Login(username, password, smsCode, publicKey, baseURL, clientID string) error {
-	// 构建登录请求
-	loginReq := LoginRequest{
-		Username: username,
-		Password: password,
-		SmsCode:  smsCode,
+// ParseAckMode 解析配置中的确认模式
+// auto（默认）：收到即确认；client-individual：写库成功后逐条确认
+func ParseAckMode(mode string) (stomp.AckMode, error) {
+	switch mode {
+	case "", "auto":
+		return stomp.AckAuto, nil
+	case "client-individual":
+		return stomp.AckClientIndividual, nil
+	default:
+		return stomp.AckAuto, fmt.Errorf("不支持的确认模式: %s", mode)
 	}
+}
 
-	// 转换为JSON
-	jsonData, err := json.Marshal(loginReq) // 序列化
-	if err != nil {
-		return fmt.Errorf("JSON序列化失败: %v", err)
-	}
+// stompAck 基于STOMP连接的消息确认句柄
+type stompAck struct {
+	conn *stomp.Conn
+	msg  *stomp.Message
+}
 
-	// 加密请求
-	var encryptedReq EncryptedRequest
-	msg, key, err := utils.EncryptRequest(string(jsonData), publicKey, clientID)
-	if err != nil {
-		return fmt.Errorf("请求加密失败: %v", err)
-	}
-	encryptedReq.ReqMsg = msg
-	encryptedReq.ReqKey = key
-	encryptedReq.ClientId = clientID
+func (a *stompAck) Ack() error {
+	return a.conn.Ack(a.msg)
+}
 
-	// 发送HTTP请求
-	reqBody, err := json.Marshal(encryptedReq)
-	if err != nil {
-		return fmt.Errorf("加密请求序列化失败: %v", err)
-	}
+func (a *stompAck) Nack() error {
+	return a.conn.Nack(a.msg)
+}
 
-	// 构建登录API的完整URL
-	// 路径: /cust-gateway/cust-auth/account/outApi/doLogin
-	LOGIN_URL := fmt.Sprintf("%s%s", baseURL, "/cust-gateway/cust-auth/account/outApi/doLogin")
-	fmt.Printf("发送登录请求到: %s\n", LOGIN_URL)
-
-	// 创建HTTP客户端，配置超时和TLS设置
-	client := &http.Client{
-		Timeout: 30 * time.Second, // 请求超时时间30秒
-		Transport: &http.Transport{
-			// TLS配置：跳过证书验证（仅用于测试环境）
-			// 生产环境应该移除InsecureSkipVerify或设置为false
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
+const (
+	defaultClientTimeout  = 30 * time.Second  // 默认请求超时时间
+	defaultHeartbeat      = 30 * time.Second  // 默认心跳发送间隔
+	defaultHeartbeatRecv  = 120 * time.Second // 默认心跳接收超时
+	heartbeatRecvMultiple = 6                 // 接收超时 = 发送间隔 * 倍数
+)
 
-	resp, err := client.Post(LOGIN_URL, "application/json", bytes.NewBuffer(reqBody)) //
-	if err != nil {
-		return fmt.Errorf("HTTP请求失败: %v", err)
+// timeout 返回生效的超时时间
+func (c *StompClient) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
 	}
-	defer resp.Body.Close()
+	return defaultClientTimeout
+}
 
-	// 读取响应
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("读取响应失败: %v", err)
+// heartbeat 返回生效的心跳发送间隔和接收超时
+func (c *StompClient) heartbeat() (send, recv time.Duration) {
+	if c.Heartbeat > 0 {
+		return c.Heartbeat, c.Heartbeat * heartbeatRecvMultiple
 	}
+	return defaultHeartbeat, defaultHeartbeatRecv
+}
 
-	fmt.Printf("响应状态: %s\n", resp.Status)
-	fmt.Printf("响应内容: %s\n", string(respBody))
-
-	var encryptedResp EncryptedResponse
-	json.Unmarshal(respBody, &encryptedResp)
-
-	pubKeyBytes, err := base64.StdEncoding.DecodeString(publicKey)
-	if err != nil {
-		return fmt.Errorf("公钥Base64解码失败: %v", err)
-	}
-	aesKey, err := utils.RsaDecryptWithPub(pubKeyBytes, encryptedResp.ResKey)
-	if err != nil {
-		return fmt.Errorf("RSA解密AES密钥失败: %v", err)
+// httpClient 返回登录使用的HTTP客户端
+func (c *StompClient) httpClient() *http.Client {
+	if c.Transport != nil {
+		return c.Transport.HTTPClient
 	}
+	return &http.Client{Timeout: c.timeout()}
+}
 
-	aesKeyBase64 := string(aesKey)                                   // 转为字符串
-	realAESKey, err := base64.StdEncoding.DecodeString(aesKeyBase64) // Base64解码
-	if err != nil {
-		return fmt.Errorf("Base64解码AES密钥失败: %v", err)
+// dialer 返回WebSocket拨号器
+func (c *StompClient) dialer() *websocket.Dialer {
+	if c.Transport != nil {
+		return c.Transport.Dialer
 	}
-	decryptedResp, err := utils.AesDecryptECB(encryptedResp.ResMsg, realAESKey)
-	if err != nil {
-		return fmt.Errorf("AES解密响应失败: %v", err)
+	return &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		Subprotocols:     []string{"v12.stomp", "v11.stomp", "v10.stomp"},
+		HandshakeTimeout: c.timeout(),
 	}
-	fmt.Printf("响应状态:%s", decryptedResp)
+}
 
-	// 解析响应
-	var loginResp LoginResponse
-	if err := json.Unmarshal(decryptedResp, &loginResp); err != nil {
-		return fmt.Errorf("响应解析失败: %v", err)
+// 登录获取Token
+// 通过 AtsAPIClient 走加密接口，token 保存在 c.Token
+func (c *StompClient) Login(username, password, smsCode, publicKey, baseURL, clientID string) error {
+	api := &AtsAPIClient{
+		BaseURL:    baseURL,
+		ClientID:   clientID,
+		PublicKey:  publicKey,
+		HTTPClient: c.httpClient(),
 	}
+	fmt.Printf("发送登录请求到: %s\n", baseURL+LoginPath)
 
-	if loginResp.Code != 200 {
-		return fmt.Errorf("登录失败: %s", loginResp.Msg)
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+	defer cancel()
+	token, err := CallAts[LoginRequest, string](ctx, api, LoginPath, "", LoginRequest{
+		Username: username,
+		Password: password,
+		SmsCode:  smsCode,
+	})
+	if err != nil {
+		return fmt.Errorf("登录失败: %w", err)
+	}
+	if token == "" {
+		return errors.New("登录失败: 响应中没有token")
 	}
 
-	c.Token = loginResp.Token
+	c.Token = token
 	return nil
 }
 
@@ -193,16 +196,8 @@ func (c *StompClient) ConnectWebSocket(wssURL string) error {
 
 	fmt.Printf("连接地址: %s\n", u.String())
 
-	// 配置WebSocket拨号器
-	dialer := websocket.Dialer{
-		// TLS配置：跳过证书验证（测试环境）
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		// 支持的STOMP协议版本，按优先级排序
-		// v12.stomp: STOMP 1.2版本（最新）
-		// v11.stomp: STOMP 1.1版本
-		// v10.stomp: STOMP 1.0版本（向后兼容）
-		Subprotocols: []string{"v12.stomp", "v11.stomp", "v10.stomp"},
-	}
+	// 使用共用的WebSocket拨号器，TLS设置和支持的STOMP协议版本见 AtsTransport
+	dialer := c.dialer()
 
 	// 设置WebSocket连接的HTTP请求头
 	headers := http.Header{}
@@ -225,6 +220,10 @@ func (c *StompClient) ConnectWebSocket(wssURL string) error {
 				body, _ := io.ReadAll(resp.Body)
 				fmt.Printf("响应内容: %s\n", string(body))
 			}
+			// 网关拒绝握手，通常是token失效
+			if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+				return fmt.Errorf("WebSocket连接失败: %w: %v", ErrAuthRejected, err)
+			}
 		}
 		return fmt.Errorf("WebSocket连接失败: %v", err)
 	}
@@ -236,15 +235,19 @@ func (c *StompClient) ConnectWebSocket(wssURL string) error {
 
 // 建立STOMP连接
 func (c *StompClient) ConnectStomp() error {
+	sendHeartbeat, recvHeartbeat := c.heartbeat()
+
 	// 创建STOMP连接选项配置
 	options := []func(*stomp.Conn) error{
 		// 登录凭据（空用户名密码，使用token认证）
 		stomp.ConnOpt.Login("", ""),
 		// 虚拟主机名（STOMP协议要求）
 		stomp.ConnOpt.Host("localhost"),
-		// 心跳配置：发送心跳间隔30秒，接收心跳超时120秒
+		// 心跳配置：默认发送心跳间隔30秒，接收心跳超时120秒
 		// 用于保持连接活跃和检测连接状态
-		stomp.ConnOpt.HeartBeat(30*time.Second, 120*time.Second),
+		stomp.ConnOpt.HeartBeat(sendHeartbeat, recvHeartbeat),
+		// 断开连接时等待回执的超时时间
+		stomp.ConnOpt.DisconnectReceiptTimeout(c.timeout()),
 		// 自定义STOMP头部信息
 		stomp.ConnOpt.Header("token", c.Token),            // 访问令牌
 		stomp.ConnOpt.Header("imei", "test-device-001"),   // 设备IMEI标识
@@ -253,10 +256,12 @@ func (c *StompClient) ConnectStomp() error {
 		stomp.ConnOpt.Header("deviceInfo", "test-client"), // 设备信息描述
 	}
 
-	// 使用WebSocket连接创建STOMP连接
-	stompConn, err := stomp.Connect(NewWebSocketNetConn(c.Conn), options...)
+	// 使用WebSocket连接创建STOMP连接，等待CONNECTED帧不超过超时时间
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+	defer cancel()
+	stompConn, err := stomp.ConnectWithContext(ctx, NewWebSocketNetConn(c.Conn), options...)
 	if err != nil {
-		return fmt.Errorf("STOMP连接失败: %v", err)
+		return fmt.Errorf("STOMP连接失败: %w", classifyStompError(err))
 	}
 
 	fmt.Println("STOMP连接成功!")
@@ -265,7 +270,7 @@ func (c *StompClient) ConnectStomp() error {
 }
 
 // 订阅消息
-func (c *StompClient) Subscribe(ctx context.Context, rawChan chan []byte, errChan chan error /*, mwg *sync.WaitGroup*/) error {
+func (c *StompClient) Subscribe() (*stomp.Subscription, error) {
 	// 订阅目标地址：债券行情消息队列
 	// /user/queue/v1/apiatsbondquote/messages
 	// - /user: 用户专用队列前缀
@@ -273,67 +278,75 @@ func (c *StompClient) Subscribe(ctx context.Context, rawChan chan []byte, errCha
 	// - v1: API版本
 	// - apiatsbondquote: 债券行情API标识
 	// - messages: 消息主题
-	destination := "/user/queue/v1/apiatsbondquote/messages"
+	destination := BondQuoteDestination
 
 	fmt.Printf("订阅主题: %s\n", destination)
 
-	// 订阅消息，使用自动确认模式
+	// 订阅消息，默认使用自动确认模式
 	// stomp.AckAuto: 消息接收后自动确认，无需手动ACK
+	// stomp.AckClientIndividual: 写库成功后逐条ACK，实现至少一次投递
 	subcribeId := uuid.New().String()
 	// 订阅消息，并添加自定义消息头
+	// 注意：不要带receipt头。go-stomp会把订阅通道按receipt和id登记两次，
+	// 收到ERROR帧时对同一通道重复关闭导致panic；网关也不会回复该回执
 	sub, err := c.StompConn.Subscribe(
 		destination,
-		stomp.AckAuto,
-		stomp.SubscribeOpt.Header("uuid", subcribeId),               // 客户端标识符
-		stomp.SubscribeOpt.Header("id", subcribeId),                 // 客户端标识符
-		stomp.SubscribeOpt.Header("receipt", "receipt-"+subcribeId), // 请求回执
+		c.AckMode,
+		stomp.SubscribeOpt.Header("uuid", subcribeId), // 客户端标识符
+		stomp.SubscribeOpt.Header("id", subcribeId),   // 客户端标识符
 	)
 
 	if err != nil {
-		return fmt.Errorf("订阅失败: %v", err)
+		return nil, fmt.Errorf("订阅失败: %v", err)
 	}
 
 	fmt.Println("订阅成功，开始监听消息...")
+	return sub, nil
+}
 
-	// mwg.Add(1)
-	// // 启动消息监听协程
-	// go func() {
-	// 	defer mwg.Done()
+// Listen 持续监听订阅消息，把消息体写入rawChan
+// 上下文取消时返回nil，连接或订阅出错时返回对应错误
+func (c *StompClient) Listen(ctx context.Context, sub *stomp.Subscription, rawChan chan *RawMessage) error {
 	for {
 		select {
 		case <-ctx.Done():
 			// 上下文取消，退出监听
 			fmt.Println("上下文取消，停止消息监听")
 			return nil
-		case msg := <-sub.C:
+		case msg, ok := <-sub.C:
+			if !ok {
+				return errors.New("订阅通道已关闭")
+			}
 			if msg.Err != nil {
 				logger.Error("消息错误: %v", msg.Err)
-				errChan <- msg.Err
-				return nil
+				return classifyStompError(msg.Err)
+			}
+
+			if len(msg.Body) == 0 {
+				continue
+			}
+			raw := &RawMessage{Body: msg.Body, ReceivedAt: time.Now()}
+			if c.Watchdog != nil {
+				c.Watchdog.FeedMessage(sub.Destination(), raw.ReceivedAt)
+			}
+			logger.Debug("收到消息 message-id=%s, 长度=%d", msg.Header.Get("message-id"), len(msg.Body))
+
+			// 先录制再投递，录制失败不影响行情处理
+			if c.Capture != nil {
+				if err := c.Capture.Write(raw.ReceivedAt, raw.Body); err != nil {
+					logger.Warn("录制原始消息失败: %v", err)
+				}
 			}
 
 			// 将rawjson发送到RawChan通道
-			if len(msg.Body) != 0 {
-				rawChan <- msg.Body
+			if sub.AckMode() != stomp.AckAuto {
+				raw.Ack = &stompAck{conn: c.StompConn, msg: msg}
 			}
-			// 打印收到的新消息
-			fmt.Println("\n========== 收到新消息 ==========")
-			fmt.Printf("时间: %s\n", time.Now().Format("2006-01-02 15:04:05"))
-			fmt.Printf("目的地: %s\n", msg.Destination)
-			fmt.Printf("内容类型: %s\n", msg.ContentType)
-			fmt.Printf("消息ID: %s\n", msg.Header.Get("message-id"))
-			fmt.Printf("订阅ID: %s\n", msg.Header.Get("subscription"))
-			fmt.Println("消息内容:")
-
-			// 尝试格式化JSON输出
-			var jsonData any
-			if err := json.Unmarshal(msg.Body, &jsonData); err == nil {
-				formattedJSON, _ := json.MarshalIndent(jsonData, "", "  ")
-				fmt.Println(string(formattedJSON))
-			} else {
-				fmt.Println(string(msg.Body))
+			select {
+			case rawChan <- raw:
+			case <-ctx.Done():
+				return nil
 			}
-			fmt.Print("==================================\n")
 		}
 	}
 }
@@ -341,8 +354,14 @@ func (c *StompClient) Subscribe(ctx context.Context, rawChan chan []byte, errCha
 // WebSocketNetConn WebSocket网络连接适配器
 // 将WebSocket连接包装成net.Conn接口，供STOMP库使用
 // STOMP库需要net.Conn接口，而WebSocket连接需要适配
+//
+// WebSocket按消息收发，net.Conn是字节流：
+// - 一条消息可能大于调用方的缓冲区，剩余字节留到下次Read返回
+// - 一个STOMP帧可能被拆成多条消息，也可能一条消息包含多个帧，由STOMP库按流解析
 type WebSocketNetConn struct {
-	conn *websocket.Conn // 底层WebSocket连接
+	conn        *websocket.Conn // 底层WebSocket连接
+	pending     []byte          // 上一条消息尚未读完的字节
+	messageType atomic.Int32    // 写出时使用的消息类型，跟随对端最近一条数据消息
 }
 
 // NewWebSocketNetConn 创建WebSocket网络连接适配器
@@ -351,36 +370,43 @@ type WebSocketNetConn struct {
 //
 // 返回：适配器实例
 func NewWebSocketNetConn(conn *websocket.Conn) *WebSocketNetConn {
-	return &WebSocketNetConn{conn: conn}
+	w := &WebSocketNetConn{conn: conn}
+	w.messageType.Store(websocket.TextMessage)
+	return w
 }
 
 // Read 实现net.Conn接口的Read方法
-// 从WebSocket连接读取数据
+// 从WebSocket连接读取数据，先返回上一条消息剩余的字节
+// 对端正常关闭时返回io.EOF，其他读错误原样返回
 func (w *WebSocketNetConn) Read(p []byte) (n int, err error) {
-	// 读取WebSocket消息（忽略消息类型）
-	_, message, err := w.conn.ReadMessage()
-	if err == nil && len(message) > 0 {
-		fmt.Printf("[%s] ", time.Now().Format("15:04:05.000"))
-		fmt.Println("收到STOMP帧:")
-		fmt.Println(string(message))
-		fmt.Println("----------------------------")
+	if len(p) == 0 {
+		return 0, nil
 	}
-	// 将消息内容复制到缓冲区
-	copy(p, message)
-	return len(message), nil
+
+	// 跳过空消息，直到读到数据或出错
+	for len(w.pending) == 0 {
+		messageType, message, err := w.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		// 文本帧和二进制帧都承载STOMP字节流
+		w.messageType.Store(int32(messageType))
+		w.pending = message
+	}
+
+	// 将消息内容复制到缓冲区，剩余部分留待下次读取
+	n = copy(p, w.pending)
+	w.pending = w.pending[n:]
+	return n, nil
 }
 
 // Write 实现net.Conn接口的Write方法
-// 向WebSocket连接写入数据
+// 向WebSocket连接写入数据，消息类型与对端保持一致（默认文本）
 func (w *WebSocketNetConn) Write(p []byte) (n int, err error) {
-	if len(p) > 0 {
-		fmt.Printf("[%s] ", time.Now().Format("15:04:05.000"))
-		fmt.Println("发送STOMP帧:")
-		fmt.Println(string(p))
-		fmt.Println("----------------------------")
-	}
-	// 发送文本消息到WebSocket
-	err = w.conn.WriteMessage(websocket.TextMessage, p)
+	err = w.conn.WriteMessage(int(w.messageType.Load()), p)
 	if err != nil {
 		return 0, err
 	}
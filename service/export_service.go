@@ -2,7 +2,6 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -22,7 +21,8 @@ import (
 
 // ExportLatestQuotesService 债券最新行情导出服务
 type ExportLatestQuotesService struct {
-	db *gorm.DB
+	db    *gorm.DB
+	books *OrderBookStore // 内存订单簿，为空时从最新行情表读取
 }
 
 // NewExportLatestQuotesService 创建债券最新行情导出服务
@@ -71,13 +71,28 @@ func (s *ExportLatestQuotesService) StartHourlyExport(exportDir string, interval
 	}(intervalDuration)
 }
 
+// SetOrderBooks 设置内存订单簿，设置后导出直接读取订单簿
+func (s *ExportLatestQuotesService) SetOrderBooks(books *OrderBookStore) {
+	s.books = books
+}
+
+// latestBooks 当前最新订单簿：内存订单簿有数据时直接读取，否则从当天最新行情表解析
+func (s *ExportLatestQuotesService) latestBooks() ([]*OrderBook, error) {
+	if s.books != nil && s.books.Len() > 0 {
+		return s.books.Books(), nil
+	}
+	var latestQuotes []model.BondLatestQuote
+	if err := s.db.Table(GetTodayLatestTableName()).Find(&latestQuotes).Error; err != nil {
+		return nil, fmt.Errorf("查询最新行情数据失败: %w", err)
+	}
+	return latestQuotesToBooks(latestQuotes), nil
+}
+
 // ExportToExcel 导出最新行情到Excel文件
 func (s *ExportLatestQuotesService) ExportToExcel(filename string) error {
-	tableName := GetTodayLatestTableName()
-	// 查询所有最新行情数据
-	var latestQuotes []model.BondLatestQuote
-	if err := s.db.Table(tableName).Find(&latestQuotes).Error; err != nil {
-		return fmt.Errorf("查询最新行情数据失败: %w", err)
+	books, err := s.latestBooks()
+	if err != nil {
+		return err
 	}
 
 	// 创建新的Excel文件
@@ -98,7 +113,7 @@ func (s *ExportLatestQuotesService) ExportToExcel(filename string) error {
 		"买方价格", "买方收益率", "买方数量", "买方报价时间",
 		"卖方价格", "卖方收益率", "卖方数量", "卖方报价时间",
 		"消息ID", "消息类型", "发送时间", "时间戳", "更新时间",
-		"买方券商ID", "卖方券商ID",
+		"买方券商ID", "卖方券商ID", "行情状态",
 	}
 
 	for i, header := range headers {
@@ -109,32 +124,10 @@ func (s *ExportLatestQuotesService) ExportToExcel(filename string) error {
 	// 填充数据
 	rowIndex := 2 // 从第2行开始（第1行是表头）
 
-	for _, quote := range latestQuotes {
-		// 解析JSON数据
-		var msg BondQuoteMessage
-		if err := json.Unmarshal([]byte(quote.RawJSON), &msg); err != nil {
-			logger.Error("解析JSON失败 (ISIN=%s): %v", quote.ISIN, err)
-			continue
-		}
-
-		// 解析内部报价数据
-		var payload QuotePriceData
-		if err := json.Unmarshal([]byte(msg.Data.QuotePriceData), &payload); err != nil {
-			logger.Error("解析报价数据失败 (ISIN=%s): %v", quote.ISIN, err)
-			continue
-		}
-
-		// 获取买方和卖方报价
-		var bidPrices []QuotePrice
-		var askPrices []QuotePrice
-
-		if len(payload.BidPrices) > 0 {
-			bidPrices = payload.BidPrices
-		}
-
-		if len(payload.AskPrices) > 0 {
-			askPrices = payload.AskPrices
-		}
+	for _, quote := range books {
+		// 买方和卖方报价，按价格排序
+		bidPrices := quote.Bids
+		askPrices := quote.Asks
 
 		// 确定需要多少行
 		maxRows := len(bidPrices)
@@ -150,7 +143,8 @@ func (s *ExportLatestQuotesService) ExportToExcel(filename string) error {
 			f.SetCellValue(sheetName, fmt.Sprintf("K%d", rowIndex), quote.MessageType)
 			f.SetCellValue(sheetName, fmt.Sprintf("L%d", rowIndex), time.UnixMilli(quote.SendTime).Format("2006-01-02 15:04:05.000"))
 			f.SetCellValue(sheetName, fmt.Sprintf("M%d", rowIndex), time.UnixMilli(quote.Timestamp).Format("2006-01-02 15:04:05.000"))
-			f.SetCellValue(sheetName, fmt.Sprintf("N%d", rowIndex), quote.LastUpdateTime.Format("2006-01-02 15:04:05.000"))
+			f.SetCellValue(sheetName, fmt.Sprintf("N%d", rowIndex), quote.UpdateTime.Format("2006-01-02 15:04:05.000"))
+			f.SetCellValue(sheetName, fmt.Sprintf("Q%d", rowIndex), quoteStatusText(quote.Status))
 			rowIndex++
 			continue
 		}
@@ -185,7 +179,8 @@ func (s *ExportLatestQuotesService) ExportToExcel(filename string) error {
 			f.SetCellValue(sheetName, fmt.Sprintf("K%d", rowIndex), quote.MessageType)
 			f.SetCellValue(sheetName, fmt.Sprintf("L%d", rowIndex), time.UnixMilli(quote.SendTime).Format("2006-01-02 15:04:05.000"))
 			f.SetCellValue(sheetName, fmt.Sprintf("M%d", rowIndex), time.UnixMilli(quote.Timestamp).Format("2006-01-02 15:04:05.000"))
-			f.SetCellValue(sheetName, fmt.Sprintf("N%d", rowIndex), quote.LastUpdateTime.Format("2006-01-02 15:04:05.000"))
+			f.SetCellValue(sheetName, fmt.Sprintf("N%d", rowIndex), quote.UpdateTime.Format("2006-01-02 15:04:05.000"))
+			f.SetCellValue(sheetName, fmt.Sprintf("Q%d", rowIndex), quoteStatusText(quote.Status))
 
 			rowIndex++
 		}
@@ -239,3 +234,13 @@ func (s *ExportLatestQuotesService) ExportToExcel(filename string) error {
 	logger.Info("成功删除本地文件: %s", filename)
 	return nil
 }
+
+// quoteStatusText 最新行情状态的中文说明
+func quoteStatusText(status string) string {
+	switch status {
+	case model.QuoteStatusUnconfirmed:
+		return "未确认(断线前数据)"
+	default:
+		return "已确认"
+	}
+}
@@ -0,0 +1,357 @@
+package service
+
+// 内存订单簿
+// 每条ATS行情携带一个ISIN完整的买卖报价，订单簿按ISIN只保留最新一条：
+// - 买方按价格从高到低、卖方从低到高排序，价格相同时先报的在前
+// - 最优买卖价取排序后第一个有效档位（isValid 不为 N），同时给出中间价、价差和深度（有效档位数量合计）
+// - 作为非必需的写入目标接入写库层，只接受比已保存的更新的行情（与最新行情表相同的比较规则）
+// - 保存后的订单簿不再修改，查询和导出直接读取，不需要从 RawJSON 重新解析
+// 快照定期保存到本地文件，重启后先恢复当前业务日期的订单簿再接收推送，恢复的订单簿在推送确认前标记为未确认
+// 内存订单簿只保存一个业务日期：收到下一业务日期的行情或读取时已过日切，清空上一业务日期的订单簿，
+// 与最新行情表按业务日期分表一致；迟到的上一业务日期行情不再进入内存订单簿
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"wealth-bond-quote-service/model"
+	logger "wealth-bond-quote-service/pkg/log"
+)
+
+// OrderBook 单个ISIN的订单簿
+type OrderBook struct {
+	ISIN        string       `json:"isin"`
+	MessageID   string       `json:"messageId"`
+	MessageType string       `json:"messageType"`
+	SendTime    int64        `json:"sendTime"`
+	Timestamp   int64        `json:"timestamp"`
+	UpdateTime  time.Time    `json:"updateTime"`
+	Status      string       `json:"status"`
+	Bids        []QuotePrice `json:"bids"` // 价格从高到低
+	Asks        []QuotePrice `json:"asks"` // 价格从低到高
+}
+
+// BookSummary 订单簿摘要
+type BookSummary struct {
+	ISIN       string      `json:"isin"`
+	BestBid    *QuotePrice `json:"bestBid,omitempty"`
+	BestAsk    *QuotePrice `json:"bestAsk,omitempty"`
+	Mid        *float64    `json:"mid,omitempty"`    // 最优买卖价均值，双边都有报价时才有
+	Spread     *float64    `json:"spread,omitempty"` // 最优卖价 - 最优买价
+	BidDepth   float64     `json:"bidDepth"`         // 有效买方档位数量合计
+	AskDepth   float64     `json:"askDepth"`
+	BidLevels  int         `json:"bidLevels"`
+	AskLevels  int         `json:"askLevels"`
+	MessageID  string      `json:"messageId"`
+	SendTime   int64       `json:"sendTime"`
+	UpdateTime time.Time   `json:"updateTime"`
+	Status     string      `json:"status"`
+}
+
+// newOrderBook 由一条行情生成订单簿，档位复制后排序
+func newOrderBook(meta *BondQuoteMessage, payload *QuotePriceData, updated time.Time, status string) *OrderBook {
+	b := &OrderBook{
+		ISIN:        payload.SecurityID,
+		MessageID:   meta.Data.MessageID,
+		MessageType: meta.Data.MessageType,
+		SendTime:    meta.SendTime,
+		Timestamp:   meta.Data.Timestamp,
+		UpdateTime:  updated,
+		Status:      status,
+		Bids:        slices.Clone(payload.BidPrices),
+		Asks:        slices.Clone(payload.AskPrices),
+	}
+	sortLevels(b.Bids, true)
+	sortLevels(b.Asks, false)
+	return b
+}
+
+// orderBookFromLatest 从最新行情表的记录生成订单簿，用于未启用内存订单簿时的查询和导出
+func orderBookFromLatest(q *model.BondLatestQuote) (*OrderBook, error) {
+	pq, err := ParseBondQuote([]byte(q.RawJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer pq.Release()
+	b := newOrderBook(&pq.Meta, &pq.Payload, q.LastUpdateTime, q.Status)
+	b.ISIN = q.ISIN
+	return b, nil
+}
+
+// latestQuotesToBooks 把最新行情表的记录转换为订单簿，无法解析的记录记录日志后跳过
+func latestQuotesToBooks(quotes []model.BondLatestQuote) []*OrderBook {
+	books := make([]*OrderBook, 0, len(quotes))
+	for i := range quotes {
+		b, err := orderBookFromLatest(&quotes[i])
+		if err != nil {
+			logger.Error("解析最新行情失败 (ISIN=%s): %v", quotes[i].ISIN, err)
+			continue
+		}
+		books = append(books, b)
+	}
+	return books
+}
+
+// sortLevels 按价格排序，价格相同时按报价时间
+func sortLevels(levels []QuotePrice, desc bool) {
+	slices.SortStableFunc(levels, func(a, b QuotePrice) int {
+		c := cmp.Compare(a.Price, b.Price)
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			c = cmp.Compare(a.QuoteTime, b.QuoteTime)
+		}
+		return c
+	})
+}
+
+// bestLevel 返回第一个有效档位和有效档位数量合计
+func bestLevel(levels []QuotePrice) (*QuotePrice, float64) {
+	var best *QuotePrice
+	var depth float64
+	for i := range levels {
+		if levels[i].IsValid == "N" {
+			continue
+		}
+		if best == nil {
+			q := levels[i]
+			best = &q
+		}
+		depth += levels[i].OrderQty
+	}
+	return best, depth
+}
+
+// Summary 计算最优买卖价、中间价、价差和深度
+func (b *OrderBook) Summary() BookSummary {
+	s := BookSummary{
+		ISIN:       b.ISIN,
+		BidLevels:  len(b.Bids),
+		AskLevels:  len(b.Asks),
+		MessageID:  b.MessageID,
+		SendTime:   b.SendTime,
+		UpdateTime: b.UpdateTime,
+		Status:     b.Status,
+	}
+	s.BestBid, s.BidDepth = bestLevel(b.Bids)
+	s.BestAsk, s.AskDepth = bestLevel(b.Asks)
+	if s.BestBid != nil && s.BestAsk != nil {
+		mid := (s.BestBid.Price + s.BestAsk.Price) / 2
+		spread := s.BestAsk.Price - s.BestBid.Price
+		s.Mid, s.Spread = &mid, &spread
+	}
+	return s
+}
+
+// OrderBookStore 按ISIN保存当前业务日期的最新订单簿，实现 QuoteSink 接入写库层
+type OrderBookStore struct {
+	name string
+	now  func() time.Time // 当前时间，测试时替换
+
+	mu    sync.RWMutex
+	day   time.Time // 订单簿所属的业务日期
+	books map[string]*OrderBook
+}
+
+// NewOrderBookStore 创建内存订单簿
+func NewOrderBookStore(name string) *OrderBookStore {
+	return &OrderBookStore{name: name, now: time.Now, books: make(map[string]*OrderBook)}
+}
+
+func (s *OrderBookStore) Name() string { return s.name }
+
+func (s *OrderBookStore) Write(batch []*ParsedQuote) error {
+	for _, pq := range batch {
+		updated := pq.ReceivedAt
+		if updated.IsZero() {
+			updated = time.Now()
+		}
+		s.apply(newOrderBook(&pq.Meta, &pq.Payload, updated, model.QuoteStatusConfirmed))
+	}
+	return nil
+}
+
+func (s *OrderBookStore) Close() error { return nil }
+
+// apply 替换为更新的订单簿，返回是否已替换
+func (s *OrderBookStore) apply(b *OrderBook) bool {
+	day := BusinessDate(messageTime(b.Timestamp, b.SendTime, b.UpdateTime))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.rotateLocked(day) {
+		return false
+	}
+	if old, ok := s.books[b.ISIN]; ok && !newerQuote(b.SendTime, b.Timestamp, old.SendTime, old.Timestamp) {
+		return false
+	}
+	s.books[b.ISIN] = b
+	return true
+}
+
+// rotateLocked 切换到业务日期 day，清空之前业务日期的订单簿；day 早于当前业务日期时返回 false，调用方需持有写锁
+func (s *OrderBookStore) rotateLocked(day time.Time) bool {
+	if day.Before(s.day) {
+		return false
+	}
+	if day.After(s.day) {
+		if len(s.books) > 0 {
+			logger.Info("内存订单簿切换到业务日期 %s，清空 %d 个订单簿", day.Format(time.DateOnly), len(s.books))
+			s.books = make(map[string]*OrderBook)
+		}
+		s.day = day
+	}
+	return true
+}
+
+// expire 读取前检查日切，已进入下一业务日期时清空订单簿，查询和导出转为读取当天的最新行情表
+func (s *OrderBookStore) expire() {
+	today := BusinessDate(s.now())
+	s.mu.RLock()
+	stale := today.After(s.day) && len(s.books) > 0
+	s.mu.RUnlock()
+	if !stale {
+		return
+	}
+	s.mu.Lock()
+	s.rotateLocked(today)
+	s.mu.Unlock()
+}
+
+// Book 返回指定ISIN的订单簿，返回值不能修改
+func (s *OrderBookStore) Book(isin string) (*OrderBook, bool) {
+	s.expire()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.books[isin]
+	return b, ok
+}
+
+// Books 返回全部订单簿，按ISIN排序，返回值不能修改
+func (s *OrderBookStore) Books() []*OrderBook {
+	s.expire()
+	s.mu.RLock()
+	books := make([]*OrderBook, 0, len(s.books))
+	for _, b := range s.books {
+		books = append(books, b)
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(books, func(a, b *OrderBook) int { return cmp.Compare(a.ISIN, b.ISIN) })
+	return books
+}
+
+// Len 返回订单簿数量
+func (s *OrderBookStore) Len() int {
+	s.expire()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.books)
+}
+
+// MarkUnconfirmed 把全部订单簿标记为未确认（断线重连后），返回标记的数量
+func (s *OrderBookStore) MarkUnconfirmed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for isin, b := range s.books {
+		if b.Status == model.QuoteStatusUnconfirmed {
+			continue
+		}
+		c := *b
+		c.Status = model.QuoteStatusUnconfirmed
+		s.books[isin] = &c
+		n++
+	}
+	return n
+}
+
+// orderBookSnapshot 订单簿快照文件内容
+type orderBookSnapshot struct {
+	SavedAt time.Time    `json:"savedAt"`
+	Books   []*OrderBook `json:"books"`
+}
+
+// SaveSnapshot 保存快照，先写临时文件再改名，保证快照文件完整
+func (s *OrderBookStore) SaveSnapshot(path string) error {
+	data, err := json.Marshal(orderBookSnapshot{SavedAt: time.Now(), Books: s.Books()})
+	if err != nil {
+		return fmt.Errorf("保存订单簿快照失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("保存订单簿快照失败: %w", err)
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("保存订单簿快照失败: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("保存订单簿快照失败: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("保存订单簿快照失败: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("保存订单簿快照失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("保存订单簿快照失败: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot 从快照恢复与 at 属于同一业务日期的订单簿，标记为未确认，返回恢复的数量；快照不存在时不恢复
+// 已有更新的订单簿时保留已有的
+func (s *OrderBookStore) LoadSnapshot(path string, at time.Time) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("读取订单簿快照失败: %w", err)
+	}
+	var snap orderBookSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, fmt.Errorf("订单簿快照文件损坏: %w", err)
+	}
+	day := BusinessDate(at)
+	n := 0
+	for _, b := range snap.Books {
+		if b == nil || b.ISIN == "" || !BusinessDate(messageTime(b.Timestamp, b.SendTime, b.UpdateTime)).Equal(day) {
+			continue
+		}
+		b.Status = model.QuoteStatusUnconfirmed
+		if s.apply(b) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Run 每隔 interval 保存一次快照，ctx 结束时再保存一次后返回
+func (s *OrderBookStore) Run(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.SaveSnapshot(path); err != nil {
+				logger.Error("%v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.SaveSnapshot(path); err != nil {
+				logger.Error("%v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/model"
+)
+
+func TestOrderBookStore(t *testing.T) {
+	conn := newTestDB(t)
+	books := NewOrderBookStore("orderbook")
+	var wg sync.WaitGroup
+	parsedChan := make(chan *ParsedQuote, 10)
+	bqs := NewBondQuoteService(conn, &wg, nil, parsedChan, nil)
+	bqs.AddSink(NewDBSink("bond", conn), SinkOptions{Required: true})
+	bqs.AddSink(books, SinkOptions{WorkerNum: 1, Blocking: true})
+	bqs.StartDBWorkers(2, 10, 10*time.Millisecond)
+
+	base := time.Now().Truncate(time.Second)
+	push := func(id, isin string, sent time.Time, bids, asks []atsmock.Level, invalid string) {
+		pq, err := ParseBondQuote(invalidateLevel(t, atsmock.OrderBookMessage(id, isin, sent, bids, asks), invalid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pq.ReceivedAt = time.Now()
+		parsedChan <- pq
+	}
+	push("M1", "HK0000000001", base,
+		[]atsmock.Level{{QuoteOrderNo: "B1", Price: 99.5, OrderQty: 1e6}, {QuoteOrderNo: "B2", Price: 100.1, OrderQty: 2e6}, {QuoteOrderNo: "B3", Price: 99.8, OrderQty: 3e6}},
+		[]atsmock.Level{{QuoteOrderNo: "A1", Price: 100.6, OrderQty: 1e6}, {QuoteOrderNo: "A2", Price: 100.4, OrderQty: 5e6}}, "B2")
+	push("M2", "HK0000000002", base, []atsmock.Level{{QuoteOrderNo: "B1", Price: 98, OrderQty: 1e6}}, nil, "")
+	// 迟到的旧行情不覆盖
+	push("M0", "HK0000000001", base.Add(-time.Second), nil, nil, "")
+	close(parsedChan)
+	wg.Wait()
+
+	check := func(name string, b *OrderBook) {
+		t.Helper()
+		s := b.Summary()
+		if b.MessageID != "M1" || len(b.Bids) != 3 || b.Bids[0].QuoteOrderNo != "B2" || b.Bids[2].QuoteOrderNo != "B1" || b.Asks[0].QuoteOrderNo != "A2" {
+			t.Fatalf("%s 订单簿 = %+v", name, b)
+		}
+		// 无效档位不参与最优价和深度
+		if s.BestBid.QuoteOrderNo != "B3" || s.BestAsk.QuoteOrderNo != "A2" || *s.Mid != 100.1 || *s.Spread < 0.599 || *s.Spread > 0.601 ||
+			s.BidDepth != 4e6 || s.AskDepth != 6e6 || s.BidLevels != 3 || s.AskLevels != 2 {
+			t.Fatalf("%s 摘要 = %+v", name, s)
+		}
+	}
+	b, ok := books.Book("HK0000000001")
+	if !ok || books.Len() != 2 {
+		t.Fatalf("订单簿数量 %d", books.Len())
+	}
+	check("内存", b)
+	if s := mustBook(t, books, "HK0000000002").Summary(); s.BestAsk != nil || s.Mid != nil || s.Spread != nil {
+		t.Fatalf("单边订单簿摘要 = %+v", s)
+	}
+
+	// 未启用内存订单簿时查询从最新行情表解析，结果一致
+	query := NewBondQueryService(conn)
+	b, err := query.OrderBook("HK0000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("最新行情表", b)
+	query.SetOrderBooks(books)
+	summaries, err := query.OrderBookSummaries()
+	if err != nil || len(summaries) != 2 || summaries[0].ISIN != "HK0000000001" {
+		t.Fatalf("摘要 = %+v, err = %v", summaries, err)
+	}
+	if _, err := query.OrderBook("HK0000000003"); err != ErrOrderBookNotFound {
+		t.Fatalf("不存在的订单簿 err = %v", err)
+	}
+
+	// 快照恢复：只恢复当天的订单簿，推送确认前为未确认
+	path := filepath.Join(t.TempDir(), "orderbook", "snapshot.json")
+	if err := books.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewOrderBookStore("orderbook")
+	if n, err := restored.LoadSnapshot(path, time.Now().AddDate(0, 0, 1)); err != nil || n != 0 {
+		t.Fatalf("跨日恢复 %d 个, err = %v", n, err)
+	}
+	if n, err := restored.LoadSnapshot(path, time.Now()); err != nil || n != 2 {
+		t.Fatalf("恢复 %d 个, err = %v", n, err)
+	}
+	b = mustBook(t, restored, "HK0000000001")
+	check("快照", b)
+	if b.Status != model.QuoteStatusUnconfirmed {
+		t.Fatalf("恢复的订单簿状态 = %s", b.Status)
+	}
+	if n, err := NewOrderBookStore("empty").LoadSnapshot(filepath.Join(t.TempDir(), "missing.json"), time.Now()); err != nil || n != 0 {
+		t.Fatalf("快照不存在时恢复 %d 个, err = %v", n, err)
+	}
+}
+
+// gatedSink 放行前阻塞写入，模拟积压的写入目标
+type gatedSink struct {
+	QuoteSink
+	gate chan struct{}
+}
+
+func (s *gatedSink) Write(batch []*ParsedQuote) error {
+	<-s.gate
+	return s.QuoteSink.Write(batch)
+}
+
+func TestOrderBookSinkBlocking(t *testing.T) {
+	books := NewOrderBookStore("orderbook")
+	sink := &gatedSink{QuoteSink: books, gate: make(chan struct{})}
+	var wg sync.WaitGroup
+	parsedChan := make(chan *ParsedQuote, 50)
+	bqs := NewBondQuoteService(nil, &wg, nil, parsedChan, nil)
+	bqs.AddSink(NewMemorySink("memory"), SinkOptions{Required: true})
+	bqs.AddSink(sink, SinkOptions{WorkerNum: 1, BatchSize: 1, Blocking: true})
+	bqs.StartDBWorkers(1, 1, 10*time.Millisecond)
+
+	// 订单簿积压期间的行情等待写入，不丢弃
+	for i := 0; i < 20; i++ {
+		isin := fmt.Sprintf("HK%010d", i)
+		pq, err := ParseBondQuote(atsmock.OrderBookMessage(fmt.Sprintf("M%d", i), isin, time.Now(), []atsmock.Level{{QuoteOrderNo: "B1", Price: 100, OrderQty: 1e6}}, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pq.ReceivedAt = time.Now()
+		parsedChan <- pq
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(sink.gate)
+	close(parsedChan)
+	wg.Wait()
+	if books.Len() != 20 {
+		t.Fatalf("订单簿 %d 个", books.Len())
+	}
+	if st := bqs.SinkStats()[1]; st.Dropped != 0 || st.Written != 20 {
+		t.Fatalf("订单簿写入统计 = %+v", st)
+	}
+}
+
+func TestOrderBookRollover(t *testing.T) {
+	cal, err := calendar.New(&config.CalendarConfig{Timezone: "Asia/Hong_Kong", CutoffTime: "17:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBusinessCalendar(cal)
+	defer SetBusinessCalendar(nil)
+	friday := time.Date(2025, 9, 26, 0, 0, 0, 0, cal.Location())
+	clock := friday.Add(16 * time.Hour)
+	books := NewOrderBookStore("orderbook")
+	books.now = func() time.Time { return clock }
+	write := func(id, isin string, ts time.Time) {
+		t.Helper()
+		pq, err := ParseBondQuote(atsmock.OrderBookMessage(id, isin, ts, []atsmock.Level{{QuoteOrderNo: id, Price: 100, OrderQty: 1e6}}, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pq.ReceivedAt = ts
+		if err := books.Write([]*ParsedQuote{pq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("M1", "HK0000000001", friday.Add(16*time.Hour))
+	write("M2", "HK0000000002", friday.Add(16*time.Hour+30*time.Minute))
+	if books.Len() != 2 {
+		t.Fatalf("日切前订单簿 %d 个", books.Len())
+	}
+	// 日切后的行情属于下周一，清空周五的订单簿
+	write("M3", "HK0000000002", friday.Add(17*time.Hour+30*time.Minute))
+	if _, ok := books.Book("HK0000000001"); ok || books.Len() != 1 || mustBook(t, books, "HK0000000002").MessageID != "M3" {
+		t.Fatalf("日切后订单簿 %d 个", books.Len())
+	}
+	// 迟到的周五行情不再进入
+	write("M4", "HK0000000001", friday.Add(16*time.Hour+59*time.Minute))
+	if books.Len() != 1 {
+		t.Fatalf("迟到行情后订单簿 %d 个", books.Len())
+	}
+
+	// 没有新行情时读取也按当前时间清空，查询转为读取当天的最新行情表
+	clock = friday.AddDate(0, 0, 3).Add(17 * time.Hour)
+	if books.Len() != 0 || len(books.Books()) != 0 {
+		t.Fatalf("下一业务日期订单簿 %d 个", books.Len())
+	}
+}
+
+func mustBook(t *testing.T, books *OrderBookStore, isin string) *OrderBook {
+	t.Helper()
+	b, ok := books.Book(isin)
+	if !ok {
+		t.Fatalf("%s 没有订单簿", isin)
+	}
+	return b
+}
+
+// invalidateLevel 把指定报价单号的档位改为无效（isValid=N）后重新生成消息
+func invalidateLevel(t *testing.T, body []byte, quoteOrderNo string) []byte {
+	t.Helper()
+	if quoteOrderNo == "" {
+		return body
+	}
+	pq, err := parseBondQuoteStd(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, levels := range [][]QuotePrice{pq.Payload.AskPrices, pq.Payload.BidPrices} {
+		for i := range levels {
+			if levels[i].QuoteOrderNo == quoteOrderNo {
+				levels[i].IsValid = "N"
+			}
+		}
+	}
+	inner, _ := json.Marshal(pq.Payload)
+	pq.Meta.Data.QuotePriceData = string(inner)
+	body, _ = json.Marshal(pq.Meta)
+	return body
+}
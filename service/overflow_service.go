@@ -0,0 +1,421 @@
+package service
+
+// 通道溢出策略
+// 下游通道满时直接阻塞会卡住STOMP读取循环，心跳超时后连接被断开。
+// 溢出阶段位于生产者和下游通道之间，始终及时读取上游，下游满时按配置处理：
+// - block：阻塞等待（原有行为）
+// - drop-oldest：丢弃通道中最早的消息
+// - drop-newest：丢弃新到的消息
+// - conflate：按ISIN合并，只保留每个ISIN最新的一条，下游空闲后按首次积压的顺序投递
+// - spill：溢出到本地磁盘，下游空闲后按顺序投递
+// 主动丢弃或被合并掉的消息直接确认（Ack），不再让服务端重新投递；解析结果同时归还对象池
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+	logger "wealth-bond-quote-service/pkg/log"
+	"wealth-bond-quote-service/pkg/spool"
+)
+
+// 通道溢出策略
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+	OverflowConflate   = "conflate"
+	OverflowSpill      = "spill"
+)
+
+const defaultOverflowLogInterval = 60 * time.Second
+
+// OverflowStats 溢出统计
+type OverflowStats struct {
+	Policy    string `json:"policy"`
+	Forwarded int64  `json:"forwarded"` // 投递到下游的消息数
+	Blocked   int64  `json:"blocked"`   // 阻塞等待的次数
+	Dropped   int64  `json:"dropped"`   // 丢弃的消息数
+	Conflated int64  `json:"conflated"` // 被同一ISIN新消息覆盖的消息数
+	Spilled   int64  `json:"spilled"`   // 溢出到磁盘的消息数
+	Pending   int64  `json:"pending"`   // 当前积压（合并或溢出）等待投递的消息数
+}
+
+// overflowCodec 不同消息类型的合并键、确认句柄和落盘编解码
+type overflowCodec[T any] struct {
+	key    func(T) string // 合并键，为空时不参与合并
+	ack    func(T) Acknowledger
+	encode func(T) (time.Time, []byte, error)
+	decode func(rec *spool.Record, ack Acknowledger) (T, error)
+	// release 消息不再投递到下游时归还资源（丢弃、合并或已落盘），可为nil
+	release func(T)
+}
+
+// OverflowStage 通道溢出阶段
+type OverflowStage[T any] struct {
+	name        string
+	policy      string
+	codec       overflowCodec[T]
+	logInterval time.Duration
+	lastWarn    time.Time
+
+	forwarded atomic.Int64
+	blocked   atomic.Int64
+	dropped   atomic.Int64
+	conflated atomic.Int64
+	spilled   atomic.Int64
+	pending   atomic.Int64
+
+	// conflate
+	latest map[string]T
+	order  []string
+	anon   uint64 // 无合并键的消息使用的序号
+
+	// spill
+	sp       *spool.Spool
+	reader   *spool.Reader
+	acks     map[uint64]Acknowledger // 溢出消息的确认句柄，只保存在内存中
+	head     T
+	headSeq  uint64
+	haveHead bool
+}
+
+// NewRawOverflowStage 创建原始消息通道的溢出阶段，按消息中的ISIN合并
+func NewRawOverflowStage(name string, cfg *config.OverflowConfig) (*OverflowStage[*RawMessage], error) {
+	return newOverflowStage(name, cfg, overflowCodec[*RawMessage]{
+		key: func(m *RawMessage) string { return rawQuoteKey(m.Body) },
+		ack: func(m *RawMessage) Acknowledger { return m.Ack },
+		encode: func(m *RawMessage) (time.Time, []byte, error) {
+			return m.ReceivedAt, m.Body, nil
+		},
+		decode: func(rec *spool.Record, ack Acknowledger) (*RawMessage, error) {
+			return &RawMessage{Body: rec.Body, ReceivedAt: rec.ReceivedAt, Ack: ack}, nil
+		},
+	})
+}
+
+// spilledQuote 解析结果落盘格式
+type spilledQuote struct {
+	Meta    BondQuoteMessage `json:"meta"`
+	Payload QuotePriceData   `json:"payload"`
+	Raw     []byte           `json:"raw,omitempty"` // 原始消息
+}
+
+// NewParsedOverflowStage 创建解析结果通道的溢出阶段，按ISIN合并
+func NewParsedOverflowStage(name string, cfg *config.OverflowConfig) (*OverflowStage[*ParsedQuote], error) {
+	return newOverflowStage(name, cfg, overflowCodec[*ParsedQuote]{
+		key: func(pq *ParsedQuote) string { return pq.Payload.SecurityID },
+		ack: func(pq *ParsedQuote) Acknowledger { return pq.Ack },
+		encode: func(pq *ParsedQuote) (time.Time, []byte, error) {
+			body, err := json.Marshal(spilledQuote{Meta: pq.Meta, Payload: pq.Payload, Raw: pq.Raw})
+			return pq.ReceivedAt, body, err
+		},
+		decode: func(rec *spool.Record, ack Acknowledger) (*ParsedQuote, error) {
+			var q spilledQuote
+			if err := json.Unmarshal(rec.Body, &q); err != nil {
+				return nil, err
+			}
+			return &ParsedQuote{Meta: q.Meta, Payload: q.Payload, ReceivedAt: rec.ReceivedAt, Ack: ack, Raw: q.Raw}, nil
+		},
+		release: func(pq *ParsedQuote) { pq.Release() },
+	})
+}
+
+func newOverflowStage[T any](name string, cfg *config.OverflowConfig, codec overflowCodec[T]) (*OverflowStage[T], error) {
+	q := &OverflowStage[T]{
+		name:        name,
+		policy:      cfg.Policy,
+		codec:       codec,
+		logInterval: time.Duration(cfg.LogInterval) * time.Second,
+	}
+	if q.policy == "" {
+		q.policy = OverflowBlock
+	}
+	if q.logInterval <= 0 {
+		q.logInterval = defaultOverflowLogInterval
+	}
+
+	switch q.policy {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+	case OverflowConflate:
+		q.latest = make(map[string]T)
+	case OverflowSpill:
+		if cfg.SpillDir == "" {
+			return nil, fmt.Errorf("通道%s溢出到磁盘需要配置spillDir", name)
+		}
+		sp, err := spool.Open(filepath.Join(cfg.SpillDir, name), spool.Options{})
+		if err != nil {
+			return nil, fmt.Errorf("打开通道%s溢出目录失败: %w", name, err)
+		}
+		q.sp = sp
+		q.reader = sp.NewReader()
+		q.acks = make(map[uint64]Acknowledger)
+		// 上次退出时未投递的溢出消息（无确认句柄）
+		q.pending.Store(int64(sp.Stats().Depth))
+	default:
+		return nil, fmt.Errorf("通道%s溢出策略%q无效", name, cfg.Policy)
+	}
+	return q, nil
+}
+
+// Start 启动溢出阶段：in 关闭后把积压的消息全部投递到 out 再退出，不关闭 out
+func (q *OverflowStage[T]) Start(ctx context.Context, in <-chan T, out chan T, wg *sync.WaitGroup) {
+	wg.Add(2)
+	reportCtx, stopReport := context.WithCancel(ctx)
+	go func() {
+		defer wg.Done()
+		defer stopReport()
+		q.run(in, out)
+	}()
+	go func() {
+		defer wg.Done()
+		q.report(reportCtx)
+	}()
+}
+
+// Stats 返回溢出统计
+func (q *OverflowStage[T]) Stats() OverflowStats {
+	return OverflowStats{
+		Policy:    q.policy,
+		Forwarded: q.forwarded.Load(),
+		Blocked:   q.blocked.Load(),
+		Dropped:   q.dropped.Load(),
+		Conflated: q.conflated.Load(),
+		Spilled:   q.spilled.Load(),
+		Pending:   q.pending.Load(),
+	}
+}
+
+func (q *OverflowStage[T]) run(in <-chan T, out chan T) {
+	if q.sp != nil {
+		defer q.sp.Close()
+		defer q.reader.Close()
+	}
+	for {
+		if q.pending.Load() == 0 {
+			v, ok := <-in
+			if !ok {
+				return
+			}
+			q.offer(v, out)
+			continue
+		}
+
+		// 有积压时新消息排在积压之后，保证顺序
+		next, ok := q.peek()
+		if !ok {
+			continue
+		}
+		select {
+		case v, ok := <-in:
+			if !ok {
+				q.flush(out)
+				return
+			}
+			q.enqueue(v, out)
+		case out <- next:
+			q.pop()
+			q.forwarded.Add(1)
+		}
+	}
+}
+
+// offer 投递到下游，下游满时按策略处理
+func (q *OverflowStage[T]) offer(v T, out chan T) {
+	select {
+	case out <- v:
+		q.forwarded.Add(1)
+		return
+	default:
+	}
+	q.warn()
+
+	switch q.policy {
+	case OverflowDropNewest:
+		q.dropped.Add(1)
+		q.discard(v)
+	case OverflowDropOldest:
+		for {
+			select {
+			case out <- v:
+				q.forwarded.Add(1)
+				return
+			default:
+			}
+			select {
+			case old := <-out:
+				q.dropped.Add(1)
+				q.discard(old)
+			default:
+			}
+		}
+	case OverflowConflate, OverflowSpill:
+		q.enqueue(v, out)
+	default:
+		q.blocked.Add(1)
+		out <- v
+		q.forwarded.Add(1)
+	}
+}
+
+// enqueue 加入积压
+func (q *OverflowStage[T]) enqueue(v T, out chan T) {
+	if q.policy == OverflowConflate {
+		key := q.codec.key(v)
+		if key == "" {
+			q.anon++
+			key = fmt.Sprintf("\x00%d", q.anon)
+		}
+		if old, ok := q.latest[key]; ok {
+			q.conflated.Add(1)
+			q.discard(old)
+		} else {
+			q.order = append(q.order, key)
+			q.pending.Add(1)
+		}
+		q.latest[key] = v
+		return
+	}
+
+	receivedAt, body, err := q.codec.encode(v)
+	var seq uint64
+	if err == nil {
+		seq, err = q.sp.Append(receivedAt, body)
+	}
+	if err != nil {
+		// 落盘失败时退回阻塞，不丢消息
+		logger.Error("通道%s溢出到磁盘失败，阻塞等待: %v", q.name, err)
+		q.flush(out)
+		q.blocked.Add(1)
+		out <- v
+		q.forwarded.Add(1)
+		return
+	}
+	if ack := q.codec.ack(v); ack != nil {
+		q.acks[seq] = ack
+	}
+	// 投递时从磁盘解码出新的消息，内存中的不再使用
+	q.release(v)
+	q.spilled.Add(1)
+	q.pending.Add(1)
+}
+
+// discard 丢弃消息：确认并归还资源
+func (q *OverflowStage[T]) discard(v T) {
+	ackMessage(q.codec.ack(v))
+	q.release(v)
+}
+
+// release 归还不再投递的消息占用的资源
+func (q *OverflowStage[T]) release(v T) {
+	if q.codec.release != nil {
+		q.codec.release(v)
+	}
+}
+
+// peek 返回最早积压的消息
+func (q *OverflowStage[T]) peek() (T, bool) {
+	if q.policy == OverflowConflate {
+		return q.latest[q.order[0]], true
+	}
+	for !q.haveHead {
+		rec, err := q.reader.Next(context.Background())
+		if err != nil {
+			logger.Error("读取通道%s溢出消息失败，丢弃剩余 %d 条: %v", q.name, q.pending.Load(), err)
+			q.pending.Store(0)
+			var zero T
+			return zero, false
+		}
+		ack := q.acks[rec.Seq]
+		v, err := q.codec.decode(rec, ack)
+		if err != nil {
+			logger.Error("解码通道%s溢出消息 %d 失败，丢弃: %v", q.name, rec.Seq, err)
+			q.dropSpilled(rec.Seq)
+			ackMessage(ack)
+			continue
+		}
+		q.head, q.headSeq, q.haveHead = v, rec.Seq, true
+	}
+	return q.head, true
+}
+
+// pop 移除最早积压的消息（已投递）
+func (q *OverflowStage[T]) pop() {
+	if q.policy == OverflowConflate {
+		delete(q.latest, q.order[0])
+		q.order = q.order[1:]
+		q.pending.Add(-1)
+		return
+	}
+	var zero T
+	q.head, q.haveHead = zero, false
+	q.dropSpilled(q.headSeq)
+}
+
+// dropSpilled 溢出消息已离开磁盘
+func (q *OverflowStage[T]) dropSpilled(seq uint64) {
+	delete(q.acks, seq)
+	q.sp.Commit(seq)
+	q.pending.Add(-1)
+}
+
+// flush 阻塞投递全部积压
+func (q *OverflowStage[T]) flush(out chan T) {
+	for q.pending.Load() > 0 {
+		next, ok := q.peek()
+		if !ok {
+			return
+		}
+		out <- next
+		q.pop()
+		q.forwarded.Add(1)
+	}
+}
+
+// warn 下游满时告警，按统计间隔限频
+func (q *OverflowStage[T]) warn() {
+	if now := time.Now(); now.Sub(q.lastWarn) >= q.logInterval {
+		q.lastWarn = now
+		logger.Warn("通道%s已满，按%s策略处理", q.name, q.policy)
+	}
+}
+
+// report 定期输出溢出统计，没有新的溢出时不输出
+func (q *OverflowStage[T]) report(ctx context.Context) {
+	ticker := time.NewTicker(q.logInterval)
+	defer ticker.Stop()
+	var last OverflowStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s := q.Stats()
+			if s.Blocked == last.Blocked && s.Dropped == last.Dropped && s.Conflated == last.Conflated &&
+				s.Spilled == last.Spilled && s.Pending == 0 {
+				continue
+			}
+			last = s
+			logger.Info("通道%s溢出统计(%s): 投递 %d, 阻塞 %d, 丢弃 %d, 合并 %d, 落盘 %d, 积压 %d",
+				q.name, s.Policy, s.Forwarded, s.Blocked, s.Dropped, s.Conflated, s.Spilled, s.Pending)
+		}
+	}
+}
+
+// rawQuoteKey 从原始消息中取ISIN作为合并键，解析失败时返回空
+// 与写库使用同一解析器，各种格式的消息得到相同的键
+func rawQuoteKey(body []byte) string {
+	pq, err := ParseBondQuote(body)
+	if err != nil {
+		return ""
+	}
+	key := strings.Clone(pq.Payload.SecurityID)
+	pq.Release()
+	return key
+}
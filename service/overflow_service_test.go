@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+// countingAck 记录确认次数
+type countingAck struct {
+	acks, nacks atomic.Int32
+}
+
+func (a *countingAck) Ack() error  { a.acks.Add(1); return nil }
+func (a *countingAck) Nack() error { a.nacks.Add(1); return nil }
+
+func TestOverflowPolicies(t *testing.T) {
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	msg := func(id, isin string) *RawMessage {
+		return &RawMessage{
+			Body:       atsmock.OrderBookMessage(id, isin, time.Now(), level, nil),
+			ReceivedAt: time.Now(),
+			Ack:        &countingAck{},
+		}
+	}
+
+	cases := []struct {
+		policy string
+		want   []string // 下游收到的消息ID
+		acked  []string // 被丢弃或合并而确认的消息ID
+		stats  OverflowStats
+	}{
+		{OverflowDropNewest, []string{"A1"}, []string{"B1", "A2", "B2", "A3"}, OverflowStats{Forwarded: 1, Dropped: 4}},
+		{OverflowDropOldest, []string{"A3"}, []string{"A1", "B1", "A2", "B2"}, OverflowStats{Forwarded: 5, Dropped: 4}},
+		{OverflowConflate, []string{"A1", "B2", "A3"}, []string{"A2", "B1"}, OverflowStats{Forwarded: 3, Conflated: 2}},
+		{OverflowSpill, []string{"A1", "B1", "A2", "B2", "A3"}, nil, OverflowStats{Forwarded: 5, Spilled: 4}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			cfg := &config.OverflowConfig{Policy: tc.policy, SpillDir: t.TempDir()}
+			stage, err := NewRawOverflowStage("raw", cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			in := make(chan *RawMessage)
+			out := make(chan *RawMessage, 1)
+			stage.Start(context.Background(), in, out, &wg)
+
+			// 下游没有消费，只有第一条能进入通道
+			sent := map[string]*RawMessage{}
+			for _, m := range []struct{ id, isin string }{
+				{"A1", "HK0000000001"}, {"B1", "HK0000000002"}, {"A2", "HK0000000001"},
+				{"B2", "HK0000000002"}, {"A3", "HK0000000001"},
+			} {
+				sent[m.id] = msg(m.id, m.isin)
+				in <- sent[m.id]
+			}
+
+			// 关闭上游后积压全部投递
+			var got []string
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for raw := range out {
+					pq, err := ParseBondQuote(raw.Body)
+					if err != nil {
+						t.Error(err)
+						continue
+					}
+					if raw.Ack != sent[pq.Meta.Data.MessageID].Ack {
+						t.Errorf("%s 的确认句柄未保留", pq.Meta.Data.MessageID)
+					}
+					got = append(got, pq.Meta.Data.MessageID)
+				}
+			}()
+			close(in)
+			wg.Wait()
+			close(out)
+			<-done
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("下游收到 %v, 期望 %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("下游收到 %v, 期望 %v", got, tc.want)
+				}
+			}
+			for _, id := range tc.acked {
+				if n := sent[id].Ack.(*countingAck).acks.Load(); n != 1 {
+					t.Errorf("%s 确认次数 = %d", id, n)
+				}
+			}
+			tc.stats.Policy = tc.policy
+			if st := stage.Stats(); st != tc.stats {
+				t.Fatalf("统计 = %+v, 期望 %+v", st, tc.stats)
+			}
+		})
+	}
+
+	if _, err := NewRawOverflowStage("raw", &config.OverflowConfig{Policy: "unknown"}); err == nil {
+		t.Fatal("无效策略应返回错误")
+	}
+	if _, err := NewRawOverflowStage("raw", &config.OverflowConfig{Policy: OverflowSpill}); err == nil {
+		t.Fatal("spill 未配置目录应返回错误")
+	}
+}
+
+func TestParsedOverflowReleasesDiscarded(t *testing.T) {
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	cases := []struct {
+		policy   string
+		released []string // 丢弃、合并或已落盘而归还对象池的消息ID
+	}{
+		{OverflowDropNewest, []string{"B1", "A2", "B2", "A3"}},
+		{OverflowDropOldest, []string{"A1", "B1", "A2", "B2"}},
+		{OverflowConflate, []string{"A2", "B1"}},
+		{OverflowSpill, []string{"B1", "A2", "B2", "A3"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			stage, err := NewParsedOverflowStage("parsed", &config.OverflowConfig{Policy: tc.policy, SpillDir: t.TempDir()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			in := make(chan *ParsedQuote)
+			out := make(chan *ParsedQuote, 1)
+			stage.Start(context.Background(), in, out, &wg)
+
+			// 先全部解析，避免已归还的对象被后面的解析复用
+			ids := []string{"A1", "B1", "A2", "B2", "A3"}
+			sent := map[string]*ParsedQuote{}
+			for _, id := range ids {
+				isin := "HK0000000001"
+				if id[0] == 'B' {
+					isin = "HK0000000002"
+				}
+				pq, err := ParseBondQuote(atsmock.OrderBookMessage(id, isin, time.Now(), level, nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				sent[id] = pq
+			}
+			for _, id := range ids {
+				in <- sent[id]
+			}
+			// 下游收到的消息由写库层释放，这里只排空
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for range out {
+				}
+			}()
+			close(in)
+			wg.Wait()
+			close(out)
+			<-done
+
+			released := map[string]bool{}
+			for _, id := range tc.released {
+				released[id] = true
+			}
+			for id, pq := range sent {
+				if got := pq.refs.Load() == 0; got != released[id] {
+					t.Errorf("%s 已释放 = %v, 期望 %v", id, got, released[id])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+// 行情处理流水线的生命周期管理
+// 各阶段按依赖顺序注册：下游（写库、死信）先注册先启动，接入（ATS会话）最后启动；
+// 停止时按注册的逆序：先停止接入，再依次排空解析层、写库层，
+// 每层的输入通道只由上一层关闭，且在上一层全部退出之后关闭，避免向已关闭的通道发送。
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	logger "wealth-bond-quote-service/pkg/log"
+)
+
+// PipelineStage 流水线中的一个阶段
+type PipelineStage struct {
+	Name string
+	// Start 启动阶段，ctx 在停止该阶段前取消
+	Start func(ctx context.Context) error
+	// Stop 关闭阶段的输入并等待排空，ctx 带整体关闭的截止时间，可为nil
+	Stop func(ctx context.Context) error
+}
+
+// Pipeline 流水线生命周期管理
+type Pipeline struct {
+	mu      sync.Mutex
+	stages  []PipelineStage
+	cancels []context.CancelFunc // 已启动阶段的取消函数
+}
+
+// NewPipeline 创建流水线
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Add 注册阶段，下游阶段先注册
+func (p *Pipeline) Add(stage PipelineStage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stages = append(p.stages, stage)
+}
+
+// Start 按注册顺序启动各阶段，某一阶段启动失败时停止已启动的阶段并返回错误
+func (p *Pipeline) Start(ctx context.Context, stopTimeout time.Duration) error {
+	p.mu.Lock()
+	stages := p.stages
+	p.mu.Unlock()
+
+	for _, stage := range stages {
+		stageCtx, cancel := context.WithCancel(ctx)
+		if stage.Start != nil {
+			if err := stage.Start(stageCtx); err != nil {
+				cancel()
+				err = fmt.Errorf("启动%s失败: %w", stage.Name, err)
+				logger.Error("%v", err)
+				p.Stop(stopTimeout)
+				return err
+			}
+		}
+		p.mu.Lock()
+		p.cancels = append(p.cancels, cancel)
+		p.mu.Unlock()
+		logger.Info("流水线阶段已启动: %s", stage.Name)
+	}
+	return nil
+}
+
+// Stop 按注册的逆序停止已启动的阶段，所有阶段共用一个截止时间
+// 某一阶段超时或出错时，上游协程可能仍在向下游发送，不再关闭后面阶段的输入，只取消其上下文
+func (p *Pipeline) Stop(timeout time.Duration) error {
+	p.mu.Lock()
+	cancels := p.cancels
+	p.cancels = nil
+	stages := p.stages[:len(cancels)]
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stopErr error
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage := stages[i]
+		start := time.Now()
+		cancels[i]()
+		if stopErr != nil || stage.Stop == nil {
+			continue
+		}
+		if err := stage.Stop(ctx); err != nil {
+			stopErr = fmt.Errorf("停止%s: %w", stage.Name, err)
+			logger.Error("%v，后续阶段不再排空", stopErr)
+			continue
+		}
+		logger.Info("流水线阶段已停止: %s, 耗时 %s", stage.Name, time.Since(start).Round(time.Millisecond))
+	}
+	return stopErr
+}
+
+// WaitGroupContext 等待 wg 完成，ctx 先结束时返回 ctx 的错误
+func WaitGroupContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+)
+
+func TestPipelineOrderedShutdown(t *testing.T) {
+	conn := newTestDB(t)
+	rawChan := make(chan *RawMessage, 10)
+	parsedChan := make(chan *ParsedQuote, 10)
+	deadChan := make(chan *DeadMessage, 10)
+
+	var order []string
+	record := func(name string) { order = append(order, name) }
+	pipeline := NewPipeline()
+
+	var deadWg sync.WaitGroup
+	var dead int
+	pipeline.Add(PipelineStage{
+		Name: "dead",
+		Start: func(ctx context.Context) error {
+			record("start dead")
+			deadWg.Add(1)
+			go func() {
+				defer deadWg.Done()
+				for range deadChan {
+					dead++
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			record("stop dead")
+			close(deadChan)
+			return WaitGroupContext(ctx, &deadWg)
+		},
+	})
+	var dbWg sync.WaitGroup
+	writer := NewBondQuoteService(conn, &dbWg, rawChan, parsedChan, deadChan)
+	pipeline.Add(PipelineStage{
+		Name: "db",
+		Start: func(ctx context.Context) error {
+			record("start db")
+			// 刷新间隔很长，关闭时必须写入最后一批
+			writer.StartDBWorkers(2, 1000, time.Hour)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			record("stop db")
+			close(parsedChan)
+			return WaitGroupContext(ctx, &dbWg)
+		},
+	})
+	var parseWg sync.WaitGroup
+	parser := NewBondQuoteService(conn, &parseWg, rawChan, parsedChan, deadChan)
+	pipeline.Add(PipelineStage{
+		Name: "parse",
+		Start: func(ctx context.Context) error {
+			record("start parse")
+			parser.StartParseWorkers(2)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			record("stop parse")
+			close(rawChan)
+			return WaitGroupContext(ctx, &parseWg)
+		},
+	})
+
+	// 接入：持续发送直到被取消
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	var ingestWg sync.WaitGroup
+	sent := 0
+	pipeline.Add(PipelineStage{
+		Name: "ingest",
+		Start: func(ctx context.Context) error {
+			record("start ingest")
+			ingestWg.Add(1)
+			go func() {
+				defer ingestWg.Done()
+				for i := 0; ; i++ {
+					body := atsmock.OrderBookMessage("P"+time.Now().Format("150405.000000000"), "HK0000000001", time.Now(), level, nil)
+					if i%5 == 4 {
+						body = []byte(`{"data":`)
+					}
+					select {
+					case rawChan <- &RawMessage{Body: body, ReceivedAt: time.Now()}:
+						sent++
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			record("stop ingest")
+			return WaitGroupContext(ctx, &ingestWg)
+		},
+	})
+
+	if err := pipeline.Start(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := pipeline.Stop(5 * time.Second); err != nil {
+		t.Fatalf("停止: %v", err)
+	}
+
+	want := []string{"start dead", "start db", "start parse", "start ingest", "stop ingest", "stop parse", "stop db", "stop dead"}
+	if len(order) != len(want) {
+		t.Fatalf("顺序 = %v", order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("顺序 = %v", order)
+		}
+	}
+
+	// 所有已接收的消息都已写库或进入死信
+	parsed, written := parser.Counts(), writer.Counts()
+	var rows int64
+	conn.Table(GetTodayDetailTableName()).Count(&rows)
+	if sent == 0 || int64(sent) != parsed.Parsed+parsed.Dead || written.Written != parsed.Parsed || rows != written.Written || int64(dead) != parsed.Dead {
+		t.Fatalf("发送 %d, 解析 %+v, 写库 %+v, 明细行数 %d, 死信 %d", sent, parsed, written, rows, dead)
+	}
+}
+
+func TestPipelineStopDeadline(t *testing.T) {
+	pipeline := NewPipeline()
+	var stopped []string
+	block := make(chan struct{})
+	defer close(block)
+	pipeline.Add(PipelineStage{
+		Name: "sink",
+		Stop: func(ctx context.Context) error { stopped = append(stopped, "sink"); return nil },
+	})
+	pipeline.Add(PipelineStage{
+		Name: "stuck",
+		Stop: func(ctx context.Context) error {
+			select {
+			case <-block:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+	startErr := errors.New("连接失败")
+	pipeline.Add(PipelineStage{
+		Name:  "source",
+		Start: func(ctx context.Context) error { return startErr },
+		Stop:  func(ctx context.Context) error { stopped = append(stopped, "source"); return nil },
+	})
+
+	// 启动失败时停止已启动的阶段；卡住的阶段超时后不再排空下游
+	err := pipeline.Start(context.Background(), 50*time.Millisecond)
+	if !errors.Is(err, startErr) {
+		t.Fatalf("启动 err = %v", err)
+	}
+	if len(stopped) != 0 {
+		t.Fatalf("已停止的阶段 = %v", stopped)
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 	"wealth-bond-quote-service/model"
@@ -12,7 +13,8 @@ import (
 
 // BondQueryService 债券行情查询服务
 type BondQueryService struct {
-	db *gorm.DB
+	db    *gorm.DB
+	books *OrderBookStore // 内存订单簿，为空时从最新行情表读取
 }
 
 // NewBondQueryService 创建债券行情查询服务
@@ -20,6 +22,102 @@ func NewBondQueryService(db *gorm.DB) *BondQueryService {
 	return &BondQueryService{db: db}
 }
 
+// SetOrderBooks 设置内存订单簿，设置后订单簿查询和最新行情导出直接读取订单簿
+func (s *BondQueryService) SetOrderBooks(books *OrderBookStore) {
+	s.books = books
+}
+
+// ErrOrderBookNotFound 没有该ISIN的订单簿
+var ErrOrderBookNotFound = errors.New("订单簿不存在")
+
+// OrderBook 查询单个ISIN的订单簿
+func (s *BondQueryService) OrderBook(isin string) (*OrderBook, error) {
+	if s.books != nil {
+		if b, ok := s.books.Book(isin); ok {
+			return b, nil
+		}
+		if s.books.Len() > 0 {
+			return nil, ErrOrderBookNotFound
+		}
+	}
+	var quote model.BondLatestQuote
+	err := s.db.Table(GetTodayLatestTableName()).Where("isin = ?", isin).Take(&quote).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOrderBookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询最新行情失败: %w", err)
+	}
+	return orderBookFromLatest(&quote)
+}
+
+// OrderBookSummaries 查询全部订单簿的最优买卖价、中间价、价差和深度，按ISIN排序
+func (s *BondQueryService) OrderBookSummaries() ([]BookSummary, error) {
+	books, err := s.latestBooks()
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]BookSummary, len(books))
+	for i, b := range books {
+		summaries[i] = b.Summary()
+	}
+	return summaries, nil
+}
+
+// latestBooks 当前最新订单簿：内存订单簿有数据时直接读取，否则从当天最新行情表解析
+func (s *BondQueryService) latestBooks() ([]*OrderBook, error) {
+	if s.books != nil && s.books.Len() > 0 {
+		return s.books.Books(), nil
+	}
+	var latestQuotes []model.BondLatestQuote
+	if err := s.db.Table(GetTodayLatestTableName()).Order("isin").Find(&latestQuotes).Error; err != nil {
+		return nil, fmt.Errorf("查询最新行情数据失败: %w", err)
+	}
+	return latestQuotesToBooks(latestQuotes), nil
+}
+
+// maxHistoryDays 报价历史一次最多查询的天数
+const maxHistoryDays = 31
+
+// QuoteOrderHistory 查询报价单的生命周期事件，按事件时间排序
+// 日期为业务日期，为空时查询当前业务日期，只填开始日期时查询开始日期当天
+func (s *BondQueryService) QuoteOrderHistory(quoteOrderNo string, param DateRangeParam) ([]model.BondQuoteEvent, error) {
+	today := BusinessDate(time.Now())
+	startDate, endDate := today, today
+	var err error
+	if param.StartDate != "" {
+		if startDate, err = time.ParseInLocation("20060102", param.StartDate, BusinessLocation()); err != nil {
+			return nil, fmt.Errorf("开始日期格式错误: %w", err)
+		}
+		endDate = startDate
+	}
+	if param.EndDate != "" {
+		if endDate, err = time.ParseInLocation("20060102", param.EndDate, BusinessLocation()); err != nil {
+			return nil, fmt.Errorf("结束日期格式错误: %w", err)
+		}
+	}
+	if endDate.Before(startDate) {
+		return nil, fmt.Errorf("结束日期不能早于开始日期")
+	}
+	if endDate.Sub(startDate) >= maxHistoryDays*24*time.Hour {
+		return nil, fmt.Errorf("日期范围不能超过%d天", maxHistoryDays)
+	}
+
+	var events []model.BondQuoteEvent
+	for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
+		table := GetEventTableName(d)
+		if !s.tableExists(table) {
+			continue
+		}
+		var list []model.BondQuoteEvent
+		if err := s.db.Table(table).Where("quote_order_no = ?", quoteOrderNo).Order("event_time, id").Find(&list).Error; err != nil {
+			return nil, fmt.Errorf("查询报价事件失败 %s: %w", table, err)
+		}
+		events = append(events, list...)
+	}
+	return events, nil
+}
+
 // 日期范围参数
 type DateRangeParam struct {
 	StartDate string `json:"startDate" form:"startDate"` // 格式: YYYYMMDD
@@ -36,12 +134,12 @@ type TimeRangeParam struct {
 // ExportDailyEndData 导出日终数据（从最新行情表）
 func (s *BondQueryService) ExportDailyEndData(param DateRangeParam) (string, error) {
 	// 解析日期
-	startDate, err := time.Parse("20060102", param.StartDate)
+	startDate, err := time.ParseInLocation("20060102", param.StartDate, BusinessLocation())
 	if err != nil {
 		return "", fmt.Errorf("开始日期格式错误: %w", err)
 	}
 
-	endDate, err := time.Parse("20060102", param.EndDate)
+	endDate, err := time.ParseInLocation("20060102", param.EndDate, BusinessLocation())
 	if err != nil {
 		return "", fmt.Errorf("结束日期格式错误: %w", err)
 	}
@@ -194,8 +292,8 @@ func (s *BondQueryService) ExportDailyEndData(param DateRangeParam) (string, err
 
 // ExportTimeRangeData 导出时间段数据（从明细表）
 func (s *BondQueryService) ExportTimeRangeData(param TimeRangeParam) (string, error) {
-	// 解析日期和时间
-	date, err := time.Parse("20060102", param.Date)
+	// 解析日期和时间，日期为业务日期，时间按交易日历的时区
+	date, err := time.ParseInLocation("20060102", param.Date, BusinessLocation())
 	if err != nil {
 		return "", fmt.Errorf("日期格式错误: %w", err)
 	}
@@ -209,12 +307,12 @@ func (s *BondQueryService) ExportTimeRangeData(param TimeRangeParam) (string, er
 	}
 
 	// 构建时间范围
-	startDateTime, err := time.Parse("20060102 15:04:05", fmt.Sprintf("%s %s", dateStr, param.StartTime))
+	startDateTime, err := time.ParseInLocation("20060102 15:04:05", fmt.Sprintf("%s %s", dateStr, param.StartTime), BusinessLocation())
 	if err != nil {
 		return "", fmt.Errorf("开始时间格式错误: %w", err)
 	}
 
-	endDateTime, err := time.Parse("20060102 15:04:05", fmt.Sprintf("%s %s", dateStr, param.EndTime))
+	endDateTime, err := time.ParseInLocation("20060102 15:04:05", fmt.Sprintf("%s %s", dateStr, param.EndTime), BusinessLocation())
 	if err != nil {
 		return "", fmt.Errorf("结束时间格式错误: %w", err)
 	}
@@ -368,9 +466,9 @@ func (s *BondQueryService) ExportTimeRangeData(param TimeRangeParam) (string, er
 // ExportCurrentLatestQuotes 导出当前最新行情到Excel
 func (s *BondQueryService) ExportCurrentLatestQuotes() (string, error) {
 	// 查询当前最新行情数据
-	var latestQuotes []model.BondLatestQuote
-	if err := s.db.Find(&latestQuotes).Error; err != nil {
-		return "", fmt.Errorf("查询最新行情数据失败: %w", err)
+	books, err := s.latestBooks()
+	if err != nil {
+		return "", err
 	}
 
 	// 创建Excel文件
@@ -403,30 +501,10 @@ func (s *BondQueryService) ExportCurrentLatestQuotes() (string, error) {
 	rowIndex := 2
 
 	// 填充数据
-	for _, quote := range latestQuotes {
-		// 解析JSON数据
-		var msg BondQuoteMessage
-		if err := json.Unmarshal([]byte(quote.RawJSON), &msg); err != nil {
-			continue
-		}
-
-		// 解析内部报价数据
-		var payload QuotePriceData
-		if err := json.Unmarshal([]byte(msg.Data.QuotePriceData), &payload); err != nil {
-			continue
-		}
-
-		// 获取买方和卖方报价
-		var bidPrices []QuotePrice
-		var askPrices []QuotePrice
-
-		if len(payload.BidPrices) > 0 {
-			bidPrices = payload.BidPrices
-		}
-
-		if len(payload.AskPrices) > 0 {
-			askPrices = payload.AskPrices
-		}
+	for _, quote := range books {
+		// 买方和卖方报价，按价格排序
+		bidPrices := quote.Bids
+		askPrices := quote.Asks
 
 		// 确定需要多少行
 		maxRows := len(bidPrices)
@@ -444,7 +522,7 @@ func (s *BondQueryService) ExportCurrentLatestQuotes() (string, error) {
 			f.SetCellValue(sheetName, fmt.Sprintf("K%d", rowIndex), quote.MessageType)
 			f.SetCellValue(sheetName, fmt.Sprintf("L%d", rowIndex), time.UnixMilli(quote.SendTime).Format("2006-01-02 15:04:05.000"))
 			f.SetCellValue(sheetName, fmt.Sprintf("M%d", rowIndex), time.UnixMilli(quote.Timestamp).Format("2006-01-02 15:04:05.000"))
-			f.SetCellValue(sheetName, fmt.Sprintf("N%d", rowIndex), quote.LastUpdateTime.Format("2006-01-02 15:04:05.000"))
+			f.SetCellValue(sheetName, fmt.Sprintf("N%d", rowIndex), quote.UpdateTime.Format("2006-01-02 15:04:05.000"))
 			rowIndex++
 			continue
 		}
@@ -479,7 +557,7 @@ func (s *BondQueryService) ExportCurrentLatestQuotes() (string, error) {
 			f.SetCellValue(sheetName, fmt.Sprintf("K%d", rowIndex), quote.MessageType)
 			f.SetCellValue(sheetName, fmt.Sprintf("L%d", rowIndex), time.UnixMilli(quote.SendTime).Format("2006-01-02 15:04:05.000"))
 			f.SetCellValue(sheetName, fmt.Sprintf("M%d", rowIndex), time.UnixMilli(quote.Timestamp).Format("2006-01-02 15:04:05.000"))
-			f.SetCellValue(sheetName, fmt.Sprintf("N%d", rowIndex), quote.LastUpdateTime.Format("2006-01-02 15:04:05.000"))
+			f.SetCellValue(sheetName, fmt.Sprintf("N%d", rowIndex), quote.UpdateTime.Format("2006-01-02 15:04:05.000"))
 
 			rowIndex++
 		}
@@ -0,0 +1,645 @@
+package service
+
+// 行情消息解析
+// 针对固定消息结构手写的单遍解析器，替代两次 encoding/json 反射解析：
+// 1. 外层消息中 data.data 是转义后的内层 JSON 字符串，只反转义一次，之后直接在该字符串上解析报价
+// 2. 档位中的字符串字段直接引用内层字符串的子串，档位再多也不额外分配内存
+// 3. 字段名匹配与标准库一致（先精确匹配，再不区分大小写），未知字段按 JSON 语法校验后跳过，null 保留零值
+// 4. 类型不符的字段返回带路径的错误（如 askPrices[0].price），作为死信原因
+// 外层消息的字符串字段会复制，不引用原始消息（原始消息的缓冲区可能被复用）
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+var errUnexpectedEnd = errors.New("unexpected end of JSON input")
+
+// quoteDecoder 在字符串上顺序解析 JSON
+type quoteDecoder struct {
+	s       string
+	pos     int
+	owned   bool // s 归解析结果所有，字符串字段可以直接引用子串
+	variant bool // 遇到了文档中的其他格式（对象形式的 data、ISO 时间、securityCode 等），需要规范化内层报价
+}
+
+// pathError 带字段路径的解析错误，例如 askPrices[0].quoteTime: ...，作为死信原因便于定位
+type pathError struct {
+	path string
+	err  error
+}
+
+func (e *pathError) Error() string { return e.path + ": " + e.err.Error() }
+func (e *pathError) Unwrap() error { return e.err }
+
+// atPath 给错误加上一级字段路径，elem 为字段名或 [下标]
+func atPath(elem string, err error) error {
+	pe, ok := err.(*pathError)
+	if !ok {
+		return &pathError{path: elem, err: err}
+	}
+	if strings.HasPrefix(pe.path, "[") {
+		pe.path = elem + pe.path
+	} else {
+		pe.path = elem + "." + pe.path
+	}
+	return pe
+}
+
+// decodeBondQuote 解析外层消息，再直接在内层 JSON 字符串上解析报价
+// 兼容 record.md 中记录的格式：data 为对象、时间为 ISO-8601、用 securityCode 代替 securityId、带 settleDate，
+// 这类消息解析后把内层报价规范化为标准格式（securityId、毫秒时间戳），下游按标准格式读取
+func decodeBondQuote(raw []byte, pq *ParsedQuote) error {
+	outer := quoteDecoder{s: unsafe.String(unsafe.SliceData(raw), len(raw))}
+	if err := outer.document(func() error { return outer.message(&pq.Meta) }); err != nil {
+		return fmt.Errorf("unmarshal BondQuoteMessage: %w", err)
+	}
+	inner := quoteDecoder{s: pq.Meta.Data.QuotePriceData, owned: true}
+	if err := inner.document(func() error { return inner.payload(&pq.Payload) }); err != nil {
+		return fmt.Errorf("unmarshal QuotePriceData: %w", err)
+	}
+
+	// isin必须存在：依次取 securityId/securityCode、外层 data.securityCode、档位中的代码
+	p := &pq.Payload
+	if p.SecurityID == "" {
+		p.SecurityID = pq.Meta.Data.SecurityCode
+		for _, levels := range [][]QuotePrice{p.AskPrices, p.BidPrices} {
+			for i := 0; i < len(levels) && p.SecurityID == ""; i++ {
+				p.SecurityID = levels[i].SecurityID
+			}
+		}
+		if p.SecurityID == "" {
+			return errors.New("securityId is empty")
+		}
+		inner.variant = true
+	}
+
+	if outer.variant || inner.variant {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("marshal QuotePriceData: %w", err)
+		}
+		pq.Meta.Data.QuotePriceData = string(b)
+	}
+	return nil
+}
+
+// document 解析一个完整的 JSON 文档，顶层值之后只允许空白
+func (d *quoteDecoder) document(value func() error) error {
+	if err := value(); err != nil {
+		return err
+	}
+	d.skipSpace()
+	if d.pos < len(d.s) {
+		return d.syntaxError("after top-level value")
+	}
+	return nil
+}
+
+func (d *quoteDecoder) message(m *BondQuoteMessage) error {
+	return d.object(func(key string) error {
+		switch {
+		case fieldIs(key, "data"):
+			return d.object(func(key string) error {
+				switch {
+				case fieldIs(key, "data"):
+					return d.quotePriceData(&m.Data.QuotePriceData)
+				case fieldIs(key, "messageId"):
+					return d.str(&m.Data.MessageID)
+				case fieldIs(key, "messageType"):
+					return d.str(&m.Data.MessageType)
+				case fieldIs(key, "organization"):
+					return d.str(&m.Data.Organization)
+				case fieldIs(key, "receiverId"):
+					return d.str(&m.Data.ReceiverID)
+				case fieldIs(key, "securityCode"):
+					return d.str(&m.Data.SecurityCode)
+				case fieldIs(key, "timestamp"):
+					return d.millis(&m.Data.Timestamp)
+				}
+				return d.skip()
+			})
+		case fieldIs(key, "sendTime"):
+			return d.millis(&m.SendTime)
+		case fieldIs(key, "wsMessageType"):
+			return d.str(&m.WsMessageType)
+		}
+		return d.skip()
+	})
+}
+
+// quotePriceData 读取内层报价：JSON 字符串，或直接嵌套的对象（保存对象原文）
+func (d *quoteDecoder) quotePriceData(dst *string) error {
+	d.skipSpace()
+	if d.peek() != '{' {
+		if c := d.peek(); c != '"' && c != 'n' {
+			return d.typeError("string or object")
+		}
+		return d.str(dst)
+	}
+	start := d.pos
+	if err := d.skip(); err != nil {
+		return err
+	}
+	*dst = strings.Clone(d.s[start:d.pos])
+	d.variant = true
+	return nil
+}
+
+func (d *quoteDecoder) payload(p *QuotePriceData) error {
+	var code string
+	err := d.object(func(key string) error {
+		switch {
+		case fieldIs(key, "askPrices"):
+			return d.levels(&p.AskPrices)
+		case fieldIs(key, "bidPrices"):
+			return d.levels(&p.BidPrices)
+		case fieldIs(key, "securityId"):
+			return d.str(&p.SecurityID)
+		case fieldIs(key, "securityCode"):
+			return d.str(&code)
+		}
+		return d.skip()
+	})
+	if p.SecurityID == "" && code != "" {
+		p.SecurityID = code
+		d.variant = true
+	}
+	return err
+}
+
+// levels 解析档位数组，追加到复用的切片中
+func (d *quoteDecoder) levels(list *[]QuotePrice) error {
+	*list = (*list)[:0]
+	if d.null() {
+		return nil
+	}
+	return d.array(func() error {
+		*list = append(*list, QuotePrice{})
+		return d.level(&(*list)[len(*list)-1])
+	})
+}
+
+func (d *quoteDecoder) level(q *QuotePrice) error {
+	var code string
+	err := d.object(func(key string) error {
+		switch {
+		case fieldIs(key, "brokerId"):
+			return d.str(&q.BrokerID)
+		case fieldIs(key, "isTbd"):
+			return d.str(&q.IsTbd)
+		case fieldIs(key, "isValid"):
+			return d.str(&q.IsValid)
+		case fieldIs(key, "minTransQuantity"):
+			return d.float(&q.MinTransQuantity)
+		case fieldIs(key, "orderQty"):
+			return d.float(&q.OrderQty)
+		case fieldIs(key, "price"):
+			return d.float(&q.Price)
+		case fieldIs(key, "quoteOrderNo"):
+			return d.str(&q.QuoteOrderNo)
+		case fieldIs(key, "quoteTime"):
+			return d.millis(&q.QuoteTime)
+		case fieldIs(key, "securityId"):
+			return d.str(&q.SecurityID)
+		case fieldIs(key, "securityCode"):
+			return d.str(&code)
+		case fieldIs(key, "settleDate"):
+			return d.date(&q.SettleDate)
+		case fieldIs(key, "settleType"):
+			return d.str(&q.SettleType)
+		case fieldIs(key, "side"):
+			return d.str(&q.Side)
+		case fieldIs(key, "yield"):
+			return d.float(&q.Yield)
+		}
+		return d.skip()
+	})
+	if q.SecurityID == "" && code != "" {
+		q.SecurityID = code
+		d.variant = true
+	}
+	return err
+}
+
+// fieldIs 字段名匹配：与标准库一致，不区分大小写
+func fieldIs(key, name string) bool {
+	return key == name || strings.EqualFold(key, name)
+}
+
+// object 解析对象，逐个字段回调，回调负责读取字段值；null 视为空对象
+func (d *quoteDecoder) object(field func(key string) error) error {
+	if d.null() {
+		return nil
+	}
+	if !d.consume('{') {
+		return d.typeError("object")
+	}
+	if d.consume('}') {
+		return nil
+	}
+	for {
+		d.skipSpace()
+		if d.peek() != '"' {
+			return d.syntaxError("looking for beginning of object key string")
+		}
+		key, err := d.rawString()
+		if err != nil {
+			return err
+		}
+		if !d.consume(':') {
+			return d.syntaxError("after object key")
+		}
+		if err := field(key); err != nil {
+			return atPath(key, err)
+		}
+		if d.consume(',') {
+			continue
+		}
+		if d.consume('}') {
+			return nil
+		}
+		return d.syntaxError("after object key:value pair")
+	}
+}
+
+// array 解析数组，逐个元素回调
+func (d *quoteDecoder) array(elem func() error) error {
+	if !d.consume('[') {
+		return d.typeError("array")
+	}
+	if d.consume(']') {
+		return nil
+	}
+	for i := 0; ; i++ {
+		if err := elem(); err != nil {
+			return atPath("["+strconv.Itoa(i)+"]", err)
+		}
+		if d.consume(',') {
+			continue
+		}
+		if d.consume(']') {
+			return nil
+		}
+		return d.syntaxError("after array element")
+	}
+}
+
+// str 读取字符串字段，null 保留原值
+func (d *quoteDecoder) str(dst *string) error {
+	if d.null() {
+		return nil
+	}
+	d.skipSpace()
+	if d.peek() != '"' {
+		return d.typeError("string")
+	}
+	s, err := d.rawString()
+	if err != nil {
+		return err
+	}
+	if !d.owned {
+		s = strings.Clone(s)
+	}
+	*dst = s
+	return nil
+}
+
+func (d *quoteDecoder) int(dst *int64) error {
+	if d.null() {
+		return nil
+	}
+	num, err := d.number("int64")
+	if err != nil {
+		return err
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal number %s into int64", num)
+	}
+	*dst = v
+	return nil
+}
+
+func (d *quoteDecoder) float(dst *float64) error {
+	if d.null() {
+		return nil
+	}
+	num, err := d.number("float64")
+	if err != nil {
+		return err
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal number %s into float64", num)
+	}
+	*dst = v
+	return nil
+}
+
+// millis 读取时间：毫秒时间戳，或字符串形式的毫秒时间戳、ISO-8601 时间（如 2025-07-03T14:54:52.000Z）
+func (d *quoteDecoder) millis(dst *int64) error {
+	if d.null() {
+		return nil
+	}
+	d.skipSpace()
+	if d.peek() != '"' {
+		if c := d.peek(); c != '-' && (c < '0' || c > '9') {
+			return d.typeError("epoch millis or ISO-8601 time")
+		}
+		return d.int(dst)
+	}
+	s, err := d.rawString()
+	if err != nil {
+		return err
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*dst = v
+	} else if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		*dst = t.UnixMilli()
+	} else {
+		return fmt.Errorf("cannot parse time %q: want epoch millis or ISO-8601 time", s)
+	}
+	d.variant = true
+	return nil
+}
+
+// date 读取日期，规范为 2006-01-02；接受 2006-01-02、20060102 和 ISO-8601 时间（按业务日期时区取日期）
+func (d *quoteDecoder) date(dst *string) error {
+	if d.null() {
+		return nil
+	}
+	d.skipSpace()
+	if d.peek() != '"' {
+		return d.typeError("date string")
+	}
+	s, err := d.rawString()
+	if err != nil {
+		return err
+	}
+	switch {
+	case s == "":
+	case isDate(s):
+		if !d.owned {
+			s = strings.Clone(s)
+		}
+	default:
+		var t time.Time
+		if t, err = time.Parse("20060102", s); err != nil {
+			if t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("cannot parse date %q: want 2006-01-02", s)
+			}
+			t = t.In(BusinessLocation())
+		}
+		s = t.Format(time.DateOnly)
+		d.variant = true
+	}
+	*dst = s
+	return nil
+}
+
+// isDate 是否为 2006-01-02 格式的有效日期
+func isDate(s string) bool {
+	_, err := time.Parse(time.DateOnly, s)
+	return err == nil
+}
+
+// skip 校验并跳过任意值
+func (d *quoteDecoder) skip() error {
+	d.skipSpace()
+	switch c := d.peek(); {
+	case c == '{':
+		return d.object(func(string) error { return d.skip() })
+	case c == '[':
+		return d.array(d.skip)
+	case c == '"':
+		_, err := d.rawString()
+		return err
+	case c == '-' || (c >= '0' && c <= '9'):
+		_, err := d.number("")
+		return err
+	case d.literal("true"), d.literal("false"), d.literal("null"):
+		return nil
+	}
+	return d.syntaxError("looking for beginning of value")
+}
+
+// number 校验并返回数字字面量，typ 为期望的类型，为空时表示跳过未知字段
+func (d *quoteDecoder) number(typ string) (string, error) {
+	d.skipSpace()
+	start := d.pos
+	if d.peek() == '-' {
+		d.pos++
+	}
+	switch c := d.peek(); {
+	case c == '0':
+		d.pos++
+	case c >= '1' && c <= '9':
+		d.digits()
+	default:
+		if typ != "" && d.pos == start {
+			return "", d.typeError(typ)
+		}
+		return "", d.syntaxError("in numeric literal")
+	}
+	if d.peek() == '.' {
+		d.pos++
+		if !d.digits() {
+			return "", d.syntaxError("after decimal point in numeric literal")
+		}
+	}
+	if c := d.peek(); c == 'e' || c == 'E' {
+		d.pos++
+		if c := d.peek(); c == '+' || c == '-' {
+			d.pos++
+		}
+		if !d.digits() {
+			return "", d.syntaxError("in exponent of numeric literal")
+		}
+	}
+	return d.s[start:d.pos], nil
+}
+
+func (d *quoteDecoder) digits() bool {
+	start := d.pos
+	for d.pos < len(d.s) && d.s[d.pos] >= '0' && d.s[d.pos] <= '9' {
+		d.pos++
+	}
+	return d.pos > start
+}
+
+// rawString 读取字符串，没有转义时直接返回子串
+func (d *quoteDecoder) rawString() (string, error) {
+	d.pos++ // 开头的引号
+	start := d.pos
+	for d.pos < len(d.s) {
+		switch c := d.s[d.pos]; {
+		case c == '"':
+			d.pos++
+			return d.s[start : d.pos-1], nil
+		case c == '\\':
+			return d.unquote(start)
+		case c < 0x20:
+			return "", d.syntaxError("in string literal")
+		}
+		d.pos++
+	}
+	return "", errUnexpectedEnd
+}
+
+// unquote 处理含转义的字符串，从 start 开始，已读到第一个反斜杠
+func (d *quoteDecoder) unquote(start int) (string, error) {
+	var b strings.Builder
+	b.Grow(len(d.s) - start)
+	b.WriteString(d.s[start:d.pos])
+	for d.pos < len(d.s) {
+		c := d.s[d.pos]
+		switch {
+		case c == '"':
+			d.pos++
+			return b.String(), nil
+		case c < 0x20:
+			return "", d.syntaxError("in string literal")
+		case c != '\\':
+			b.WriteByte(c)
+			d.pos++
+			continue
+		}
+		d.pos++
+		if d.pos >= len(d.s) {
+			return "", errUnexpectedEnd
+		}
+		esc := d.s[d.pos]
+		d.pos++
+		switch esc {
+		case '"', '\\', '/':
+			b.WriteByte(esc)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			r, ok := d.hex4()
+			if !ok {
+				return "", d.syntaxError("in \\u hexadecimal character escape")
+			}
+			if utf16.IsSurrogate(r) {
+				// 代理对，后半部分缺失或无效时与标准库一样替换为 U+FFFD
+				r2 := utf8.RuneError
+				if strings.HasPrefix(d.s[d.pos:], `\u`) {
+					save := d.pos
+					d.pos += 2
+					if lo, ok := d.hex4(); ok {
+						if dec := utf16.DecodeRune(r, lo); dec != utf8.RuneError {
+							r2 = dec
+						} else {
+							d.pos = save
+						}
+					} else {
+						d.pos = save
+					}
+				}
+				r = r2
+			}
+			b.WriteRune(r)
+		default:
+			return "", d.syntaxError("in string escape code")
+		}
+	}
+	return "", errUnexpectedEnd
+}
+
+func (d *quoteDecoder) hex4() (rune, bool) {
+	if d.pos+4 > len(d.s) {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(d.s[d.pos:d.pos+4], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	d.pos += 4
+	return rune(v), true
+}
+
+func (d *quoteDecoder) skipSpace() {
+	for d.pos < len(d.s) {
+		switch d.s[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (d *quoteDecoder) peek() byte {
+	if d.pos < len(d.s) {
+		return d.s[d.pos]
+	}
+	return 0
+}
+
+// consume 跳过空白后读取期望的字符
+func (d *quoteDecoder) consume(c byte) bool {
+	d.skipSpace()
+	if d.peek() == c {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// literal 跳过空白后读取 true/false/null
+func (d *quoteDecoder) literal(lit string) bool {
+	d.skipSpace()
+	if strings.HasPrefix(d.s[d.pos:], lit) {
+		d.pos += len(lit)
+		return true
+	}
+	return false
+}
+
+func (d *quoteDecoder) null() bool {
+	return d.literal("null")
+}
+
+func (d *quoteDecoder) syntaxError(context string) error {
+	if d.pos >= len(d.s) {
+		return errUnexpectedEnd
+	}
+	return fmt.Errorf("invalid character %q %s at offset %d", d.s[d.pos], context, d.pos)
+}
+
+// typeError 字段值类型不符
+func (d *quoteDecoder) typeError(want string) error {
+	d.skipSpace()
+	var kind string
+	switch c := d.peek(); {
+	case c == '"':
+		kind = "string"
+	case c == '{':
+		kind = "object"
+	case c == '[':
+		kind = "array"
+	case c == 't' || c == 'f':
+		kind = "bool"
+	case c == '-' || (c >= '0' && c <= '9'):
+		kind = "number"
+	default:
+		return d.syntaxError("looking for beginning of value")
+	}
+	return fmt.Errorf("cannot unmarshal %s into %s", kind, want)
+}
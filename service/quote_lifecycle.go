@@ -0,0 +1,251 @@
+package service
+
+// 报价生命周期跟踪
+// 明细表把每条行情的每个档位独立保存，无法回答"这笔报价何时出现、何时改价、何时撤销"。
+// 跟踪器按ISIN保存上一次的订单簿，每条新行情与之比对，按报价单号（和方向）生成事件：
+// - NEW：新出现的有效报价
+// - AMEND：价格、收益率或数量变化
+// - CANCEL：报价从订单簿中消失，或变为无效（isValid=N）
+// 事件按消息的业务日期写入 t_bond_quote_event_*，比已处理的旧的行情不产生事件。
+// 写入成功后才更新保存的订单簿，写入失败重试时按同一前一状态重新比对，重复写入按唯一索引跳过。
+// 创建时从当前业务日期的最新行情表恢复各ISIN的订单簿，重启后不会把已有报价全部记为 NEW。
+// 保存的订单簿只属于一个业务日期：收到下一业务日期的行情时清空，新业务日期的第一条行情全部记为 NEW；
+// 迟到的上一业务日期行情不产生事件。
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"wealth-bond-quote-service/model"
+)
+
+// trackedBook 跟踪器保存的订单簿，只含有效档位
+type trackedBook struct {
+	day       time.Time // 业务日期
+	sendTime  int64
+	timestamp int64
+	keys      []string // 档位在消息中的顺序
+	levels    map[string]QuotePrice
+}
+
+// levelKey 档位的跟踪键：方向 + 报价单号
+func levelKey(q *QuotePrice) string {
+	return q.Side + "|" + q.QuoteOrderNo
+}
+
+func newTrackedBook(sendTime, timestamp int64, payload *QuotePriceData) *trackedBook {
+	b := &trackedBook{sendTime: sendTime, timestamp: timestamp, levels: make(map[string]QuotePrice)}
+	for _, levels := range [][]QuotePrice{payload.AskPrices, payload.BidPrices} {
+		for _, q := range levels {
+			// 没有报价单号的档位无法跟踪
+			if q.QuoteOrderNo == "" || q.IsValid == "N" {
+				continue
+			}
+			k := levelKey(&q)
+			if _, ok := b.levels[k]; !ok {
+				b.keys = append(b.keys, k)
+			}
+			b.levels[k] = q
+		}
+	}
+	return b
+}
+
+// diffLifecycle 比对前后两次订单簿生成事件，prev 为空时全部有效报价记为 NEW
+func diffLifecycle(meta *BondQuoteMessage, isin string, prev, cur *trackedBook) []model.BondQuoteEvent {
+	eventTime := time.UnixMilli(meta.SendTime)
+	var events []model.BondQuoteEvent
+	for _, k := range cur.keys {
+		q := cur.levels[k]
+		var p QuotePrice
+		ok := false
+		if prev != nil {
+			p, ok = prev.levels[k]
+		}
+		switch {
+		case !ok:
+			events = append(events, newQuoteEvent(meta, isin, model.QuoteEventNew, nil, &q, eventTime))
+		case p.Price != q.Price || p.Yield != q.Yield || p.OrderQty != q.OrderQty:
+			events = append(events, newQuoteEvent(meta, isin, model.QuoteEventAmend, &p, &q, eventTime))
+		}
+	}
+	if prev == nil {
+		return events
+	}
+	for _, k := range prev.keys {
+		if _, ok := cur.levels[k]; ok {
+			continue
+		}
+		p := prev.levels[k]
+		events = append(events, newQuoteEvent(meta, isin, model.QuoteEventCancel, &p, nil, eventTime))
+	}
+	return events
+}
+
+// newQuoteEvent 生成事件，prev/cur 为变化前后的档位，NEW 时 prev 为空，CANCEL 时 cur 为空
+func newQuoteEvent(meta *BondQuoteMessage, isin, eventType string, prev, cur *QuotePrice, eventTime time.Time) model.BondQuoteEvent {
+	q := cur
+	if q == nil {
+		q = prev
+	}
+	e := model.BondQuoteEvent{
+		MessageID:    meta.Data.MessageID,
+		QuoteOrderNo: q.QuoteOrderNo,
+		Side:         q.Side,
+		EventType:    eventType,
+		ISIN:         isin,
+		BrokerID:     q.BrokerID,
+		QuoteTime:    time.UnixMilli(q.QuoteTime),
+		EventTime:    eventTime,
+		CreateTime:   time.Now(),
+	}
+	if prev != nil {
+		e.PrevPrice, e.PrevYield, e.PrevOrderQty = &prev.Price, &prev.Yield, &prev.OrderQty
+	}
+	if cur != nil {
+		e.Price, e.Yield, e.OrderQty = &cur.Price, &cur.Yield, &cur.OrderQty
+	}
+	return e
+}
+
+// QuoteLifecycleTracker 报价生命周期跟踪，实现 QuoteSink 接入写库层
+// 写库层按ISIN分配写库协程，同一ISIN的行情按顺序到达同一协程
+type QuoteLifecycleTracker struct {
+	name string
+	db   *gorm.DB
+
+	mu     sync.Mutex
+	day    time.Time // 保存的订单簿所属的业务日期
+	books  map[string]*trackedBook
+	tables map[string]bool // 已确认存在的日期
+}
+
+// NewQuoteLifecycleTracker 创建报价生命周期跟踪
+func NewQuoteLifecycleTracker(name string, conn *gorm.DB) *QuoteLifecycleTracker {
+	return &QuoteLifecycleTracker{
+		name:   name,
+		db:     conn.Session(&gorm.Session{SkipDefaultTransaction: true}),
+		books:  make(map[string]*trackedBook),
+		tables: make(map[string]bool),
+	}
+}
+
+// LoadLatest 从指定时刻所属业务日期的最新行情表恢复各ISIN的订单簿，返回恢复的数量，需在写入前调用
+func (t *QuoteLifecycleTracker) LoadLatest(at time.Time) (int, error) {
+	day := BusinessDate(at)
+	table := GetLatestTableName(day)
+	if !t.db.Migrator().HasTable(table) {
+		return 0, nil
+	}
+	var quotes []model.BondLatestQuote
+	if err := t.db.Table(table).Find(&quotes).Error; err != nil {
+		return 0, fmt.Errorf("查询最新行情失败: %w", err)
+	}
+	books := latestQuotesToBooks(quotes)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.day = day
+	for _, b := range books {
+		payload := QuotePriceData{SecurityID: b.ISIN, AskPrices: b.Asks, BidPrices: b.Bids}
+		tb := newTrackedBook(b.SendTime, b.Timestamp, &payload)
+		tb.day = day
+		t.books[b.ISIN] = tb
+	}
+	return len(books), nil
+}
+
+func (t *QuoteLifecycleTracker) Name() string { return t.name }
+
+func (t *QuoteLifecycleTracker) Write(batch []*ParsedQuote) error {
+	// 按前一状态比对，批内同一ISIN的多条行情依次比对
+	pending := make(map[string]*trackedBook)
+	var days []time.Time
+	events := make(map[string][]model.BondQuoteEvent)
+	t.mu.Lock()
+	for _, pq := range batch {
+		date := quoteBusinessDate(pq)
+		switch {
+		case date.Before(t.day):
+			// 上一业务日期的订单簿已清空，无法比对
+			continue
+		case date.After(t.day):
+			clear(t.books)
+			t.day = date
+		}
+		isin := pq.Payload.SecurityID
+		prev, ok := pending[isin]
+		if !ok {
+			prev = t.books[isin]
+		}
+		if prev != nil && prev.day.Before(date) {
+			prev = nil
+		}
+		if prev != nil && !newerQuote(pq.Meta.SendTime, pq.Meta.Data.Timestamp, prev.sendTime, prev.timestamp) {
+			continue
+		}
+		cur := newTrackedBook(pq.Meta.SendTime, pq.Meta.Data.Timestamp, &pq.Payload)
+		cur.day = date
+		pending[isin] = cur
+
+		day := date.Format("20060102")
+		if _, ok := events[day]; !ok {
+			days = append(days, date)
+		}
+		events[day] = append(events[day], diffLifecycle(&pq.Meta, isin, prev, cur)...)
+	}
+	t.mu.Unlock()
+
+	if err := t.ensureTables(days); err != nil {
+		return err
+	}
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		for _, day := range days {
+			list := events[day.Format("20060102")]
+			if len(list) == 0 {
+				continue
+			}
+			if err := tx.Table(GetEventTableName(day)).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(list, 1000).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		if isTableMissing(err) {
+			// 表被删除或尚未创建，重试时重新建表
+			clear(t.tables)
+		}
+		return err
+	}
+	for isin, b := range pending {
+		if b.day.Equal(t.day) {
+			t.books[isin] = b
+		}
+	}
+	return nil
+}
+
+// ensureTables 确保涉及日期的表存在
+func (t *QuoteLifecycleTracker) ensureTables(days []time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, d := range days {
+		day := d.Format("20060102")
+		if t.tables[day] {
+			continue
+		}
+		if err := NewCreateTableService(t.db).EnsureDailyTablesExist(d); err != nil {
+			return err
+		}
+		t.tables[day] = true
+	}
+	return nil
+}
+
+func (t *QuoteLifecycleTracker) Close() error { return nil }
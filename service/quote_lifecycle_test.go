@@ -0,0 +1,172 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/model"
+)
+
+func TestQuoteLifecycleTracker(t *testing.T) {
+	conn := newTestDB(t)
+	eventTable := GetEventTableName(time.Now())
+	base := time.Now().Truncate(time.Second)
+	quote := func(id string, sent time.Time, bids, asks []atsmock.Level, invalid string) *ParsedQuote {
+		pq, err := ParseBondQuote(invalidateLevel(t, atsmock.OrderBookMessage(id, "HK0000000001", sent, bids, asks), invalid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pq.ReceivedAt = time.Now()
+		return pq
+	}
+	bid := func(no string, price, qty float64) atsmock.Level {
+		return atsmock.Level{QuoteOrderNo: no, BrokerID: "B1", Price: price, Yield: 4, OrderQty: qty}
+	}
+	events := func() string {
+		t.Helper()
+		var list []model.BondQuoteEvent
+		if err := conn.Table(eventTable).Order("id").Find(&list).Error; err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range list {
+			got = append(got, e.MessageID+":"+e.QuoteOrderNo+":"+e.EventType)
+		}
+		return strings.Join(got, " ")
+	}
+
+	tracker := NewQuoteLifecycleTracker("lifecycle", conn)
+	db := NewDBSink("bond", conn)
+	steps := []struct {
+		pq   *ParsedQuote
+		want string
+	}{
+		{quote("M1", base, []atsmock.Level{bid("B1", 100, 1e6), bid("B2", 99, 2e6)}, []atsmock.Level{bid("A1", 101, 1e6)}, ""),
+			"M1:A1:NEW M1:B1:NEW M1:B2:NEW"},
+		// 改价、撤销、新增，未变化的不产生事件
+		{quote("M2", base.Add(2*time.Second), []atsmock.Level{bid("B1", 100.2, 1e6)}, []atsmock.Level{bid("A1", 101, 1e6), bid("A2", 101.5, 1e6)}, ""),
+			"M1:A1:NEW M1:B1:NEW M1:B2:NEW M2:A2:NEW M2:B1:AMEND M2:B2:CANCEL"},
+		// 迟到的旧行情不比对
+		{quote("M3", base.Add(time.Second), nil, nil, ""), "M1:A1:NEW M1:B1:NEW M1:B2:NEW M2:A2:NEW M2:B1:AMEND M2:B2:CANCEL"},
+		// 变为无效视为撤销
+		{quote("M4", base.Add(3*time.Second), []atsmock.Level{bid("B1", 100.2, 1e6)}, []atsmock.Level{bid("A1", 101, 1e6), bid("A2", 101.5, 1e6)}, "A1"),
+			"M1:A1:NEW M1:B1:NEW M1:B2:NEW M2:A2:NEW M2:B1:AMEND M2:B2:CANCEL M4:A1:CANCEL"},
+	}
+	for i, st := range steps {
+		if err := tracker.Write([]*ParsedQuote{st.pq}); err != nil {
+			t.Fatalf("第%d步写入失败: %v", i+1, err)
+		}
+		if err := db.Write([]*ParsedQuote{st.pq}); err != nil {
+			t.Fatal(err)
+		}
+		if got := events(); got != st.want {
+			t.Fatalf("第%d步事件 = %s, 期望 %s", i+1, got, st.want)
+		}
+	}
+
+	// 写入失败时不推进状态，恢复后按同一前一状态重新比对
+	breakTable(t, conn, eventTable)
+	m5 := quote("M5", base.Add(4*time.Second), []atsmock.Level{bid("B1", 100.2, 3e6)}, []atsmock.Level{bid("A2", 101.5, 1e6)}, "")
+	if err := tracker.Write([]*ParsedQuote{m5}); err == nil {
+		t.Fatal("事件表损坏时应写入失败")
+	}
+	if err := conn.Migrator().DropTable(eventTable); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewCreateTableService(conn).EnsureDailyTablesExist(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Write([]*ParsedQuote{m5}); err != nil {
+		t.Fatal(err)
+	}
+	if got := events(); got != "M5:B1:AMEND" {
+		t.Fatalf("重试后事件 = %s", got)
+	}
+
+	// 重启后从最新行情表恢复，已有报价不再记为 NEW，重复的事件按唯一索引跳过
+	restarted := NewQuoteLifecycleTracker("lifecycle", conn)
+	if n, err := restarted.LoadLatest(time.Now()); err != nil || n != 1 {
+		t.Fatalf("恢复 %d 个, err = %v", n, err)
+	}
+	if err := restarted.Write([]*ParsedQuote{m5}); err != nil {
+		t.Fatal(err)
+	}
+	if got := events(); got != "M5:B1:AMEND" {
+		t.Fatalf("重启后事件 = %s", got)
+	}
+
+	// 按报价单号查询生命周期
+	history, err := NewBondQueryService(conn).QuoteOrderHistory("B1", DateRangeParam{})
+	if err != nil || len(history) != 1 {
+		t.Fatalf("报价历史 = %+v, err = %v", history, err)
+	}
+	if e := history[0]; e.EventType != model.QuoteEventAmend || *e.PrevOrderQty != 1e6 || *e.OrderQty != 3e6 || *e.Price != 100.2 || e.ISIN != "HK0000000001" {
+		t.Fatalf("报价事件 = %+v", e)
+	}
+}
+
+func TestQuoteLifecycleRollover(t *testing.T) {
+	cal, err := calendar.New(&config.CalendarConfig{Timezone: "Asia/Hong_Kong", CutoffTime: "17:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBusinessCalendar(cal)
+	defer SetBusinessCalendar(nil)
+	conn := newTestDB(t)
+	friday := time.Date(2025, 9, 26, 0, 0, 0, 0, cal.Location())
+	monday := friday.AddDate(0, 0, 3)
+	quote := func(id string, sent time.Time, levels ...atsmock.Level) *ParsedQuote {
+		pq, err := ParseBondQuote(atsmock.OrderBookMessage(id, "HK0000000001", sent, levels, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pq.ReceivedAt = sent
+		return pq
+	}
+	events := func(day time.Time) string {
+		t.Helper()
+		var list []model.BondQuoteEvent
+		if err := conn.Table(GetEventTableName(day)).Order("id").Find(&list).Error; err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range list {
+			got = append(got, e.MessageID+":"+e.QuoteOrderNo+":"+e.EventType)
+		}
+		return strings.Join(got, " ")
+	}
+
+	tracker := NewQuoteLifecycleTracker("lifecycle", conn)
+	b1 := atsmock.Level{QuoteOrderNo: "B1", Price: 100, OrderQty: 1e6}
+	b2 := atsmock.Level{QuoteOrderNo: "B2", Price: 99, OrderQty: 1e6}
+	batch := []*ParsedQuote{
+		quote("M1", friday.Add(16*time.Hour), b1, b2),
+		// 日切后属于下周一：与周五的订单簿无关，全部记为 NEW，不产生改价和撤销
+		quote("M2", friday.Add(17*time.Hour+10*time.Minute), atsmock.Level{QuoteOrderNo: "B1", Price: 100.5, OrderQty: 1e6}),
+	}
+	if err := tracker.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	// 迟到的周五行情不产生事件
+	if err := tracker.Write([]*ParsedQuote{quote("M3", friday.Add(16*time.Hour+30*time.Minute), b1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := events(friday); got != "M1:B1:NEW M1:B2:NEW" {
+		t.Fatalf("周五事件 = %s", got)
+	}
+	if got := events(monday); got != "M2:B1:NEW" {
+		t.Fatalf("周一事件 = %s", got)
+	}
+
+	// 同一业务日期内继续比对
+	if err := tracker.Write([]*ParsedQuote{quote("M4", monday.Add(9*time.Hour), b1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := events(monday); got != "M2:B1:NEW M4:B1:AMEND" {
+		t.Fatalf("周一事件 = %s", got)
+	}
+}
@@ -0,0 +1,306 @@
+package service
+
+// 行情写入目标
+// 写库层把解析后的行情按批交给 QuoteSink，可以同时配置多个目标（例如迁移期间双写），
+// 每个目标有独立的分发、批量和错误处理：
+// - 必需的目标全部写入成功后才确认消息，任一失败则拒绝等待重新投递
+// - 非必需的目标写入失败只记录日志，积压时丢弃，不影响必需目标
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/pkg/db"
+	logger "wealth-bond-quote-service/pkg/log"
+
+	"gorm.io/gorm"
+)
+
+// 写入目标类型
+const (
+	SinkMySQL  = "mysql"
+	SinkSQLite = "sqlite"
+	SinkJSONL  = "jsonl"
+	SinkMemory = "memory"
+	// SinkLifecycle 报价生命周期事件，写入MySQL的事件表，不替代 mysql 目标
+	SinkLifecycle = "lifecycle"
+)
+
+// QuoteSink 行情写入目标，Write 可能被多个写库协程并发调用
+type QuoteSink interface {
+	Name() string
+	Write(batch []*ParsedQuote) error
+	Close() error
+}
+
+// NewQuoteSink 按配置创建写入目标，mysql 类型使用传入的连接
+func NewQuoteSink(cfg *config.SinkConfig, mysqlDB *gorm.DB) (QuoteSink, error) {
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Type
+	}
+	switch cfg.Type {
+	case SinkMySQL:
+		if mysqlDB == nil {
+			return nil, fmt.Errorf("写入目标%s: MySQL连接不可用", name)
+		}
+		return NewDBSink(name, mysqlDB), nil
+	case SinkSQLite:
+		return NewSQLiteSink(name, cfg.Path)
+	case SinkJSONL:
+		return NewJSONLSink(name, cfg.Path)
+	case SinkMemory:
+		return NewMemorySink(name), nil
+	case SinkLifecycle:
+		if mysqlDB == nil {
+			return nil, fmt.Errorf("写入目标%s: MySQL连接不可用", name)
+		}
+		t := NewQuoteLifecycleTracker(name, mysqlDB)
+		n, err := t.LoadLatest(time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("写入目标%s: 恢复订单簿失败: %w", name, err)
+		}
+		logger.Info("写入目标%s: 从最新行情表恢复订单簿 %d 个", name, n)
+		return t, nil
+	default:
+		return nil, fmt.Errorf("写入目标%s: 类型%q无效", name, cfg.Type)
+	}
+}
+
+// DBSink 写入按日分表的数据库（MySQL 或 SQLite），首次写入某天时确保当天的表存在
+type DBSink struct {
+	name  string
+	db    *gorm.DB
+	owned bool // 连接由本目标打开，关闭时一并关闭
+
+	mu     sync.Mutex
+	tables map[string]bool // 已确认存在的日期
+
+	duplicates atomic.Int64
+	stale      atomic.Int64
+}
+
+// NewDBSink 创建数据库写入目标
+func NewDBSink(name string, conn *gorm.DB) *DBSink {
+	return &DBSink{
+		name:   name,
+		db:     conn.Session(&gorm.Session{SkipDefaultTransaction: true, PrepareStmt: true}),
+		tables: make(map[string]bool),
+	}
+}
+
+// NewSQLiteSink 创建SQLite写入目标，无需MySQL即可运行完整流水线
+func NewSQLiteSink(name, path string) (*DBSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("写入目标%s: SQLite需要配置path", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("写入目标%s: 创建目录失败: %w", name, err)
+	}
+	conn, err := db.InitSqliteConn(path)
+	if err != nil {
+		return nil, fmt.Errorf("写入目标%s: 打开SQLite失败: %w", name, err)
+	}
+	s := NewDBSink(name, conn)
+	s.owned = true
+	return s, nil
+}
+
+func (s *DBSink) Name() string { return s.name }
+
+func (s *DBSink) Write(batch []*ParsedQuote) error {
+	if err := s.ensureTables(batch); err != nil {
+		return err
+	}
+	res, err := insertBatch(s.db, batch)
+	if err == nil {
+		s.duplicates.Add(res.Duplicates)
+		s.stale.Add(res.Stale)
+	} else if isTableMissing(err) {
+		// 表被删除或尚未创建，重试时重新建表
+		s.mu.Lock()
+		clear(s.tables)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Duplicates 返回因已存在而跳过的明细行数
+func (s *DBSink) Duplicates() int64 { return s.duplicates.Load() }
+
+// Stale 返回比已保存的旧而未覆盖的最新行情数
+func (s *DBSink) Stale() int64 { return s.stale.Load() }
+
+// ensureTables 确保批次涉及日期的表存在
+func (s *DBSink) ensureTables(batch []*ParsedQuote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pq := range batch {
+		date := quoteBusinessDate(pq)
+		day := date.Format("20060102")
+		if s.tables[day] {
+			continue
+		}
+		if err := NewCreateTableService(s.db).EnsureDailyTablesExist(date); err != nil {
+			return err
+		}
+		s.tables[day] = true
+	}
+	return nil
+}
+
+func (s *DBSink) Close() error {
+	if !s.owned {
+		return nil
+	}
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// jsonlQuote JSON-lines 文件中的一行
+type jsonlQuote struct {
+	ReceivedAt time.Time        `json:"receivedAt"`
+	Meta       BondQuoteMessage `json:"meta"`
+	Payload    QuotePriceData   `json:"payload"`
+}
+
+// JSONLSink 按接收日期写入 JSON-lines 文件（quotes_20060102.jsonl），每批写入后刷盘
+type JSONLSink struct {
+	name string
+	dir  string
+
+	mu    sync.Mutex
+	day   string
+	file  *os.File
+	lines []byte
+}
+
+// NewJSONLSink 创建 JSON-lines 文件写入目标
+func NewJSONLSink(name, dir string) (*JSONLSink, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("写入目标%s: JSON-lines需要配置path", name)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("写入目标%s: 创建目录失败: %w", name, err)
+	}
+	return &JSONLSink{name: name, dir: dir}, nil
+}
+
+func (s *JSONLSink) Name() string { return s.name }
+
+func (s *JSONLSink) Write(batch []*ParsedQuote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pq := range batch {
+		received := pq.ReceivedAt
+		if received.IsZero() {
+			received = time.Now()
+		}
+		if err := s.rotate(received.Format("20060102")); err != nil {
+			return err
+		}
+		line, err := json.Marshal(jsonlQuote{ReceivedAt: received, Meta: pq.Meta, Payload: pq.Payload})
+		if err != nil {
+			return err
+		}
+		s.lines = append(append(s.lines, line...), '\n')
+	}
+	return s.flush()
+}
+
+// rotate 切换到指定日期的文件，调用方需持有锁
+func (s *JSONLSink) rotate(day string) error {
+	if s.file != nil && s.day == day {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, "quotes_"+day+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.file, s.day = f, day
+	return nil
+}
+
+// flush 写入缓冲的行并刷盘，调用方需持有锁
+func (s *JSONLSink) flush() error {
+	if len(s.lines) == 0 {
+		return nil
+	}
+	_, err := s.file.Write(s.lines)
+	s.lines = s.lines[:0]
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// MemorySink 保存在内存中的写入目标，用于测试
+type MemorySink struct {
+	name string
+
+	mu     sync.Mutex
+	quotes []*ParsedQuote
+	err    error
+}
+
+// NewMemorySink 创建内存写入目标
+func NewMemorySink(name string) *MemorySink {
+	return &MemorySink{name: name}
+}
+
+func (s *MemorySink) Name() string { return s.name }
+
+func (s *MemorySink) Write(batch []*ParsedQuote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	// 行情对象在写入后归还对象池，保存副本
+	for _, pq := range batch {
+		s.quotes = append(s.quotes, pq.Clone())
+	}
+	return nil
+}
+
+func (s *MemorySink) Close() error { return nil }
+
+// SetError 设置后续写入返回的错误，nil 表示恢复正常
+func (s *MemorySink) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Quotes 返回已写入的行情
+func (s *MemorySink) Quotes() []*ParsedQuote {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*ParsedQuote(nil), s.quotes...)
+}
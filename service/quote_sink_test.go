@@ -0,0 +1,125 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+// waitAcks 等待确认句柄被调用
+func waitAcks(t *testing.T, a *countingAck, acks, nacks int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if a.acks.Load() == acks && a.nacks.Load() == nacks {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("ACK %d/NACK %d, 期望 %d/%d", a.acks.Load(), a.nacks.Load(), acks, nacks)
+}
+
+func TestMultipleQuoteSinks(t *testing.T) {
+	dir := t.TempDir()
+	primary := NewMemorySink("memory")
+	sqlite, err := NewQuoteSink(&config.SinkConfig{Type: SinkSQLite, Path: filepath.Join(dir, "db", "bond.db")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonl, err := NewQuoteSink(&config.SinkConfig{Type: SinkJSONL, Name: "archive", Path: filepath.Join(dir, "jsonl")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := NewMemorySink("broken")
+	broken.SetError(errors.New("目标库维护中"))
+	if _, err := NewQuoteSink(&config.SinkConfig{Type: SinkMySQL}, nil); err == nil {
+		t.Fatal("没有MySQL连接时应返回错误")
+	}
+
+	var wg sync.WaitGroup
+	parsedChan := make(chan *ParsedQuote, 10)
+	bqs := NewBondQuoteService(nil, &wg, nil, parsedChan, nil)
+	bqs.AddSink(primary, SinkOptions{Required: true})
+	bqs.AddSink(sqlite, SinkOptions{Required: true, WorkerNum: 1})
+	bqs.AddSink(jsonl, SinkOptions{FlushDelay: 50 * time.Millisecond})
+	bqs.AddSink(broken, SinkOptions{})
+	bqs.StartDBWorkers(2, 10, 20*time.Millisecond)
+
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	send := func(id, isin string, received time.Time) *countingAck {
+		pq, err := ParseBondQuote(atsmock.OrderBookMessage(id, isin, received, level, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ack := &countingAck{}
+		pq.ReceivedAt, pq.Ack = received, ack
+		parsedChan <- pq
+		return ack
+	}
+
+	// 非必需目标失败不影响确认；SQLite 目标自动创建当天和昨天的表
+	yesterday := time.Now().AddDate(0, 0, -1)
+	acks := []*countingAck{
+		send("S1", "HK0000000001", time.Now()),
+		send("S2", "HK0000000002", time.Now()),
+		send("S3", "HK0000000001", yesterday),
+	}
+	for _, a := range acks {
+		waitAcks(t, a, 1, 0)
+	}
+
+	// 必需目标失败时拒绝消息
+	primary.SetError(errors.New("写入失败"))
+	waitAcks(t, send("S4", "HK0000000003", time.Now()), 0, 1)
+
+	// S4 在 SQLite 中写入成功，只是整体未确认
+	conn := sqlite.(*DBSink).db
+	waitRows(t, conn, GetDetailTableName(time.Now()), 3)
+	waitRows(t, conn, GetDetailTableName(yesterday), 1)
+
+	close(parsedChan)
+	wg.Wait()
+
+	if got := len(primary.Quotes()); got != 3 {
+		t.Fatalf("内存目标写入 %d 条", got)
+	}
+	counts := bqs.Counts()
+	if counts.Written != 3 || counts.Failed != 1 {
+		t.Fatalf("计数 = %+v", counts)
+	}
+
+	stats := map[string]SinkStats{}
+	for _, st := range bqs.SinkStats() {
+		stats[st.Name] = st
+	}
+	if st := stats["sqlite"]; !st.Required || st.Written != 4 {
+		t.Fatalf("SQLite 统计 = %+v", st)
+	}
+	if st := stats["broken"]; st.Required || st.Failed != 4 || st.Written != 0 {
+		t.Fatalf("失败目标统计 = %+v", st)
+	}
+
+	// JSON-lines 按接收日期分文件
+	lines := 0
+	files, _ := filepath.Glob(filepath.Join(dir, "jsonl", "quotes_*.jsonl"))
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for sc := bufio.NewScanner(f); sc.Scan(); {
+			lines++
+		}
+		f.Close()
+	}
+	if len(files) != 2 || lines != 4 {
+		t.Fatalf("JSON-lines 文件 %v 共 %d 行", files, lines)
+	}
+}
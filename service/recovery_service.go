@@ -0,0 +1,297 @@
+package service
+
+// 重连后的行情修正
+// 断线期间的报价变化不会补推，最新行情表会一直停留在断线前的状态。每次订阅成功后：
+// 1. 把当天最新行情全部标记为 UNCONFIRMED（推送写入时恢复为 CONFIRMED）
+// 2. 配置了快照接口时，拉取全量报价快照，与仍未确认的最新行情逐个比对：
+//    - 快照中新增或变化的档位写入修正明细（source=SNAPSHOT）
+//    - 快照中已不存在的档位写入 is_valid=N 的修正明细
+//    - 最新行情替换为快照并标记为 CONFIRMED
+// 3. 比对期间已被推送确认的债券以推送为准，不再修正
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"wealth-bond-quote-service/model"
+)
+
+const (
+	snapshotMessageType   = "ORDER_BOOK_SNAPSHOT" // 快照修正的消息类型
+	snapshotWsMessageType = "ATS_SNAPSHOT"
+)
+
+// ErrSnapshotUnavailable 未配置快照接口
+var ErrSnapshotUnavailable = errors.New("未配置报价快照接口")
+
+// OrderBookSnapshotRequest 全量报价快照请求
+type OrderBookSnapshotRequest struct {
+	SecurityIDs []string `json:"securityIds,omitempty"` // 为空表示全部债券
+}
+
+// RecoveryResult 一次修正的统计
+type RecoveryResult struct {
+	Books       int // 参与比对的债券数（快照 ∪ 未确认）
+	Corrected   int // 按快照修正的债券数
+	Skipped     int // 比对期间已被推送确认而跳过的债券数
+	Corrections int // 写入的修正明细行数
+}
+
+// QuoteRecovery 重连后的行情修正
+type QuoteRecovery struct {
+	db           *gorm.DB
+	snapshotPath string
+	books        *OrderBookStore // 内存订单簿，与最新行情表同步标记和修正
+}
+
+// NewQuoteRecovery 创建行情修正服务，snapshotPath 为空时只标记未确认
+func NewQuoteRecovery(db *gorm.DB, snapshotPath string) *QuoteRecovery {
+	return &QuoteRecovery{db: db, snapshotPath: snapshotPath}
+}
+
+// SetOrderBooks 设置内存订单簿，标记未确认和快照修正时一并更新
+func (r *QuoteRecovery) SetOrderBooks(books *OrderBookStore) {
+	r.books = books
+}
+
+// HasSnapshot 是否配置了快照接口
+func (r *QuoteRecovery) HasSnapshot() bool {
+	return r.snapshotPath != ""
+}
+
+// MarkUnconfirmed 把指定时刻所属业务日期的最新行情标记为未确认，返回标记的行数
+func (r *QuoteRecovery) MarkUnconfirmed(at time.Time) (int64, error) {
+	if r.books != nil {
+		r.books.MarkUnconfirmed()
+	}
+	table := GetLatestTableName(BusinessDate(at))
+	if !r.db.Migrator().HasTable(table) {
+		return 0, nil
+	}
+	res := r.db.Table(table).
+		Where("status = ?", model.QuoteStatusConfirmed).
+		Update("status", model.QuoteStatusUnconfirmed)
+	return res.RowsAffected, res.Error
+}
+
+// Recover 拉取全量报价快照并修正当天的最新行情
+func (r *QuoteRecovery) Recover(ctx context.Context, api *AtsAPIClient, token string) (RecoveryResult, error) {
+	if !r.HasSnapshot() {
+		return RecoveryResult{}, ErrSnapshotUnavailable
+	}
+	books, err := CallAts[OrderBookSnapshotRequest, []QuotePriceData](ctx, api, r.snapshotPath, token, OrderBookSnapshotRequest{})
+	if err != nil {
+		return RecoveryResult{}, fmt.Errorf("拉取报价快照失败: %w", err)
+	}
+	return r.Reconcile(time.Now(), books)
+}
+
+// Reconcile 用快照修正指定时刻所属业务日期的最新行情
+// 快照视为全量：未确认且不在快照中的债券表示已无报价
+func (r *QuoteRecovery) Reconcile(at time.Time, books []QuotePriceData) (RecoveryResult, error) {
+	day := BusinessDate(at)
+	latestTable := GetLatestTableName(day)
+
+	snapshot := make(map[string]*QuotePriceData, len(books))
+	for i := range books {
+		if books[i].SecurityID != "" {
+			snapshot[books[i].SecurityID] = &books[i]
+		}
+	}
+
+	var unconfirmed []model.BondLatestQuote
+	if err := r.db.Table(latestTable).Where("status = ?", model.QuoteStatusUnconfirmed).Find(&unconfirmed).Error; err != nil {
+		return RecoveryResult{}, fmt.Errorf("查询未确认行情失败: %w", err)
+	}
+	previous := make(map[string]*model.BondLatestQuote, len(unconfirmed))
+	for i := range unconfirmed {
+		previous[unconfirmed[i].ISIN] = &unconfirmed[i]
+	}
+
+	isins := make([]string, 0, len(snapshot)+len(previous))
+	for isin := range snapshot {
+		isins = append(isins, isin)
+	}
+	for isin := range previous {
+		if _, ok := snapshot[isin]; !ok {
+			isins = append(isins, isin)
+		}
+	}
+	sort.Strings(isins)
+
+	result := RecoveryResult{Books: len(isins)}
+	for _, isin := range isins {
+		book := snapshot[isin]
+		if book == nil {
+			book = &QuotePriceData{SecurityID: isin}
+		}
+		n, applied, err := r.reconcileOne(at, day, book, previous[isin])
+		if err != nil {
+			return result, fmt.Errorf("修正 %s 失败: %w", isin, err)
+		}
+		if !applied {
+			result.Skipped++
+			continue
+		}
+		result.Corrected++
+		result.Corrections += n
+	}
+	return result, nil
+}
+
+// reconcileOne 修正单个债券，写入业务日期 day 的最新行情表和明细表，返回写入的修正明细行数和是否已应用
+// 最新行情的替换以"仍未确认"为条件，与推送写入并发时以推送为准
+func (r *QuoteRecovery) reconcileOne(at, day time.Time, book *QuotePriceData, prev *model.BondLatestQuote) (int, bool, error) {
+	millis := at.UnixMilli()
+	sent := snapshotSendTime(book, prev)
+	inner, err := json.Marshal(book)
+	if err != nil {
+		return 0, false, err
+	}
+	meta := BondQuoteMessage{
+		Data: BondQuoteData{
+			QuotePriceData: string(inner),
+			MessageID:      fmt.Sprintf("SNAPSHOT-%s-%d", book.SecurityID, millis),
+			MessageType:    snapshotMessageType,
+			Timestamp:      sent,
+		},
+		SendTime:      sent,
+		WsMessageType: snapshotWsMessageType,
+	}
+	rawJSON, err := json.Marshal(meta)
+	if err != nil {
+		return 0, false, err
+	}
+
+	corrections := diffBook(&meta, book, previousBook(prev))
+	latest := model.BondLatestQuote{
+		ISIN:           book.SecurityID,
+		RawJSON:        string(rawJSON),
+		MessageID:      meta.Data.MessageID,
+		MessageType:    meta.Data.MessageType,
+		SendTime:       meta.SendTime,
+		Timestamp:      meta.Data.Timestamp,
+		LastUpdateTime: at,
+		Status:         model.QuoteStatusConfirmed,
+	}
+
+	applied := false
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var res *gorm.DB
+		if prev != nil {
+			res = tx.Table(GetLatestTableName(day)).
+				Where("isin = ? AND status = ?", book.SecurityID, model.QuoteStatusUnconfirmed).
+				Select("raw_json", "message_id", "message_type", "send_time", "timestamp", "last_update_time", "status").
+				Updates(&latest)
+		} else {
+			// 断线期间新出现的债券；已被推送写入时不覆盖
+			res = tx.Table(GetLatestTableName(day)).Clauses(clause.OnConflict{DoNothing: true}).Create(&latest)
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		applied = true
+		if len(corrections) > 0 {
+			return tx.Table(GetDetailTableName(day)).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(corrections, 1000).Error
+		}
+		return nil
+	})
+	if err != nil || !applied {
+		return 0, false, err
+	}
+	if r.books != nil {
+		r.books.apply(newOrderBook(&meta, book, at, model.QuoteStatusConfirmed))
+	}
+	return len(corrections), true, nil
+}
+
+// previousBook 解析最新行情中保存的报价，解析失败视为空
+func previousBook(prev *model.BondLatestQuote) *QuotePriceData {
+	book := &QuotePriceData{}
+	if prev == nil || prev.RawJSON == "" {
+		return book
+	}
+	var msg BondQuoteMessage
+	if err := json.Unmarshal([]byte(prev.RawJSON), &msg); err != nil {
+		return book
+	}
+	json.Unmarshal([]byte(msg.Data.QuotePriceData), book)
+	return book
+}
+
+// snapshotSendTime 快照行情的发送时间
+// 快照接口不返回服务端时间，取断线前最后一条行情的发送时间和快照中最晚的报价时间中较大的，
+// 不用修正时的本地时间：快照之前发出、之后才送达的推送仍比快照新，不会被最新行情的时间条件拒绝
+func snapshotSendTime(book *QuotePriceData, prev *model.BondLatestQuote) int64 {
+	var sent int64
+	if prev != nil {
+		sent = max(prev.SendTime, prev.Timestamp)
+	}
+	for _, levels := range [][]QuotePrice{book.AskPrices, book.BidPrices} {
+		for _, q := range levels {
+			sent = max(sent, q.QuoteTime)
+		}
+	}
+	return sent
+}
+
+// bookLevelKey 快照比对的档位键：方向 + 报价单号；报价单号为空时用方向 + 档位序号（同一方向内从1开始），
+// 与明细表的去重规则一致
+func bookLevelKey(q *QuotePrice, level int) string {
+	if q.QuoteOrderNo == "" {
+		return fmt.Sprintf("%s#%d", q.Side, level)
+	}
+	return q.Side + "|" + q.QuoteOrderNo
+}
+
+// diffBook 比对快照与断线前的报价，生成修正明细
+func diffBook(meta *BondQuoteMessage, book, prev *QuotePriceData) []model.BondQuoteDetail {
+	old := make(map[string]QuotePrice)
+	for _, levels := range [][]QuotePrice{prev.AskPrices, prev.BidPrices} {
+		for i, q := range levels {
+			old[bookLevelKey(&q, i+1)] = q
+		}
+	}
+
+	// 档位序号按修正明细中每个方向的顺序编号
+	var details []model.BondQuoteDetail
+	levels := make(map[string]int)
+	add := func(q QuotePrice) {
+		levels[q.Side]++
+		details = append(details, newQuoteDetail(meta, book.SecurityID, q, levels[q.Side], model.QuoteSourceSnapshot))
+	}
+	for _, side := range [][]QuotePrice{book.AskPrices, book.BidPrices} {
+		for i, q := range side {
+			k := bookLevelKey(&q, i+1)
+			p, ok := old[k]
+			delete(old, k)
+			if ok && p.Price == q.Price && p.Yield == q.Yield && p.OrderQty == q.OrderQty && p.IsValid == q.IsValid {
+				continue
+			}
+			add(q)
+		}
+	}
+
+	// 快照中已不存在的档位，按原报价写入失效记录
+	removed := make([]string, 0, len(old))
+	for k := range old {
+		removed = append(removed, k)
+	}
+	sort.Strings(removed)
+	for _, k := range removed {
+		q := old[k]
+		q.IsValid = "N"
+		add(q)
+	}
+	return details
+}
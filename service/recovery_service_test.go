@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/model"
+)
+
+// quoteMsg 构造一条解析后的推送
+func quoteMsg(t *testing.T, id, isin string, bids, asks []atsmock.Level) *ParsedQuote {
+	t.Helper()
+	pq, err := ParseBondQuote(atsmock.OrderBookMessage(id, isin, time.Now(), bids, asks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pq
+}
+
+func latestStatus(t *testing.T, conn *gorm.DB, isin string) model.BondLatestQuote {
+	t.Helper()
+	var lq model.BondLatestQuote
+	if err := conn.Table(GetTodayLatestTableName()).Where("isin = ?", isin).Take(&lq).Error; err != nil {
+		t.Fatalf("查询 %s 最新行情失败: %v", isin, err)
+	}
+	return lq
+}
+
+func snapshotRows(t *testing.T, conn *gorm.DB, isin string) []model.BondQuoteDetail {
+	t.Helper()
+	var rows []model.BondQuoteDetail
+	conn.Table(GetTodayDetailTableName()).Where("isin = ? AND source = ?", isin, model.QuoteSourceSnapshot).Order("quote_order_no").Find(&rows)
+	return rows
+}
+
+func TestQuoteRecoveryReconcile(t *testing.T) {
+	conn := newTestDB(t)
+	recovery := NewQuoteRecovery(conn, "")
+
+	level := func(no string, price float64) atsmock.Level {
+		return atsmock.Level{QuoteOrderNo: no, BrokerID: "B1", Price: price, Yield: 4, OrderQty: 1000000}
+	}
+	// 断线前的行情
+	if err := InsertBatch(conn, []*ParsedQuote{
+		quoteMsg(t, "M1", "ISIN_A", []atsmock.Level{level("A1", 100), level("A2", 99)}, nil),
+		quoteMsg(t, "M2", "ISIN_B", nil, []atsmock.Level{level("B1", 101)}),
+		quoteMsg(t, "M3", "ISIN_C", []atsmock.Level{level("C1", 98)}, nil),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重连：全部标记为未确认
+	n, err := recovery.MarkUnconfirmed(time.Now())
+	if err != nil || n != 3 {
+		t.Fatalf("标记未确认 %d 行, err = %v", n, err)
+	}
+	if got := latestStatus(t, conn, "ISIN_A").Status; got != model.QuoteStatusUnconfirmed {
+		t.Fatalf("ISIN_A 状态 = %s", got)
+	}
+
+	// 重连后推送先到，ISIN_C 以推送为准
+	if err := InsertBatch(conn, []*ParsedQuote{quoteMsg(t, "M4", "ISIN_C", []atsmock.Level{level("C1", 97)}, nil)}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := []QuotePriceData{
+		// A1 价格变化、A2 未变、A3 新增
+		{SecurityID: "ISIN_A", BidPrices: []QuotePrice{
+			{QuoteOrderNo: "A1", Side: "BID", Price: 100.5, Yield: 4, OrderQty: 1000000, IsValid: "Y"},
+			{QuoteOrderNo: "A2", Side: "BID", Price: 99, Yield: 4, OrderQty: 1000000, IsValid: "Y"},
+			{QuoteOrderNo: "A3", Side: "BID", Price: 98, Yield: 4, OrderQty: 2000000, IsValid: "Y"},
+		}},
+		// ISIN_B 不在快照中：断线期间报价已撤销
+		{SecurityID: "ISIN_C", BidPrices: []QuotePrice{{QuoteOrderNo: "C1", Side: "BID", Price: 50, IsValid: "Y"}}},
+		{SecurityID: "ISIN_D", AskPrices: []QuotePrice{{QuoteOrderNo: "D1", Side: "ASK", Price: 102, IsValid: "Y"}}},
+	}
+	res, err := recovery.Reconcile(time.Now(), snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Books != 4 || res.Corrected != 3 || res.Skipped != 1 || res.Corrections != 4 {
+		t.Fatalf("修正结果 = %+v", res)
+	}
+
+	// ISIN_A：变化和新增的档位写入修正明细，最新行情替换为快照
+	rows := snapshotRows(t, conn, "ISIN_A")
+	if len(rows) != 2 || rows[0].QuoteOrderNo != "A1" || rows[0].Price != 100.5 || rows[1].QuoteOrderNo != "A3" {
+		t.Fatalf("ISIN_A 修正明细 = %+v", rows)
+	}
+	lq := latestStatus(t, conn, "ISIN_A")
+	if lq.Status != model.QuoteStatusConfirmed || lq.MessageType != snapshotMessageType {
+		t.Fatalf("ISIN_A 最新行情 = %s/%s", lq.Status, lq.MessageType)
+	}
+	book := previousBook(&lq)
+	if len(book.BidPrices) != 3 {
+		t.Fatalf("ISIN_A 最新行情档位数 = %d", len(book.BidPrices))
+	}
+
+	// ISIN_B：已撤销的档位写入失效记录，最新行情为空
+	rows = snapshotRows(t, conn, "ISIN_B")
+	if len(rows) != 1 || rows[0].QuoteOrderNo != "B1" || rows[0].IsValid == nil || *rows[0].IsValid != "N" {
+		t.Fatalf("ISIN_B 修正明细 = %+v", rows)
+	}
+	if book := previousBook(ptr(latestStatus(t, conn, "ISIN_B"))); len(book.AskPrices)+len(book.BidPrices) != 0 {
+		t.Fatalf("ISIN_B 最新行情应为空: %+v", book)
+	}
+
+	// ISIN_C：推送已确认，不被快照覆盖
+	if rows := snapshotRows(t, conn, "ISIN_C"); len(rows) != 0 {
+		t.Fatalf("ISIN_C 不应修正: %+v", rows)
+	}
+	if lq := latestStatus(t, conn, "ISIN_C"); lq.MessageID != "M4" || lq.Status != model.QuoteStatusConfirmed {
+		t.Fatalf("ISIN_C 最新行情 = %s/%s", lq.MessageID, lq.Status)
+	}
+
+	// ISIN_D：断线期间新出现的债券
+	if rows := snapshotRows(t, conn, "ISIN_D"); len(rows) != 1 {
+		t.Fatalf("ISIN_D 修正明细 = %+v", rows)
+	}
+	if lq := latestStatus(t, conn, "ISIN_D"); lq.Status != model.QuoteStatusConfirmed {
+		t.Fatalf("ISIN_D 状态 = %s", lq.Status)
+	}
+}
+
+func TestQuoteRecoveryReconcileAfterCutoff(t *testing.T) {
+	cal, err := calendar.New(&config.CalendarConfig{Timezone: "Asia/Hong_Kong", CutoffTime: "17:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBusinessCalendar(cal)
+	defer SetBusinessCalendar(nil)
+	conn := newTestDB(t)
+	recovery := NewQuoteRecovery(conn, "")
+
+	// 周五日切后的行情属于下周一，重连和快照修正都在同一业务日期的表中
+	friday := time.Date(2025, 9, 26, 0, 0, 0, 0, cal.Location())
+	monday := friday.AddDate(0, 0, 3)
+	sent := friday.Add(17*time.Hour + 10*time.Minute)
+	pq, err := ParseBondQuote(atsmock.OrderBookMessage("M1", "ISIN_A", sent, []atsmock.Level{{QuoteOrderNo: "A1", Price: 100, OrderQty: 1000000}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pq.ReceivedAt = sent
+	if err := NewDBSink("bond", conn).Write([]*ParsedQuote{pq}); err != nil {
+		t.Fatal(err)
+	}
+	at := friday.Add(17*time.Hour + 30*time.Minute)
+	if n, err := recovery.MarkUnconfirmed(at); err != nil || n != 1 {
+		t.Fatalf("标记未确认 %d 行, err = %v", n, err)
+	}
+
+	snapshot := []QuotePriceData{
+		{SecurityID: "ISIN_A", BidPrices: []QuotePrice{{QuoteOrderNo: "A1", Side: "BID", Price: 100.5, OrderQty: 1000000, IsValid: "Y"}}},
+		{SecurityID: "ISIN_D", AskPrices: []QuotePrice{{QuoteOrderNo: "D1", Side: "ASK", Price: 102, OrderQty: 1000000, IsValid: "Y"}}},
+	}
+	res, err := recovery.Reconcile(at, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Corrected != 2 || res.Corrections != 2 {
+		t.Fatalf("修正结果 = %+v", res)
+	}
+	var latest []model.BondLatestQuote
+	conn.Table(GetLatestTableName(monday)).Order("isin").Find(&latest)
+	if len(latest) != 2 || latest[0].Status != model.QuoteStatusConfirmed || latest[1].ISIN != "ISIN_D" {
+		t.Fatalf("周一最新行情 = %+v", latest)
+	}
+	var rows int64
+	conn.Table(GetDetailTableName(monday)).Where("source = ?", model.QuoteSourceSnapshot).Count(&rows)
+	if rows != 2 {
+		t.Fatalf("周一修正明细 %d 行", rows)
+	}
+	if conn.Migrator().HasTable(GetDetailTableName(friday)) || conn.Migrator().HasTable(GetLatestTableName(friday)) {
+		t.Fatal("日切后的修正不应写入周五的表")
+	}
+}
+
+func TestQuoteRecoveryEmptyOrderNoAndLatePush(t *testing.T) {
+	conn := newTestDB(t)
+	recovery := NewQuoteRecovery(conn, "")
+	base := time.Now().Add(-time.Minute).Truncate(time.Second)
+	quote := func(id string, sent time.Time, bids []atsmock.Level) *ParsedQuote {
+		pq, err := ParseBondQuote(atsmock.OrderBookMessage(id, "ISIN_A", sent, bids, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pq.ReceivedAt = time.Now()
+		return pq
+	}
+	// 断线前报价单号为空的两档
+	if err := NewDBSink("bond", conn).Write([]*ParsedQuote{quote("M1", base, []atsmock.Level{{Price: 100, OrderQty: 1e6}, {Price: 99, OrderQty: 1e6}})}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recovery.MarkUnconfirmed(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// 断线期间发出、快照之后才送达的推送
+	late := quote("M2", base.Add(10*time.Second), []atsmock.Level{{Price: 100.2, OrderQty: 1e6}})
+
+	// 按档位序号比对：第一档未变，只修正第二档
+	snapshot := []QuotePriceData{{SecurityID: "ISIN_A", BidPrices: []QuotePrice{
+		{Side: "BID", Price: 100, OrderQty: 1e6, IsValid: "Y", QuoteTime: base.UnixMilli()},
+		{Side: "BID", Price: 98.5, OrderQty: 1e6, IsValid: "Y", QuoteTime: base.Add(5 * time.Second).UnixMilli()},
+	}}}
+	res, err := recovery.Reconcile(time.Now(), snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := snapshotRows(t, conn, "ISIN_A")
+	if res.Corrections != 1 || len(rows) != 1 || rows[0].Price != 98.5 || rows[0].LevelNo != 1 {
+		t.Fatalf("修正结果 = %+v, 修正明细 = %+v", res, rows)
+	}
+
+	// 快照按报价时间而不是修正时间比较，更新的推送仍然覆盖
+	if err := NewDBSink("bond", conn).Write([]*ParsedQuote{late}); err != nil {
+		t.Fatal(err)
+	}
+	if lq := latestStatus(t, conn, "ISIN_A"); lq.MessageID != "M2" {
+		t.Fatalf("最新行情 = %s", lq.MessageID)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestSessionRecoveryAfterReconnect(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+
+	const snapshotPath = "/ats-api/quote/snapshot"
+	snapshotCalls := make(chan struct{}, 10)
+	srv.HandleAPI(snapshotPath, func(json.RawMessage) (int, string, any) {
+		snapshotCalls <- struct{}{}
+		return 200, "成功", []map[string]any{{
+			"securityId": "HK0000000001",
+			"bidPrices":  []map[string]any{{"quoteOrderNo": "Q2", "side": "BID", "price": 101, "isValid": "Y"}},
+			"askPrices":  []map[string]any{},
+		}}
+	})
+
+	conn := newTestDB(t)
+	// 启动前已有的最新行情（例如进程重启前写入）
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	if err := InsertBatch(conn, []*ParsedQuote{
+		quoteMsg(t, "OLD1", "HK0000000001", level, nil),
+		quoteMsg(t, "OLD2", "HK0000000002", level, nil),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newTestATSConfig(srv)
+	cfg.SnapshotPath = snapshotPath
+	session := newTestSession(cfg, make(chan *RawMessage, 10))
+	session.SetRecovery(NewQuoteRecovery(conn, cfg.SnapshotPath))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- session.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case <-snapshotCalls:
+	case <-time.After(5 * time.Second):
+		t.Fatal("订阅后未拉取快照")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for latestStatus(t, conn, "HK0000000002").Status != model.QuoteStatusConfirmed {
+		if time.Now().After(deadline) {
+			t.Fatal("快照修正未完成")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if lq := latestStatus(t, conn, "HK0000000001"); lq.MessageType != snapshotMessageType {
+		t.Fatalf("HK0000000001 未按快照修正: %s", lq.MessageType)
+	}
+	// Q1 撤销、Q2 新增
+	if rows := snapshotRows(t, conn, "HK0000000001"); len(rows) != 2 {
+		t.Fatalf("修正明细 = %+v", rows)
+	}
+
+	// 断线重连后再次修正
+	srv.DropConnections()
+	select {
+	case <-snapshotCalls:
+	case <-time.After(5 * time.Second):
+		t.Fatal("重连后未拉取快照")
+	}
+}
@@ -0,0 +1,507 @@
+package service
+
+// ATS会话监督器
+// 以状态机方式驱动 登录 → WebSocket → STOMP → 订阅 → 监听 的完整流程
+//
+// 主要功能：
+// 1. 任一步骤失败后按指数退避（带抖动）重连
+// 2. 连续失败达到 MaxReconnectAttempts 后停止
+// 3. 同一时刻只允许一个监听循环运行
+// 4. 对外暴露当前会话状态
+// 5. token被拒绝时通过 TokenManager 重新登录后恢复订阅
+// 6. 配置多个网关时按顺序故障切换，主网关恢复后回切（见 ats_endpoint.go）
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/pkg/capture"
+	"wealth-bond-quote-service/pkg/dtalk"
+	logger "wealth-bond-quote-service/pkg/log"
+
+	"github.com/go-stomp/stomp/v3"
+)
+
+// SessionState 会话状态
+type SessionState int32
+
+const (
+	SessionIdle            SessionState = iota // 未启动
+	SessionLoggingIn                           // 登录中
+	SessionConnectingWS                        // 建立WebSocket连接中
+	SessionConnectingStomp                     // 建立STOMP连接中
+	SessionSubscribing                         // 订阅中
+	SessionStreaming                           // 正常接收行情
+	SessionBackoff                             // 退避等待重连
+	SessionStopped                             // 已停止（上下文取消）
+	SessionFailed                              // 重连次数耗尽
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionIdle:
+		return "IDLE"
+	case SessionLoggingIn:
+		return "LOGGING_IN"
+	case SessionConnectingWS:
+		return "CONNECTING_WS"
+	case SessionConnectingStomp:
+		return "CONNECTING_STOMP"
+	case SessionSubscribing:
+		return "SUBSCRIBING"
+	case SessionStreaming:
+		return "STREAMING"
+	case SessionBackoff:
+		return "BACKOFF"
+	case SessionStopped:
+		return "STOPPED"
+	case SessionFailed:
+		return "FAILED"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", int32(s))
+	}
+}
+
+const (
+	defaultReconnectInterval = 5 * time.Second // 默认重连基础间隔
+	maxReconnectBackoff      = 5 * time.Minute // 退避上限
+	stableStreamDuration     = time.Minute     // 持续接收超过该时长视为连接稳定，重置失败计数
+)
+
+var (
+	ErrSessionRunning       = errors.New("ATS会话已在运行")
+	ErrMaxReconnectAttempts = errors.New("超过最大重连次数")
+)
+
+// AtsSession ATS会话监督器
+type AtsSession struct {
+	cfg       *config.AdenATSConfig
+	rawChan   chan *RawMessage
+	ackMode   stomp.AckMode
+	capture   *capture.Writer
+	transport *AtsTransport
+	watchdog  *FeedWatchdog
+	recovery  *QuoteRecovery
+
+	endpoints    []*atsEndpoint
+	endpointErr  error // 网关配置错误，Run 时返回
+	active       atomic.Int32
+	failbackTo   atomic.Int32 // 待回切的网关下标，-1表示无
+	threshold    int
+	failbackTick time.Duration
+
+	// alert 发送运维告警，默认钉钉文本消息，测试时可替换
+	alert func(ctx context.Context, content string) error
+	// sleep 退避等待，上下文取消时返回false，测试时可替换
+	sleep func(ctx context.Context, d time.Duration) bool
+
+	state    atomic.Int32
+	attempts atomic.Int32 // 连续失败次数
+	running  atomic.Bool
+
+	mu           sync.Mutex
+	lastErr      error
+	rnd          *rand.Rand
+	cancelListen context.CancelCauseFunc // 当前监听循环的取消函数，供 ForceReconnect 使用
+}
+
+// NewAtsSession 创建ATS会话监督器
+func NewAtsSession(cfg *config.AdenATSConfig, rawChan chan *RawMessage) *AtsSession {
+	s := &AtsSession{
+		cfg:          cfg,
+		rawChan:      rawChan,
+		threshold:    cfg.FailoverThreshold,
+		failbackTick: time.Duration(cfg.FailbackInterval) * time.Second,
+		alert:        dtalk.DTalkSendTextMsg,
+		sleep:        sleepContext,
+		rnd:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	s.endpoints, s.endpointErr = resolveEndpoints(cfg)
+	s.failbackTo.Store(-1)
+	if s.threshold <= 0 {
+		s.threshold = defaultFailoverThreshold
+	}
+	if s.failbackTick <= 0 {
+		s.failbackTick = defaultFailbackInterval
+	}
+	return s
+}
+
+// SetCapture 设置原始消息录制，需在 Run 之前调用
+func (s *AtsSession) SetCapture(w *capture.Writer) {
+	s.capture = w
+}
+
+// SetWatchdog 设置行情断流监控，断流时由监控强制重连，需在 Run 之前调用
+func (s *AtsSession) SetWatchdog(w *FeedWatchdog) {
+	s.watchdog = w
+	w.WatchFeed(BondQuoteDestination, s.ForceReconnect)
+}
+
+// SetRecovery 设置重连后的行情修正，需在 Run 之前调用
+func (s *AtsSession) SetRecovery(r *QuoteRecovery) {
+	s.recovery = r
+}
+
+// ForceReconnect 中断当前监听，按正常的断线流程退避后重连
+// 会话不在接收状态时忽略
+func (s *AtsSession) ForceReconnect(reason error) {
+	s.mu.Lock()
+	cancel := s.cancelListen
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	logger.Warn("强制重连ATS会话: %v", reason)
+	cancel(reason)
+}
+
+// State 当前会话状态
+func (s *AtsSession) State() SessionState {
+	return SessionState(s.state.Load())
+}
+
+// ActiveEndpoint 当前使用的网关
+func (s *AtsSession) ActiveEndpoint() EndpointStatus {
+	return s.endpoint().status(true)
+}
+
+// Endpoints 所有网关的状态，按配置顺序
+func (s *AtsSession) Endpoints() []EndpointStatus {
+	active := int(s.active.Load())
+	statuses := make([]EndpointStatus, len(s.endpoints))
+	for i, e := range s.endpoints {
+		statuses[i] = e.status(i == active)
+	}
+	return statuses
+}
+
+func (s *AtsSession) endpoint() *atsEndpoint {
+	return s.endpoints[s.active.Load()]
+}
+
+// Attempts 当前连续失败次数
+func (s *AtsSession) Attempts() int {
+	return int(s.attempts.Load())
+}
+
+// LastError 最近一次失败原因
+func (s *AtsSession) LastError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+func (s *AtsSession) setState(state SessionState) {
+	old := SessionState(s.state.Swap(int32(state)))
+	if old != state {
+		logger.Info("ATS会话状态: %s -> %s", old, state)
+	}
+}
+
+// Run 运行会话直到上下文取消或重连次数耗尽
+// 上下文取消时返回nil；重连次数耗尽时返回 ErrMaxReconnectAttempts
+func (s *AtsSession) Run(ctx context.Context) error {
+	if !s.running.CompareAndSwap(false, true) {
+		return ErrSessionRunning
+	}
+	defer s.running.Store(false)
+
+	if s.endpointErr != nil {
+		s.setState(SessionFailed)
+		return s.endpointErr
+	}
+
+	ackMode, err := ParseAckMode(s.cfg.AckMode)
+	if err != nil {
+		s.setState(SessionFailed)
+		return err
+	}
+	s.ackMode = ackMode
+
+	// TLS配置错误（证书文件缺失、指纹格式错误）重试无意义，直接失败
+	transport, err := NewAtsTransport(s.cfg)
+	if err != nil {
+		s.setState(SessionFailed)
+		return err
+	}
+	s.transport = transport
+	for _, e := range s.endpoints {
+		e.setTransport(transport)
+	}
+	logger.Info("使用ATS网关: %s (%s)", s.endpoint().name, s.endpoint().cfg.BaseURL)
+
+	if len(s.endpoints) > 1 {
+		monitorCtx, stopMonitor := context.WithCancel(ctx)
+		defer stopMonitor()
+		go s.monitorFailback(monitorCtx)
+	}
+
+	for {
+		if target := int(s.failbackTo.Swap(-1)); target >= 0 && target < int(s.active.Load()) {
+			s.switchEndpoint(target, "主网关已恢复")
+		}
+
+		ep := s.endpoint()
+		streamedFor, err := s.runOnce(ctx, ep)
+		if ctx.Err() != nil {
+			s.setState(SessionStopped)
+			return nil
+		}
+		if errors.Is(err, ErrEndpointFailback) {
+			// 主动回切，不计入失败
+			continue
+		}
+
+		// 稳定运行过一段时间后的断线视为新一轮故障，重新计数
+		if streamedFor >= stableStreamDuration {
+			s.attempts.Store(0)
+		}
+		attempt := int(s.attempts.Add(1))
+
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+
+		if limit := s.cfg.MaxReconnectAttempts; limit > 0 && attempt > limit {
+			s.setState(SessionFailed)
+			logger.Error("ATS会话连续失败 %d 次，停止重连: %v", attempt, err)
+			return fmt.Errorf("%w: %v", ErrMaxReconnectAttempts, err)
+		}
+
+		delay := s.backoff(attempt)
+		if s.failover(ctx, ep, streamedFor > 0) {
+			// 切换网关后尽快连接，不沿用原网关的退避时长
+			delay = s.backoff(1)
+		}
+		s.setState(SessionBackoff)
+		logger.Warn("ATS会话中断(第%d次): %v，%s 后重连", attempt, err, delay)
+
+		if !s.sleep(ctx, delay) {
+			s.setState(SessionStopped)
+			return nil
+		}
+	}
+}
+
+// sleepContext 等待d，上下文取消时提前返回false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// backoff 计算第attempt次重连前的等待时间
+// 指数退避：base * 2^(attempt-1)，上限 maxReconnectBackoff
+// 抖动：在[d/2, d)之间随机，避免多实例同时重连
+func (s *AtsSession) backoff(attempt int) time.Duration {
+	base := time.Duration(s.cfg.ReconnectInterval) * time.Millisecond
+	if base <= 0 {
+		base = defaultReconnectInterval
+	}
+
+	d := base
+	for i := 1; i < attempt && d < maxReconnectBackoff; i++ {
+		d *= 2
+	}
+	if d > maxReconnectBackoff {
+		d = maxReconnectBackoff
+	}
+
+	half := d / 2
+	s.mu.Lock()
+	jitter := time.Duration(s.rnd.Int63n(int64(half) + 1))
+	s.mu.Unlock()
+	return half + jitter
+}
+
+// failover 记录当前网关的失败，达到阈值或探测不可用时切换到下一个可用网关
+// 返回是否发生了切换
+func (s *AtsSession) failover(ctx context.Context, ep *atsEndpoint, streamed bool) bool {
+	failures := ep.recordFailure(streamed)
+	if len(s.endpoints) < 2 {
+		return false
+	}
+	if failures < s.threshold && ep.probe(ctx, s.transport.HTTPClient) {
+		return false
+	}
+
+	// 从下一个网关开始依次探测，选择第一个可用的；都不可用时轮换到下一个
+	current := int(s.active.Load())
+	next := (current + 1) % len(s.endpoints)
+	for i := 1; i < len(s.endpoints); i++ {
+		idx := (current + i) % len(s.endpoints)
+		if s.endpoints[idx].probe(ctx, s.transport.HTTPClient) {
+			next = idx
+			break
+		}
+	}
+	s.switchEndpoint(next, fmt.Sprintf("网关 %s 连续失败 %d 次", ep.name, failures))
+	return true
+}
+
+// monitorFailback 使用备用网关期间定期探测更高优先级的网关，恢复后请求回切
+func (s *AtsSession) monitorFailback(ctx context.Context) {
+	ticker := time.NewTicker(s.failbackTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		active := int(s.active.Load())
+		for i := 0; i < active; i++ {
+			if !s.endpoints[i].probe(ctx, s.transport.HTTPClient) {
+				continue
+			}
+			logger.Info("ATS网关 %s 探测恢复，准备回切", s.endpoints[i].name)
+			s.failbackTo.Store(int32(i))
+			s.ForceReconnect(ErrEndpointFailback)
+			break
+		}
+	}
+}
+
+// switchEndpoint 切换当前网关并告警
+func (s *AtsSession) switchEndpoint(idx int, reason string) {
+	from := s.endpoint()
+	to := s.endpoints[idx]
+	to.resetFailures()
+	s.active.Store(int32(idx))
+
+	content := fmt.Sprintf("【债券行情告警】ATS网关切换: %s -> %s（%s）", from.name, to.name, reason)
+	logger.Warn("%s, 新网关地址: %s", content, to.cfg.BaseURL)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), alertSendTimeout)
+		defer cancel()
+		if err := s.alert(ctx, content); err != nil {
+			logger.Error("发送网关切换告警失败: %v", err)
+		}
+	}()
+}
+
+// runOnce 在指定网关上执行一轮完整的连接流程，返回本轮处于接收状态的时长和中断原因
+func (s *AtsSession) runOnce(ctx context.Context, ep *atsEndpoint) (time.Duration, error) {
+	cfg := ep.cfg
+	client := &StompClient{
+		Timeout:   time.Duration(cfg.Timeout) * time.Second,
+		Heartbeat: time.Duration(cfg.Heartbeat) * time.Millisecond,
+		AckMode:   s.ackMode,
+		Capture:   s.capture,
+		Transport: s.transport,
+		Watchdog:  s.watchdog,
+	}
+
+	// 第一步：获取访问令牌（复用缓存的token，失效时重新登录）
+	s.setState(SessionLoggingIn)
+	token, err := ep.tokens.Token(ctx)
+	if err != nil {
+		return 0, err
+	}
+	client.Token = token
+
+	// 第二步：建立WebSocket连接
+	s.setState(SessionConnectingWS)
+	if err := client.ConnectWebSocket(cfg.WssURL); err != nil {
+		ep.checkAuth(token, err)
+		return 0, err
+	}
+	defer client.Conn.Close()
+
+	// 第三步：建立STOMP协议连接
+	s.setState(SessionConnectingStomp)
+	if err := client.ConnectStomp(); err != nil {
+		ep.checkAuth(token, err)
+		return 0, err
+	}
+
+	// 第四步：订阅债券行情消息
+	s.setState(SessionSubscribing)
+	sub, err := client.Subscribe()
+	if err != nil {
+		client.StompConn.MustDisconnect()
+		return 0, err
+	}
+
+	// 第五步：持续监听消息推送
+	listenCtx, cancelListen := context.WithCancelCause(ctx)
+	s.mu.Lock()
+	s.cancelListen = cancelListen
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.cancelListen = nil
+		s.mu.Unlock()
+		cancelListen(nil)
+	}()
+
+	s.setState(SessionStreaming)
+	start := time.Now()
+	if s.watchdog != nil {
+		s.watchdog.FeedSubscribed(sub.Destination(), start)
+	}
+	if s.recovery != nil {
+		s.startRecovery(listenCtx, ep.api, token)
+	}
+	err = client.Listen(listenCtx, sub, s.rawChan)
+	streamedFor := time.Since(start)
+	if err == nil && ctx.Err() == nil {
+		// 被 ForceReconnect 中断，原因作为本轮失败原因
+		err = context.Cause(listenCtx)
+	}
+	if err != nil {
+		ep.checkAuth(token, err)
+		client.StompConn.MustDisconnect()
+		return streamedFor, err
+	}
+
+	client.StompConn.Disconnect()
+	return streamedFor, nil
+}
+
+// startRecovery 订阅成功后修正断线期间的行情
+// 先同步把已有最新行情标记为未确认，再异步拉取快照修正，不阻塞行情接收
+func (s *AtsSession) startRecovery(ctx context.Context, api *AtsAPIClient, token string) {
+	now := time.Now()
+	n, err := s.recovery.MarkUnconfirmed(now)
+	if err != nil {
+		logger.Error("标记未确认行情失败: %v", err)
+		return
+	}
+	if !s.recovery.HasSnapshot() {
+		logger.Info("未配置报价快照接口，%d 条最新行情标记为未确认，等待推送确认", n)
+		return
+	}
+
+	go func() {
+		res, err := s.recovery.Recover(ctx, api, token)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("重连后行情修正失败，未确认的行情等待推送确认: %v", err)
+			}
+			return
+		}
+		logger.Info("重连后行情修正完成: 比对 %d 个债券，修正 %d 个，跳过 %d 个，写入修正明细 %d 行",
+			res.Books, res.Corrected, res.Skipped, res.Corrections)
+	}()
+}
+
+// maskToken 日志中只输出token前缀
+func maskToken(token string) string {
+	if len(token) <= 8 {
+		return "***"
+	}
+	return token[:8] + "..."
+}
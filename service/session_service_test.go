@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+)
+
+func TestSessionBackoffAndAttemptLimit(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+	cfg := newTestATSConfig(srv)
+	cfg.Password = "wrong" // 每次登录都失败
+	cfg.ReconnectInterval = 1000
+	cfg.MaxReconnectAttempts = 3
+	s := newTestSession(cfg, make(chan *RawMessage, 1))
+	s.rnd = rand.New(rand.NewSource(1))
+	var delays []time.Duration
+	s.sleep = func(ctx context.Context, d time.Duration) bool {
+		delays = append(delays, d)
+		return true
+	}
+
+	err := s.Run(context.Background())
+	if !errors.Is(err, ErrMaxReconnectAttempts) || s.State() != SessionFailed {
+		t.Fatalf("Run = %v, 状态 %s", err, s.State())
+	}
+	// 第4次失败超过上限，之前退避3次
+	if s.Attempts() != 4 || len(delays) != 3 {
+		t.Fatalf("失败 %d 次, 退避 %v", s.Attempts(), delays)
+	}
+	// 指数退避，抖动在 [d/2, d] 之间
+	for i, d := range delays {
+		full := time.Second << i
+		if d < full/2 || d > full {
+			t.Fatalf("第%d次退避 %s 超出 [%s, %s]", i+1, d, full/2, full)
+		}
+	}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		d := s.backoff(30)
+		if d < maxReconnectBackoff/2 || d > maxReconnectBackoff {
+			t.Fatalf("退避上限 %s", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("退避时长没有抖动")
+	}
+
+	// 上下文取消时停止等待
+	s.sleep = sleepContext
+	cfg.MaxReconnectAttempts = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Run(ctx); err != nil || s.State() != SessionStopped {
+		t.Fatalf("取消后 Run = %v, 状态 %s", err, s.State())
+	}
+}
+
+func TestSessionSingleListener(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+	s := newTestSession(newTestATSConfig(srv), make(chan *RawMessage, 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	if err := srv.WaitSubscribes(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 运行中再次启动直接返回，不会建立第二个监听
+	if err := s.Run(ctx); !errors.Is(err, ErrSessionRunning) {
+		t.Fatalf("重复 Run = %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := srv.Subscribes(); n != 1 || s.State() != SessionStreaming {
+		t.Fatalf("订阅 %d 次, 状态 %s", n, s.State())
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// 停止后可以重新启动
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- s.Run(ctx) }()
+	if err := srv.WaitSubscribes(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,255 @@
+package service
+
+// 本地预写日志阶段
+// 会话收到的原始消息先追加到本地预写日志，刷盘后即向ATS确认（客户端确认模式）；
+// 读取协程再从预写日志顺序投递给解析层，写库成功后推进检查点。
+// 数据库维护期间写库失败的消息按退避间隔从失败位置重新投递，
+// 进程重启后从上次检查点之后重放，保证不丢行情（至少一次）。
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+	logger "wealth-bond-quote-service/pkg/log"
+	"wealth-bond-quote-service/pkg/spool"
+)
+
+const (
+	defaultSpoolStatsInterval = 60 * time.Second
+	spoolRetryMin             = time.Second
+	spoolRetryMax             = time.Minute
+	maxSpoolAckBatch          = 256 // 一次刷盘最多确认的消息数
+)
+
+// OpenSpool 按配置打开本地预写日志
+func OpenSpool(cfg *config.SpoolConfig) (*spool.Spool, error) {
+	return spool.Open(cfg.Dir, spool.Options{
+		SegmentSize:  int64(cfg.SegmentSizeMB) << 20,
+		Retention:    time.Duration(cfg.Retention) * time.Minute,
+		SyncInterval: time.Duration(cfg.SyncInterval) * time.Millisecond,
+	})
+}
+
+// SpoolStage 预写日志阶段，位于会话和解析层之间
+type SpoolStage struct {
+	sp            *spool.Spool
+	reader        *spool.Reader
+	statsInterval time.Duration
+	bypassed      atomic.Int64 // 落盘失败直接投递的消息数
+
+	// flush 确认消息前刷盘，测试时可替换
+	flush func() error
+
+	mu            sync.Mutex
+	pending       uint64        // 待重新投递的最小序号，0 表示无
+	timer         *time.Timer   // 重新投递定时器
+	retry         time.Duration // 当前退避间隔
+	lastCommitted uint64        // 上次安排重新投递时的检查点，用于判断是否有进展
+}
+
+// SpoolStats 预写日志指标
+type SpoolStats struct {
+	spool.Stats
+	Bypassed int64 `json:"bypassed"` // 落盘失败直接投递的消息数
+}
+
+// NewSpoolStage 创建预写日志阶段
+func NewSpoolStage(sp *spool.Spool, cfg *config.SpoolConfig) *SpoolStage {
+	st := &SpoolStage{
+		sp:            sp,
+		statsInterval: time.Duration(cfg.StatsInterval) * time.Second,
+		flush:         sp.Flush,
+	}
+	if st.statsInterval <= 0 {
+		st.statsInterval = defaultSpoolStatsInterval
+	}
+	return st
+}
+
+// Start 启动写入和投递协程
+// in 关闭后写入协程退出；ctx 取消后投递协程退出，尚未投递的记录留在磁盘，下次启动时重放
+func (st *SpoolStage) Start(ctx context.Context, in <-chan *RawMessage, out chan<- *RawMessage, wg *sync.WaitGroup) {
+	st.reader = st.sp.NewReader()
+	if stats := st.sp.Stats(); stats.Depth > 0 {
+		logger.Info("预写日志从检查点 %d 之后重放 %d 条消息", stats.Committed, stats.Depth)
+	}
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		st.write(in, out)
+	}()
+	go func() {
+		defer wg.Done()
+		defer st.reader.Close()
+		defer st.stopRewind()
+		st.deliver(ctx, out)
+	}()
+	go func() {
+		defer wg.Done()
+		st.report(ctx)
+	}()
+}
+
+// Stats 返回预写日志指标
+func (st *SpoolStage) Stats() SpoolStats {
+	return SpoolStats{Stats: st.sp.Stats(), Bypassed: st.bypassed.Load()}
+}
+
+// write 追加到预写日志，刷盘后确认上游消息；落盘失败时原样投递，由写库结果确认
+// 需要确认的消息攒批刷盘：输入暂时没有新消息或攒够 maxSpoolAckBatch 条时刷盘一次再统一确认
+func (st *SpoolStage) write(in <-chan *RawMessage, out chan<- *RawMessage) {
+	var pending []Acknowledger
+	defer func() { st.ackFlushed(pending) }()
+	for {
+		var raw *RawMessage
+		var ok bool
+		select {
+		case raw, ok = <-in:
+		default:
+			pending = st.ackFlushed(pending)
+			raw, ok = <-in
+		}
+		if !ok {
+			return
+		}
+		if _, err := st.sp.Append(raw.ReceivedAt, raw.Body); err != nil {
+			if st.bypassed.Add(1) == 1 || !errors.Is(err, spool.ErrClosed) {
+				logger.Error("写入预写日志失败，消息直接投递: %v", err)
+			}
+			out <- raw
+			continue
+		}
+		if raw.Ack != nil {
+			pending = append(pending, raw.Ack)
+			if len(pending) >= maxSpoolAckBatch {
+				pending = st.ackFlushed(pending)
+			}
+		}
+	}
+}
+
+// ackFlushed 刷盘后确认消息；刷盘失败时拒绝，由ATS重新投递（重复的明细按唯一索引跳过）
+func (st *SpoolStage) ackFlushed(pending []Acknowledger) []Acknowledger {
+	if len(pending) == 0 {
+		return pending
+	}
+	if err := st.flush(); err != nil {
+		logger.Error("预写日志刷盘失败，拒绝 %d 条消息: %v", len(pending), err)
+		for _, a := range pending {
+			nackMessage(a)
+		}
+	} else {
+		for _, a := range pending {
+			ackMessage(a)
+		}
+	}
+	clear(pending)
+	return pending[:0]
+}
+
+// deliver 从预写日志顺序投递未提交的消息
+func (st *SpoolStage) deliver(ctx context.Context, out chan<- *RawMessage) {
+	for {
+		rec, err := st.reader.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, spool.ErrClosed) {
+				logger.Error("读取预写日志失败，停止投递: %v", err)
+			}
+			return
+		}
+		msg := &RawMessage{
+			Body:       rec.Body,
+			ReceivedAt: rec.ReceivedAt,
+			Ack:        &spoolAck{stage: st, seq: rec.Seq},
+		}
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// report 定期输出预写日志积压指标
+func (st *SpoolStage) report(ctx context.Context) {
+	ticker := time.NewTicker(st.statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s := st.Stats()
+			logger.Info("预写日志: 段 %d 个/%d 字节, 最新序号 %d, 检查点 %d, 积压 %d 条, 直接投递 %d 条",
+				s.Segments, s.Bytes, s.LastSeq, s.Committed, s.Depth, s.Bypassed)
+		}
+	}
+}
+
+// scheduleRewind 安排从 seq 重新投递
+// 同一退避周期内的多次失败合并为一次回退；两次回退之间检查点没有推进时退避间隔翻倍
+func (st *SpoolStage) scheduleRewind(seq uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.pending == 0 || seq < st.pending {
+		st.pending = seq
+	}
+	if st.timer != nil {
+		return
+	}
+
+	committed := st.sp.Committed()
+	switch {
+	case st.retry == 0 || committed > st.lastCommitted:
+		st.retry = spoolRetryMin
+	case st.retry < spoolRetryMax:
+		st.retry = min(st.retry*2, spoolRetryMax)
+	}
+	st.lastCommitted = committed
+	logger.Warn("写库失败，%s 后从预写日志序号 %d 重新投递", st.retry, st.pending)
+
+	st.timer = time.AfterFunc(st.retry, func() {
+		st.mu.Lock()
+		seq := st.pending
+		st.pending, st.timer = 0, nil
+		st.mu.Unlock()
+		st.reader.Rewind(seq)
+	})
+}
+
+// stopRewind 取消尚未执行的重新投递，未提交的记录下次启动时重放
+func (st *SpoolStage) stopRewind() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.timer != nil {
+		st.timer.Stop()
+		st.pending, st.timer = 0, nil
+	}
+}
+
+// spoolAck 预写日志消息的确认句柄：写库成功推进检查点，失败则回退重读
+type spoolAck struct {
+	stage *SpoolStage
+	seq   uint64
+}
+
+func (a *spoolAck) Ack() error {
+	a.stage.sp.Commit(a.seq)
+	return nil
+}
+
+func (a *spoolAck) Nack() error {
+	a.stage.scheduleRewind(a.seq)
+	return nil
+}
+
+// DeadLetter 消息已写入死信表，重读也无法解析，直接推进检查点
+func (a *spoolAck) DeadLetter() error {
+	a.stage.sp.Commit(a.seq)
+	return nil
+}
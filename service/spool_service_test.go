@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/pkg/spool"
+
+	"gorm.io/gorm"
+)
+
+// spoolPipeline 预写日志 + 解析 + 写库的完整流水线
+type spoolPipeline struct {
+	sp     *spool.Spool
+	stage  *SpoolStage
+	in     chan *RawMessage
+	dead   chan *DeadMessage
+	cancel context.CancelFunc
+	stop   func()
+}
+
+func startSpoolPipeline(t *testing.T, conn *gorm.DB, dir string) *spoolPipeline {
+	t.Helper()
+	cfg := &config.SpoolConfig{Dir: dir}
+	sp, err := OpenSpool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg, spoolWg sync.WaitGroup
+	rawChan := make(chan *RawMessage, 100)
+	parsedChan := make(chan *ParsedQuote, 100)
+	p := &spoolPipeline{sp: sp, in: make(chan *RawMessage, 100), dead: make(chan *DeadMessage, 10)}
+	bqs := NewBondQuoteService(conn, &wg, rawChan, parsedChan, p.dead)
+	bqs.StartParseWorkers(2)
+	bqs.StartDBWorkers(1, 10, 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.stage = NewSpoolStage(sp, cfg)
+	p.stage.Start(ctx, p.in, rawChan, &spoolWg)
+	p.stop = func() {
+		cancel()
+		close(p.in)
+		spoolWg.Wait()
+		close(rawChan)
+		close(parsedChan)
+		close(p.dead)
+		wg.Wait()
+		sp.Close()
+	}
+	return p
+}
+
+// waitRewindScheduled 等待写库失败后安排重新投递
+func waitRewindScheduled(t *testing.T, st *SpoolStage) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		st.mu.Lock()
+		scheduled := st.timer != nil
+		st.mu.Unlock()
+		if scheduled {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("写库失败后未安排重新投递")
+}
+
+func TestSpoolSurvivesDBOutage(t *testing.T) {
+	conn := newTestDB(t)
+	dir := filepath.Join(t.TempDir(), "spool")
+	detailTable := GetTodayDetailTableName()
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	push := func(p *spoolPipeline, body []byte) {
+		p.in <- &RawMessage{Body: body, ReceivedAt: time.Now()}
+	}
+
+	p := startSpoolPipeline(t, conn, dir)
+	push(p, atsmock.OrderBookMessage("SP1", "HK0000000001", time.Now(), level, nil))
+	push(p, []byte(`{"data":`))
+	waitRows(t, conn, detailTable, 1)
+	<-p.dead
+
+	// 数据库维护：写库失败后按退避间隔从预写日志重新投递
+	breakTable(t, conn, detailTable)
+	push(p, atsmock.OrderBookMessage("SP2", "HK0000000002", time.Now(), level, nil))
+	waitRewindScheduled(t, p.stage)
+	if st := p.stage.Stats(); st.Depth != 1 || st.Committed != 2 {
+		t.Fatalf("写库失败时状态 = %+v", st)
+	}
+	if err := conn.Migrator().DropTable(detailTable); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewCreateTableService(conn).EnsureDailyTablesExist(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	waitRows(t, conn, detailTable, 1)
+
+	// 维护期间停止服务：未提交的消息留在磁盘
+	breakTable(t, conn, detailTable)
+	push(p, atsmock.OrderBookMessage("SP3", "HK0000000003", time.Now(), level, nil))
+	waitRewindScheduled(t, p.stage)
+	p.stop()
+
+	// 重启后从检查点之后重放
+	if err := conn.Migrator().DropTable(detailTable); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewCreateTableService(conn).EnsureDailyTablesExist(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	p = startSpoolPipeline(t, conn, dir)
+	defer p.stop()
+	waitRows(t, conn, detailTable, 1)
+	var ids []string
+	conn.Table(detailTable).Pluck("message_id", &ids)
+	if len(ids) != 1 || ids[0] != "SP3" {
+		t.Fatalf("重放写入 = %v", ids)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.stage.Stats().Depth != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := p.stage.Stats(); st.Depth != 0 || st.Committed != 4 {
+		t.Fatalf("重放后状态 = %+v", st)
+	}
+}
+
+// flushCheckAck 确认时检查对应记录是否已刷盘
+type flushCheckAck struct {
+	seq     uint64
+	flushed *atomic.Uint64
+	acked   chan bool // 确认时是否已刷盘，拒绝时为 false
+}
+
+func (a *flushCheckAck) Ack() error  { a.acked <- a.flushed.Load() >= a.seq; return nil }
+func (a *flushCheckAck) Nack() error { a.acked <- false; return nil }
+
+func TestSpoolAcksAfterFlush(t *testing.T) {
+	cfg := &config.SpoolConfig{Dir: filepath.Join(t.TempDir(), "spool"), SyncInterval: 60000}
+	sp, err := OpenSpool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	st := NewSpoolStage(sp, cfg)
+	var flushed atomic.Uint64
+	failFlush := atomic.Bool{}
+	st.flush = func() error {
+		if failFlush.Load() {
+			return errors.New("disk full")
+		}
+		err := sp.Flush()
+		flushed.Store(sp.Stats().LastSeq)
+		return err
+	}
+
+	in := make(chan *RawMessage, 10)
+	out := make(chan *RawMessage, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	st.Start(ctx, in, out, &wg)
+	defer func() {
+		cancel()
+		close(in)
+		wg.Wait()
+	}()
+
+	acked := make(chan bool, 10)
+	for seq := uint64(1); seq <= 5; seq++ {
+		in <- &RawMessage{Body: []byte(`{}`), ReceivedAt: time.Now(), Ack: &flushCheckAck{seq: seq, flushed: &flushed, acked: acked}}
+	}
+	for i := 0; i < 5; i++ {
+		if ok := <-acked; !ok {
+			t.Fatal("记录刷盘前已确认")
+		}
+	}
+
+	// 刷盘失败时拒绝，由ATS重新投递
+	failFlush.Store(true)
+	in <- &RawMessage{Body: []byte(`{}`), ReceivedAt: time.Now(), Ack: &flushCheckAck{seq: 6, flushed: &flushed, acked: acked}}
+	if ok := <-acked; ok {
+		t.Fatal("刷盘失败时不应确认")
+	}
+}
@@ -0,0 +1,243 @@
+package service
+
+// ATS访问令牌生命周期管理
+// 1. 缓存登录获得的token，多次重连复用同一个token
+// 2. 网关拒绝token（握手401/403、STOMP ERROR帧状态码401/403）时作废并重新登录
+// 3. 登录限流：两次登录之间保持最小间隔，且每小时登录次数有上限，防止账号被锁
+// 4. 同一时刻只有一个登录在进行，其他调用方等待其结果；限流等待和登录期间不持有锁
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+	logger "wealth-bond-quote-service/pkg/log"
+
+	"github.com/go-stomp/stomp/v3"
+)
+
+// ErrAuthRejected ATS拒绝了当前token
+var ErrAuthRejected = errors.New("ATS认证被拒绝")
+
+const (
+	defaultLoginMinInterval = 10 * time.Second // 默认两次登录最小间隔
+	defaultMaxLoginsPerHour = 10               // 默认每小时最多登录次数
+	loginWindow             = time.Hour        // 登录次数统计窗口
+)
+
+// IsAuthError 判断错误是否由认证失败引起
+func IsAuthError(err error) bool {
+	return errors.Is(err, ErrAuthRejected)
+}
+
+// classifyStompError 识别STOMP ERROR帧中的认证失败，包装为 ErrAuthRejected
+// 按帧携带的状态码判断（与ATS接口相同的 401/403），不按描述文字匹配
+func classifyStompError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var message string
+	var body []byte
+	var stompErr *stomp.Error
+	var stompErrVal stomp.Error
+	switch {
+	case errors.As(err, &stompErr):
+		message = stompErr.Message
+		if stompErr.Frame != nil {
+			body = stompErr.Frame.Body
+		}
+	case errors.As(err, &stompErrVal):
+		message = stompErrVal.Message
+		if stompErrVal.Frame != nil {
+			body = stompErrVal.Frame.Body
+		}
+	default:
+		return err
+	}
+
+	if atsErrorCodes[stompErrorCode(message, body)] == ErrAuthRejected {
+		return fmt.Errorf("%w: %v", ErrAuthRejected, err)
+	}
+	return err
+}
+
+// stompErrorCode 取ERROR帧的状态码：帧体为ATS响应格式时取 code，否则取 message 开头的HTTP状态码，没有时返回0
+func stompErrorCode(message string, body []byte) int {
+	var resp struct {
+		Code int `json:"code"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Code != 0 {
+		return resp.Code
+	}
+	if fields := strings.Fields(message); len(fields) > 0 && len(fields[0]) == 3 {
+		if code, err := strconv.Atoi(fields[0]); err == nil {
+			return code
+		}
+	}
+	return 0
+}
+
+// TokenManager ATS访问令牌管理器
+type TokenManager struct {
+	cfg       *config.AdenATSConfig
+	transport *AtsTransport // 登录使用的HTTP客户端，为nil时使用默认配置
+
+	mu         sync.Mutex
+	token      string
+	obtainedAt time.Time
+	logins     []time.Time // 窗口内的登录时间
+	inflight   *loginCall  // 进行中的登录，没有时为nil
+
+	minInterval  time.Duration
+	maxPerWindow int
+
+	// login 执行一次登录，默认走加密登录流程，测试时可替换
+	login func(ctx context.Context) (string, error)
+}
+
+// NewTokenManager 创建令牌管理器
+func NewTokenManager(cfg *config.AdenATSConfig) *TokenManager {
+	m := &TokenManager{
+		cfg:          cfg,
+		minInterval:  time.Duration(cfg.LoginMinInterval) * time.Second,
+		maxPerWindow: cfg.MaxLoginsPerHour,
+	}
+	if m.minInterval <= 0 {
+		m.minInterval = defaultLoginMinInterval
+	}
+	if m.maxPerWindow <= 0 {
+		m.maxPerWindow = defaultMaxLoginsPerHour
+	}
+	m.login = m.encryptedLogin
+	return m
+}
+
+// encryptedLogin 使用RSA+AES加密登录接口获取token
+func (m *TokenManager) encryptedLogin(ctx context.Context) (string, error) {
+	cfg := m.cfg
+	client := &StompClient{
+		Timeout:   time.Duration(cfg.Timeout) * time.Second,
+		Transport: m.transport,
+	}
+	if err := client.Login(cfg.Username, cfg.Password, cfg.SmsCode, cfg.PublicKey, cfg.BaseURL, cfg.ClientId); err != nil {
+		return "", err
+	}
+	return client.Token, nil
+}
+
+// loginCall 一次进行中的登录，结束后关闭 done
+type loginCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// Token 返回可用的token，没有缓存时登录获取
+// 登录受限流保护，超出频率时阻塞等待，直到允许登录或上下文取消
+// 已有登录在进行时等待其结果，不重复登录
+func (m *TokenManager) Token(ctx context.Context) (string, error) {
+	for {
+		m.mu.Lock()
+		if m.token != "" {
+			token := m.token
+			m.mu.Unlock()
+			return token, nil
+		}
+		if call := m.inflight; call != nil {
+			m.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-call.done:
+			}
+			// 发起登录的调用方被取消时由本调用方重新登录
+			if errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded) {
+				continue
+			}
+			return call.token, call.err
+		}
+		call := &loginCall{done: make(chan struct{})}
+		m.inflight = call
+		wait := m.nextLoginDelay(time.Now())
+		m.mu.Unlock()
+
+		call.token, call.err = m.loginAfter(ctx, wait)
+
+		m.mu.Lock()
+		m.inflight = nil
+		if call.err == nil {
+			m.token = call.token
+			m.obtainedAt = time.Now()
+		}
+		m.mu.Unlock()
+		close(call.done)
+		return call.token, call.err
+	}
+}
+
+// loginAfter 等待wait后登录，不持有锁
+func (m *TokenManager) loginAfter(ctx context.Context, wait time.Duration) (string, error) {
+	if wait > 0 {
+		logger.Warn("登录过于频繁，%s 后再次登录", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	m.mu.Lock()
+	m.logins = append(m.logins, time.Now())
+	m.mu.Unlock()
+	token, err := m.login(ctx)
+	if err != nil {
+		return "", fmt.Errorf("登录失败: %w", err)
+	}
+	logger.Info("登录成功，获取到Token: %s", maskToken(token))
+	return token, nil
+}
+
+// Invalidate 作废指定token，下次获取时重新登录
+// 只作废与当前缓存一致的token，避免并发下误删刚刷新的token
+func (m *TokenManager) Invalidate(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if token != "" && token == m.token {
+		logger.Warn("Token被拒绝，已作废（使用时长 %s）", time.Since(m.obtainedAt).Truncate(time.Second))
+		m.token = ""
+	}
+}
+
+// nextLoginDelay 计算距离下次允许登录还需等待的时间
+func (m *TokenManager) nextLoginDelay(now time.Time) time.Duration {
+	// 清理窗口外的记录
+	kept := m.logins[:0]
+	for _, t := range m.logins {
+		if now.Sub(t) < loginWindow {
+			kept = append(kept, t)
+		}
+	}
+	m.logins = kept
+
+	var wait time.Duration
+	if n := len(m.logins); n > 0 {
+		if d := m.minInterval - now.Sub(m.logins[n-1]); d > wait {
+			wait = d
+		}
+	}
+	if len(m.logins) >= m.maxPerWindow {
+		oldest := m.logins[len(m.logins)-m.maxPerWindow]
+		if d := loginWindow - now.Sub(oldest); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	config "wealth-bond-quote-service/internal/conf"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// newTestTokenManager 登录次数计数的令牌管理器，release 非nil时登录阻塞到其关闭
+func newTestTokenManager(release chan struct{}) (*TokenManager, *atomic.Int32) {
+	m := NewTokenManager(&config.AdenATSConfig{})
+	var logins atomic.Int32
+	m.login = func(ctx context.Context) (string, error) {
+		n := logins.Add(1)
+		if release != nil {
+			<-release
+		}
+		return fmt.Sprintf("T%d", n), nil
+	}
+	return m, &logins
+}
+
+func TestTokenManagerRateLimit(t *testing.T) {
+	m, logins := newTestTokenManager(nil)
+	m.minInterval = 100 * time.Millisecond
+	m.maxPerWindow = 2
+	ctx := context.Background()
+
+	token, err := m.Token(ctx)
+	if err != nil || token != "T1" {
+		t.Fatalf("Token = %s, err = %v", token, err)
+	}
+	// 缓存的token直接复用
+	if token, _ := m.Token(ctx); token != "T1" || logins.Load() != 1 {
+		t.Fatalf("复用 Token = %s, 登录 %d 次", token, logins.Load())
+	}
+
+	// 作废后重新登录，与上次登录保持最小间隔
+	m.Invalidate("T1")
+	start := time.Now()
+	if token, err := m.Token(ctx); err != nil || token != "T2" {
+		t.Fatalf("重新登录 Token = %s, err = %v", token, err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("重新登录间隔 %s 小于最小间隔", elapsed)
+	}
+	// 作废旧token不影响新token
+	m.Invalidate("T1")
+	if token, _ := m.Token(ctx); token != "T2" {
+		t.Fatalf("作废旧token后 Token = %s", token)
+	}
+
+	// 窗口内登录次数用完，等待期间不持有锁，其他调用方不被阻塞
+	m.Invalidate("T2")
+	waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Token(waitCtx)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start = time.Now()
+	m.Invalidate("T2")
+	shortCtx, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if _, err := m.Token(shortCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("等待登录结果 err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("限流等待期间被阻塞 %s", elapsed)
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) || logins.Load() != 2 {
+		t.Fatalf("超出登录次数 err = %v, 登录 %d 次", err, logins.Load())
+	}
+}
+
+func TestTokenManagerSingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	m, logins := newTestTokenManager(release)
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i], _ = m.Token(context.Background())
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for _, token := range tokens {
+		if token != "T1" {
+			t.Fatalf("Token = %v", tokens)
+		}
+	}
+	if logins.Load() != 1 {
+		t.Fatalf("并发获取登录 %d 次", logins.Load())
+	}
+
+	// 发起登录的调用方取消后，等待的调用方自己登录
+	m, logins = newTestTokenManager(nil)
+	m.minInterval = 50 * time.Millisecond
+	if _, err := m.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m.Invalidate("T1")
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := m.Token(ctx)
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	second := make(chan string, 1)
+	go func() {
+		token, _ := m.Token(context.Background())
+		second <- token
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("取消的调用方 err = %v", err)
+	}
+	if token := <-second; token != "T2" || logins.Load() != 2 {
+		t.Fatalf("等待的调用方 Token = %s, 登录 %d 次", token, logins.Load())
+	}
+}
+
+func TestClassifyStompError(t *testing.T) {
+	errorFrame := func(message, body string) error {
+		f := frame.New(frame.ERROR, frame.Message, message)
+		f.Body = []byte(body)
+		return stomp.Error{Message: message, Frame: f}
+	}
+	tests := []struct {
+		name string
+		err  error
+		auth bool
+	}{
+		{"ATS状态码401", errorFrame("token expired", `{"code":401,"msg":"token已失效，请重新登录"}`), true},
+		{"ATS状态码403", errorFrame("forbidden", `{"code":403,"msg":"无权限"}`), true},
+		{"HTTP状态码", errorFrame("401 Unauthorized", ""), true},
+		{"描述含认证字样", errorFrame("auth service unavailable", "token store timeout"), false},
+		{"服务端错误", errorFrame("server error", `{"code":500,"msg":"登录服务异常"}`), false},
+		{"未知目的地", errorFrame("unknown destination", "/topic/x"), false},
+		{"非STOMP错误", errors.New("401 unauthorized"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsAuthError(classifyStompError(tt.err)); got != tt.auth {
+				t.Fatalf("认证失败 = %v, 期望 %v", got, tt.auth)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package service
+
+// 行情断流监控
+// ATS可能只回心跳、不再推送行情，连接层无法察觉。
+// 监控按订阅和ISIN记录最近一次收到消息的时间，交易时段内超时未收到时：
+// 1. 订阅整体断流：强制重连并告警
+// 2. 单个ISIN长时间无报价：只告警
+// 非交易时段（夜间、周末、休市日）不检查，开市后从开市时间起算
+// ISIN只在报价所属的业务日期内监控，到期或停止报价的债券进入下一业务日期后不再告警
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+	"wealth-bond-quote-service/pkg/dtalk"
+	logger "wealth-bond-quote-service/pkg/log"
+)
+
+const (
+	defaultFeedTimeout   = 60 * time.Second
+	defaultCheckInterval = 10 * time.Second
+	defaultAlertInterval = 10 * time.Minute
+	alertSendTimeout     = 30 * time.Second
+	maxAlertISINs        = 20 // 告警中最多列出的ISIN个数
+)
+
+// ErrFeedStale 交易时段内订阅超时未收到消息
+var ErrFeedStale = errors.New("行情推送中断")
+
+// feedState 单个订阅的监控状态
+type feedState struct {
+	lastMessage time.Time       // 最近一次收到消息的时间
+	since       time.Time       // 最近一次订阅成功或强制重连的时间，从此刻起重新计时
+	reconnect   func(err error) // 断流时的处理，通常是强制重连
+	stale       bool            // 已判定断流，收到消息后恢复
+}
+
+// FeedWatchdog 行情断流监控
+type FeedWatchdog struct {
+	cal           *calendar.Calendar
+	feedTimeout   time.Duration
+	isinTimeout   time.Duration
+	checkInterval time.Duration
+	alertInterval time.Duration
+
+	mu        sync.Mutex
+	feeds     map[string]*feedState
+	isins     map[string]time.Time // ISIN -> 最近一次报价时间，只保留当前业务日期有报价的
+	lastAlert map[string]time.Time // 告警键 -> 最近一次告警时间
+
+	// alert 发送运维告警，默认钉钉文本消息，测试时可替换
+	alert func(ctx context.Context, content string) error
+}
+
+// NewFeedWatchdog 创建行情断流监控
+func NewFeedWatchdog(cfg *config.WatchdogConfig, cal *calendar.Calendar) *FeedWatchdog {
+	w := &FeedWatchdog{
+		cal:           cal,
+		feedTimeout:   time.Duration(cfg.FeedTimeout) * time.Second,
+		isinTimeout:   time.Duration(cfg.IsinTimeout) * time.Second,
+		checkInterval: time.Duration(cfg.CheckInterval) * time.Second,
+		alertInterval: time.Duration(cfg.AlertInterval) * time.Second,
+		feeds:         make(map[string]*feedState),
+		isins:         make(map[string]time.Time),
+		lastAlert:     make(map[string]time.Time),
+		alert:         dtalk.DTalkSendTextMsg,
+	}
+	if w.feedTimeout <= 0 {
+		w.feedTimeout = defaultFeedTimeout
+	}
+	if w.checkInterval <= 0 {
+		w.checkInterval = defaultCheckInterval
+	}
+	if w.alertInterval <= 0 {
+		w.alertInterval = defaultAlertInterval
+	}
+	return w
+}
+
+// WatchFeed 登记需要监控的订阅，reconnect 在断流时被调用
+func (w *FeedWatchdog) WatchFeed(feed string, reconnect func(err error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.feed(feed).reconnect = reconnect
+}
+
+// FeedSubscribed 订阅成功，从此刻起重新计时
+func (w *FeedWatchdog) FeedSubscribed(feed string, t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.feed(feed).since = t
+}
+
+// FeedMessage 记录订阅收到消息；此前判定为断流时发送恢复通知
+func (w *FeedWatchdog) FeedMessage(feed string, t time.Time) {
+	w.mu.Lock()
+	f := w.feed(feed)
+	f.lastMessage = t
+	recovered := f.stale
+	f.stale = false
+	w.mu.Unlock()
+
+	if recovered {
+		logger.Info("行情推送已恢复: %s", feed)
+		w.sendAlert(fmt.Sprintf("【债券行情恢复】%s 已恢复推送，时间: %s", feed, t.Format("2006-01-02 15:04:05")))
+	}
+}
+
+// QuoteReceived 记录ISIN收到报价
+func (w *FeedWatchdog) QuoteReceived(isin string, t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.After(w.isins[isin]) {
+		w.isins[isin] = t
+	}
+}
+
+// LastMessage 订阅最近一次收到消息的时间
+func (w *FeedWatchdog) LastMessage(feed string) (time.Time, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, ok := w.feeds[feed]
+	if !ok || f.lastMessage.IsZero() {
+		return time.Time{}, false
+	}
+	return f.lastMessage, true
+}
+
+// Run 定时检查，直到上下文取消
+func (w *FeedWatchdog) Run(ctx context.Context) {
+	logger.Info("启动行情断流监控，订阅超时: %s，ISIN超时: %s", w.feedTimeout, w.isinTimeout)
+	ticker := time.NewTicker(w.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.Check(now)
+		}
+	}
+}
+
+// Check 执行一轮检查
+func (w *FeedWatchdog) Check(now time.Time) {
+	sessionStart, open := w.cal.SessionStart(now)
+	if !open {
+		return
+	}
+
+	type staleFeed struct {
+		name      string
+		silence   time.Duration
+		reconnect func(err error)
+	}
+	var feeds []staleFeed
+	var isins []string
+
+	w.mu.Lock()
+	for name, f := range w.feeds {
+		ref := latest(f.lastMessage, f.since, sessionStart)
+		if silence := now.Sub(ref); silence > w.feedTimeout {
+			// 重连后重新计时，避免每轮检查都触发重连
+			f.since = now
+			f.stale = true
+			feeds = append(feeds, staleFeed{name: name, silence: now.Sub(latest(f.lastMessage, sessionStart)), reconnect: f.reconnect})
+		}
+	}
+	today := w.cal.BusinessDate(now)
+	for isin, last := range w.isins {
+		if w.cal.BusinessDate(last).Before(today) {
+			delete(w.isins, isin)
+			continue
+		}
+		if w.isinTimeout > 0 && now.Sub(latest(last, sessionStart)) > w.isinTimeout {
+			isins = append(isins, isin)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, f := range feeds {
+		err := fmt.Errorf("%w: %s 已 %s 未收到消息", ErrFeedStale, f.name, f.silence.Round(time.Second))
+		logger.Error("%v，强制重连", err)
+		if f.reconnect != nil {
+			f.reconnect(err)
+		}
+		w.sendAlertLimited("feed:"+f.name, now, fmt.Sprintf("【债券行情告警】%s 已 %s 未收到推送，已强制重连。时间: %s",
+			f.name, f.silence.Round(time.Second), now.Format("2006-01-02 15:04:05")))
+	}
+
+	if len(isins) > 0 {
+		sort.Strings(isins)
+		logger.Warn("%d 个ISIN超过 %s 无报价", len(isins), w.isinTimeout)
+		list := isins
+		if len(list) > maxAlertISINs {
+			list = list[:maxAlertISINs]
+		}
+		content := fmt.Sprintf("【债券行情告警】%d 个ISIN超过 %s 无报价: %s", len(isins), w.isinTimeout, strings.Join(list, ", "))
+		if len(isins) > len(list) {
+			content += " 等"
+		}
+		w.sendAlertLimited("isin", now, content)
+	}
+}
+
+// feed 获取订阅状态，不存在时创建，调用方需持有锁
+func (w *FeedWatchdog) feed(name string) *feedState {
+	f, ok := w.feeds[name]
+	if !ok {
+		f = &feedState{}
+		w.feeds[name] = f
+	}
+	return f
+}
+
+// sendAlertLimited 同一告警键在 alertInterval 内只发送一次
+func (w *FeedWatchdog) sendAlertLimited(key string, now time.Time, content string) {
+	w.mu.Lock()
+	if last, ok := w.lastAlert[key]; ok && now.Sub(last) < w.alertInterval {
+		w.mu.Unlock()
+		return
+	}
+	w.lastAlert[key] = now
+	w.mu.Unlock()
+	w.sendAlert(content)
+}
+
+// sendAlert 异步发送告警，不阻塞行情处理
+func (w *FeedWatchdog) sendAlert(content string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), alertSendTimeout)
+		defer cancel()
+		if err := w.alert(ctx, content); err != nil {
+			logger.Error("发送行情告警失败: %v", err)
+		}
+	}()
+}
+
+// latest 返回最晚的时间
+func latest(times ...time.Time) time.Time {
+	var t time.Time
+	for _, v := range times {
+		if v.After(t) {
+			t = v
+		}
+	}
+	return t
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+	"wealth-bond-quote-service/internal/calendar"
+	config "wealth-bond-quote-service/internal/conf"
+)
+
+// newTestWatchdog 创建告警写入通道的监控
+func newTestWatchdog(t *testing.T, calCfg *config.CalendarConfig, cfg *config.WatchdogConfig) (*FeedWatchdog, chan string) {
+	t.Helper()
+	cal, err := calendar.New(calCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewFeedWatchdog(cfg, cal)
+	alerts := make(chan string, 10)
+	w.alert = func(_ context.Context, content string) error {
+		alerts <- content
+		return nil
+	}
+	return w, alerts
+}
+
+func expectAlert(t *testing.T, alerts chan string, contains string) {
+	t.Helper()
+	select {
+	case a := <-alerts:
+		if !strings.Contains(a, contains) {
+			t.Fatalf("告警内容 = %q, 期望包含 %q", a, contains)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("未收到告警: %s", contains)
+	}
+}
+
+func expectNoAlert(t *testing.T, alerts chan string) {
+	t.Helper()
+	select {
+	case a := <-alerts:
+		t.Fatalf("不应告警: %s", a)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFeedWatchdogCheck(t *testing.T) {
+	w, alerts := newTestWatchdog(t,
+		&config.CalendarConfig{Timezone: "Asia/Shanghai", Sessions: []string{"09:00-17:00"}},
+		&config.WatchdogConfig{FeedTimeout: 60, IsinTimeout: 600, AlertInterval: 600})
+	loc := w.cal.Location()
+	day := func(h, m int) time.Time { return time.Date(2025, 9, 30, h, m, 0, 0, loc) } // 周二
+
+	var reconnects []error
+	w.WatchFeed("feed", func(err error) { reconnects = append(reconnects, err) })
+
+	// 前一天收盘前收到最后一条消息，夜间不检查
+	w.FeedMessage("feed", day(-7, 0))
+	w.Check(day(3, 0))
+	if len(reconnects) != 0 {
+		t.Fatal("非交易时段不应重连")
+	}
+
+	// 开市后从开市时间起算
+	w.Check(day(9, 0).Add(30 * time.Second))
+	if len(reconnects) != 0 {
+		t.Fatal("开市宽限期内不应重连")
+	}
+	w.Check(day(9, 2))
+	if len(reconnects) != 1 || !errors.Is(reconnects[0], ErrFeedStale) {
+		t.Fatalf("断流应强制重连一次, 实际: %v", reconnects)
+	}
+	expectAlert(t, alerts, "未收到推送")
+
+	// 重连后重新计时；再次断流时重连，但告警在间隔内只发一次
+	w.Check(day(9, 2).Add(30 * time.Second))
+	if len(reconnects) != 1 {
+		t.Fatal("重连后应重新计时")
+	}
+	w.Check(day(9, 4))
+	if len(reconnects) != 2 {
+		t.Fatalf("持续断流应再次重连, 实际 %d 次", len(reconnects))
+	}
+	expectNoAlert(t, alerts)
+
+	// 恢复推送后发送恢复通知
+	w.FeedMessage("feed", day(9, 5))
+	expectAlert(t, alerts, "恢复")
+	if last, ok := w.LastMessage("feed"); !ok || !last.Equal(day(9, 5)) {
+		t.Fatalf("最近消息时间 = %v", last)
+	}
+
+	// 单个ISIN超时只告警不重连
+	w.QuoteReceived("HK0000000001", day(9, 0))
+	w.QuoteReceived("HK0000000002", day(9, 5))
+	for i := 6; i <= 11; i++ {
+		w.FeedMessage("feed", day(9, i))
+	}
+	w.Check(day(9, 11))
+	expectAlert(t, alerts, "HK0000000001")
+	if len(reconnects) != 2 {
+		t.Fatal("ISIN无报价不应重连")
+	}
+
+	// 下一业务日期不再监控前一天之后没有报价的ISIN（到期、停止报价）
+	next := func(h, m int) time.Time { return day(h, m).AddDate(0, 0, 1) }
+	w.FeedMessage("feed", next(9, 30))
+	w.Check(next(9, 30))
+	expectNoAlert(t, alerts)
+	w.QuoteReceived("HK0000000003", next(9, 31))
+	w.FeedMessage("feed", next(9, 45))
+	w.Check(next(9, 45))
+	expectAlert(t, alerts, "HK0000000003")
+}
+
+func TestWatchdogForcesReconnect(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+
+	w, alerts := newTestWatchdog(t, &config.CalendarConfig{Weekdays: []int{0, 1, 2, 3, 4, 5, 6}}, &config.WatchdogConfig{})
+	w.feedTimeout = 200 * time.Millisecond
+	w.checkInterval = 50 * time.Millisecond
+
+	rawChan := make(chan *RawMessage, 10)
+	session := newTestSession(newTestATSConfig(srv), rawChan)
+	session.SetWatchdog(w)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	done := make(chan error, 1)
+	go func() { done <- session.Run(ctx) }()
+
+	// 只有心跳、没有行情：监控强制重连，复用token重新订阅
+	if err := srv.WaitSubscribes(2, 5*time.Second); err != nil {
+		t.Fatalf("断流后重新订阅: %v", err)
+	}
+	if got := srv.Logins(); got != 1 {
+		t.Fatalf("登录次数 = %d, 期望 1", got)
+	}
+	if !errors.Is(session.LastError(), ErrFeedStale) {
+		t.Fatalf("会话中断原因 = %v, 期望断流", session.LastError())
+	}
+	expectAlert(t, alerts, BondQuoteDestination)
+
+	// 恢复推送
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	for srv.Push(atsmock.OrderBookMessage("MSG1", "HK0000000001", time.Now(), level, nil)) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	<-rawChan
+	expectAlert(t, alerts, "恢复")
+
+	cancel()
+	<-done
+}
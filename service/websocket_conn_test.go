@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/websocket"
+)
+
+// dialTestWs 启动一个WebSocket服务端，由serve驱动服务端连接，返回客户端连接
+func dialTestWs(t *testing.T, serve func(conn *websocket.Conn)) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serve(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("连接测试服务失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestWebSocketNetConnReadLargeMessage(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 10000) // 100KB，远大于读缓冲
+	conn := dialTestWs(t, func(c *websocket.Conn) {
+		c.WriteMessage(websocket.TextMessage, payload)
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	})
+
+	adapter := NewWebSocketNetConn(conn)
+	var got []byte
+	buf := make([]byte, 1000)
+	for {
+		n, err := adapter.Read(buf)
+		if n > len(buf) {
+			t.Fatalf("Read返回 %d 字节，超过缓冲区 %d", n, len(buf))
+		}
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("读取失败: %v", err)
+		}
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("读取内容长度 = %d, 期望 %d", len(got), len(payload))
+	}
+}
+
+func TestWebSocketNetConnFragmentedFrames(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 64*1024)
+	first := append([]byte("MESSAGE\ndestination:/a\n\nsmall\x00MESSAGE\ndestination:/b\n\n"), body[:1000]...)
+	second := append(append([]byte(nil), body[1000:]...), 0)
+
+	conn := dialTestWs(t, func(c *websocket.Conn) {
+		// 第一条消息：完整的小帧 + 大帧的开头；第二条：大帧剩余部分（二进制帧）；中间插入空消息
+		c.WriteMessage(websocket.TextMessage, first)
+		c.WriteMessage(websocket.TextMessage, nil)
+		c.WriteMessage(websocket.BinaryMessage, second)
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	})
+
+	reader := frame.NewReader(NewWebSocketNetConn(conn))
+	f1, err := reader.Read()
+	if err != nil {
+		t.Fatalf("读取第一帧失败: %v", err)
+	}
+	if got := string(f1.Body); got != "small" {
+		t.Fatalf("第一帧内容 = %q", got)
+	}
+
+	f2, err := reader.Read()
+	if err != nil {
+		t.Fatalf("读取第二帧失败: %v", err)
+	}
+	if f2.Header.Get(frame.Destination) != "/b" || !bytes.Equal(f2.Body, body) {
+		t.Fatalf("第二帧内容长度 = %d, 期望 %d", len(f2.Body), len(body))
+	}
+
+	if _, err := reader.Read(); err != io.EOF {
+		t.Fatalf("对端正常关闭后应返回io.EOF, 实际: %v", err)
+	}
+}
+
+func TestWebSocketNetConnReadError(t *testing.T) {
+	conn := dialTestWs(t, func(c *websocket.Conn) {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "boom"))
+	})
+
+	_, err := NewWebSocketNetConn(conn).Read(make([]byte, 16))
+	if err == nil || errors.Is(err, io.EOF) {
+		t.Fatalf("异常关闭应返回错误, 实际: %v", err)
+	}
+}
+
+func TestStompLargeOrderBookMessage(t *testing.T) {
+	srv := atsmock.NewServer()
+	defer srv.Close()
+	cfg := newTestATSConfig(srv)
+
+	client := &StompClient{Timeout: 5 * time.Second}
+	if err := client.Login(cfg.Username, cfg.Password, cfg.SmsCode, cfg.PublicKey, cfg.BaseURL, cfg.ClientId); err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	if err := client.ConnectWebSocket(cfg.WssURL); err != nil {
+		t.Fatalf("WebSocket连接失败: %v", err)
+	}
+	defer client.Conn.Close()
+	if err := client.ConnectStomp(); err != nil {
+		t.Fatalf("STOMP连接失败: %v", err)
+	}
+	sub, err := client.Subscribe()
+	if err != nil {
+		t.Fatalf("订阅失败: %v", err)
+	}
+	if err := srv.WaitSubscribes(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 500档报价，消息体远大于STOMP默认4KB读缓冲，模拟网关按4KB拆成多条WebSocket消息
+	levels := make([]atsmock.Level, 500)
+	for i := range levels {
+		levels[i] = atsmock.Level{QuoteOrderNo: "Q" + strings.Repeat("0", 20), BrokerID: "B", Price: 100, Yield: 4, OrderQty: 1000000}
+	}
+	body := atsmock.OrderBookMessage("BIG1", "HK0000000001", time.Now(), levels, levels)
+	srv.Push(body)
+
+	rawChan := make(chan *RawMessage, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Listen(ctx, sub, rawChan)
+
+	select {
+	case raw := <-rawChan:
+		if !bytes.Equal(raw.Body, body) {
+			t.Fatalf("收到消息长度 = %d, 期望 %d", len(raw.Body), len(body))
+		}
+		if _, err := ParseBondQuote(raw.Body); err != nil {
+			t.Fatalf("大消息解析失败: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("未收到大消息")
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+// 写库失败处理
+// 1. 暂时性错误（连接断开、死锁、锁等待超时、表不存在）按退避重试，重试耗尽后拒绝整批等待重新投递
+// 2. 其他错误二分拆批，找出单独写入仍失败的记录，其余健康的记录正常写入
+// 3. 找出的记录隔离到死信表（可通过死信接口修复后重新处理），并确认消息，避免反复投递卡住整批
+// 拆批后所有记录都失败、且错误不属于数据错误时，视为整体故障而不是个别坏数据，拒绝整批
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	logger "wealth-bond-quote-service/pkg/log"
+)
+
+const (
+	defaultWriteRetries      = 3
+	defaultWriteRetryBackoff = 100 * time.Millisecond
+	maxWriteRetryBackoff     = 5 * time.Second
+)
+
+// MySQL 错误码
+var (
+	// 暂时性错误：锁等待超时、死锁、表不存在、连接数过多、连接断开
+	mysqlTransientErrors = map[uint16]bool{1205: true, 1213: true, 1146: true, 1040: true, 2006: true, 2013: true}
+	// 数据错误：非空、重复键、超出范围、截断、时间格式、字符集、超长、JSON 格式、约束检查
+	mysqlDataErrors = map[uint16]bool{1048: true, 1062: true, 1264: true, 1265: true, 1292: true, 1366: true, 1406: true, 3140: true, 3819: true, 4025: true}
+)
+
+// isTransientWriteError 是否为重试可能成功的暂时性错误
+func isTransientWriteError(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return mysqlTransientErrors[myErr.Number]
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr) {
+		return true
+	}
+	// SQLite
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "no such table")
+}
+
+// isTableMissing 是否为表不存在
+func isTableMissing(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1146
+	}
+	return strings.Contains(err.Error(), "no such table")
+}
+
+// isDataWriteError 是否为个别记录的数据错误
+func isDataWriteError(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return mysqlDataErrors[myErr.Number]
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "constraint failed") || strings.Contains(msg, "datatype mismatch")
+}
+
+// poisonQuote 单独写入仍失败的记录
+type poisonQuote struct {
+	pq  *ParsedQuote
+	err error
+}
+
+// writeWithRetry 写入一批，暂时性错误按退避重试
+func (r *sinkRunner) writeWithRetry(batch []*ParsedQuote) error {
+	backoff := r.opts.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := r.sink.Write(batch)
+		if err == nil || !isTransientWriteError(err) || attempt > r.opts.MaxRetries {
+			return err
+		}
+		r.retried.Add(1)
+		logger.Warn("写入%s暂时失败，%s 后第%d次重试: %v", r.sink.Name(), backoff, attempt, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxWriteRetryBackoff)
+	}
+}
+
+// bisect 二分拆批写入，返回写入成功、暂时性失败和单独写入仍失败的记录
+func (r *sinkRunner) bisect(batch []*ParsedQuote, err error) (ok, transient []*ParsedQuote, poison []poisonQuote) {
+	if len(batch) == 1 {
+		return nil, nil, []poisonQuote{{pq: batch[0], err: err}}
+	}
+	mid := len(batch) / 2
+	for _, half := range [][]*ParsedQuote{batch[:mid], batch[mid:]} {
+		err := r.writeWithRetry(half)
+		switch {
+		case err == nil:
+			ok = append(ok, half...)
+		case isTransientWriteError(err):
+			transient = append(transient, half...)
+		default:
+			o, t, p := r.bisect(half, err)
+			ok, transient, poison = append(ok, o...), append(transient, t...), append(poison, p...)
+		}
+	}
+	return ok, transient, poison
+}
+
+// flushSink 写入一批并按结果确认消息
+func (bqs *BondQuoteService) flushSink(r *sinkRunner, batch []*ParsedQuote) {
+	err := r.writeWithRetry(batch)
+	if err == nil {
+		r.succeed(batch)
+		return
+	}
+	if isTransientWriteError(err) {
+		logger.Error("批量写入%s失败，重试%d次后放弃: %v", r.sink.Name(), r.opts.MaxRetries, err)
+		r.fail(batch)
+		return
+	}
+
+	logger.Warn("批量写入%s失败，拆分 %d 条查找问题记录: %v", r.sink.Name(), len(batch), err)
+	ok, transient, poison := r.bisect(batch, err)
+	r.succeed(ok)
+	r.fail(transient)
+	if len(ok) == 0 && len(transient) == 0 && !isDataWriteError(err) {
+		// 所有记录单独写入都失败，是整体故障而不是个别坏数据
+		logger.Error("批量写入%s失败，所有记录均无法写入: %v", r.sink.Name(), err)
+		for _, p := range poison {
+			r.fail([]*ParsedQuote{p.pq})
+		}
+		return
+	}
+	for _, p := range poison {
+		bqs.quarantine(r, p)
+	}
+}
+
+// succeed 写入成功，必需目标确认消息
+func (r *sinkRunner) succeed(batch []*ParsedQuote) {
+	r.written.Add(int64(len(batch)))
+	if r.opts.Required {
+		for _, pq := range batch {
+			ackMessage(pq.Ack)
+		}
+	}
+}
+
+// fail 写入失败，必需目标拒绝消息等待重新投递
+func (r *sinkRunner) fail(batch []*ParsedQuote) {
+	r.failed.Add(int64(len(batch)))
+	if r.opts.Required {
+		for _, pq := range batch {
+			nackMessage(pq.Ack)
+		}
+	}
+}
+
+// quarantine 隔离无法写入的记录：必需目标写入死信表后确认，非必需目标只记录日志
+func (bqs *BondQuoteService) quarantine(r *sinkRunner, p poisonQuote) {
+	isin, id := p.pq.Payload.SecurityID, p.pq.Meta.Data.MessageID
+	if !r.opts.Required {
+		logger.Error("写入%s失败，丢弃问题记录 %s/%s: %v", r.sink.Name(), id, isin, p.err)
+		r.failed.Add(1)
+		return
+	}
+	// 保存原始消息，重新处理时按原格式解析；没有原始消息时（快照修正等）保存解析后的消息
+	body := p.pq.Raw
+	var err error
+	if len(body) == 0 {
+		body, err = json.Marshal(p.pq.Meta)
+	}
+	if err != nil || bqs.DeadChan == nil {
+		logger.Error("写入%s失败，问题记录 %s/%s 无法隔离: %v", r.sink.Name(), id, isin, p.err)
+		r.fail([]*ParsedQuote{p.pq})
+		return
+	}
+	logger.Error("写入%s失败，问题记录 %s/%s 已隔离到死信: %v", r.sink.Name(), id, isin, p.err)
+	bqs.DeadChan <- &DeadMessage{
+		Body:       body,
+		ReceivedAt: p.pq.ReceivedAt,
+		Reason:     fmt.Sprintf("写入%s失败: %v", r.sink.Name(), p.err),
+	}
+	r.quarantined.Add(1)
+	ackMessage(p.pq.Ack)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"wealth-bond-quote-service/internal/atsmock"
+)
+
+// flakySink 前 transient 次写入返回连接错误，之后包含坏ISIN的批次返回数据错误
+type flakySink struct {
+	*MemorySink
+	mu        sync.Mutex
+	transient int
+	badISIN   string
+	writes    int
+}
+
+func (s *flakySink) Write(batch []*ParsedQuote) error {
+	s.mu.Lock()
+	s.writes++
+	if s.transient > 0 {
+		s.transient--
+		s.mu.Unlock()
+		return fmt.Errorf("insert: %w", driver.ErrBadConn)
+	}
+	s.mu.Unlock()
+	for _, pq := range batch {
+		if pq.Payload.SecurityID == s.badISIN {
+			return errors.New("Error 1366: Incorrect decimal value")
+		}
+	}
+	return s.MemorySink.Write(batch)
+}
+
+func TestWriteRetryAndQuarantine(t *testing.T) {
+	level := []atsmock.Level{{QuoteOrderNo: "Q1", BrokerID: "B1", Price: 100, Yield: 4, OrderQty: 1000000}}
+	var bodies [][]byte
+	run := func(sink QuoteSink, isins []string) ([]*countingAck, *BondQuoteService, []*DeadMessage) {
+		t.Helper()
+		var wg sync.WaitGroup
+		parsedChan := make(chan *ParsedQuote, len(isins))
+		deadChan := make(chan *DeadMessage, len(isins))
+		bqs := NewBondQuoteService(nil, &wg, nil, parsedChan, deadChan)
+		bqs.AddSink(sink, SinkOptions{Required: true, RetryBackoff: time.Millisecond})
+		var acks []*countingAck
+		bodies = bodies[:0]
+		for i, isin := range isins {
+			// 内层报价为嵌套对象的格式，解析后会规范为字符串
+			body := nestedQuoteData(t, atsmock.OrderBookMessage(fmt.Sprintf("W%d", i+1), isin, time.Now(), level, nil))
+			bodies = append(bodies, body)
+			pq, err := ParseBondQuote(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ack := &countingAck{}
+			pq.ReceivedAt, pq.Ack = time.Now(), ack
+			parsedChan <- pq
+			acks = append(acks, ack)
+		}
+		// 一个写库协程、整批一次写入
+		bqs.StartDBWorkers(1, len(isins), time.Hour)
+		close(parsedChan)
+		wg.Wait()
+		close(deadChan)
+		var dead []*DeadMessage
+		for d := range deadChan {
+			dead = append(dead, d)
+		}
+		return acks, bqs, dead
+	}
+
+	// 暂时性错误重试后成功
+	sink := &flakySink{MemorySink: NewMemorySink("flaky"), transient: 2}
+	acks, bqs, dead := run(sink, []string{"HK0000000001", "HK0000000002"})
+	if st := bqs.SinkStats()[0]; st.Retried != 2 || st.Written != 2 || len(dead) != 0 {
+		t.Fatalf("重试统计 = %+v, 死信 %d", st, len(dead))
+	}
+	for _, a := range acks {
+		waitAcks(t, a, 1, 0)
+	}
+
+	// 重试耗尽后拒绝整批
+	sink = &flakySink{MemorySink: NewMemorySink("down"), transient: 100}
+	acks, bqs, _ = run(sink, []string{"HK0000000001", "HK0000000002"})
+	if st := bqs.SinkStats()[0]; st.Retried != defaultWriteRetries || st.Failed != 2 || sink.writes != defaultWriteRetries+1 {
+		t.Fatalf("重试耗尽统计 = %+v, 写入 %d 次", st, sink.writes)
+	}
+	for _, a := range acks {
+		waitAcks(t, a, 0, 1)
+	}
+
+	// 坏记录被二分隔离到死信，其余正常写入
+	isins := []string{"HK0000000001", "HK0000000002", "HK0000000003", "BAD", "HK0000000004", "HK0000000005", "HK0000000006", "HK0000000007"}
+	sink = &flakySink{MemorySink: NewMemorySink("mysql"), badISIN: "BAD"}
+	acks, bqs, dead = run(sink, isins)
+	if got := len(sink.Quotes()); got != 7 {
+		t.Fatalf("写入 %d 条, 期望 7", got)
+	}
+	if st := bqs.SinkStats()[0]; st.Written != 7 || st.Quarantined != 1 || st.Failed != 0 {
+		t.Fatalf("隔离统计 = %+v", st)
+	}
+	for _, a := range acks {
+		waitAcks(t, a, 1, 0)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("死信 %d 条", len(dead))
+	}
+	// 隔离的是原始消息，可以重新解析
+	if !bytes.Equal(dead[0].Body, bodies[3]) {
+		t.Fatalf("死信内容不是原始消息: %s", dead[0].Body)
+	}
+	pq, err := ParseBondQuote(dead[0].Body)
+	if err != nil || pq.Payload.SecurityID != "BAD" || pq.Meta.Data.MessageID != "W4" {
+		t.Fatalf("死信内容 = %+v, err = %v", pq, err)
+	}
+}
+
+// nestedQuoteData 把 data.data 的报价JSON字符串改为嵌套对象
+func nestedQuoteData(t *testing.T, body []byte) []byte {
+	t.Helper()
+	var msg map[string]any
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	data := msg["data"].(map[string]any)
+	var inner any
+	if err := json.Unmarshal([]byte(data["data"].(string)), &inner); err != nil {
+		t.Fatal(err)
+	}
+	data["data"] = inner
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}